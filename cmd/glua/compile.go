@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// count is a boolean flag which counts its occurrences (e.g. -l -l).
+type count int
+
+func (c *count) String() string   { return fmt.Sprint(int(*c)) }
+func (c *count) Set(string) error { *c++; return nil }
+func (c *count) IsBoolFlag() bool { return true }
+
+// compile runs "glua compile", which precompiles Lua files like luac:
+//
+//	glua compile [options] [filenames]
+//
+// The files (or stdin, for "-") are compiled into a single binary chunk
+// which runs them in the given order; binary chunks are loaded as is. The
+// chunk is written to luac.out, unless another file is given with -o or
+// option -p is set. Options -l and -j list the chunk (see Chunk.Print and
+// Chunk.PrintJSON). With -a, the files are bytecode listings, assembled
+// by code.Assemble. Syntax errors are shown with their source lines (see
+// luac.Diagnostic).
+func compile(args []string) {
+	var (
+		flags   = flag.NewFlagSet("glua compile", flag.ExitOnError)
+		output  = flags.String("o", "luac.out", "output to file `name`")
+		parse   = flags.Bool("p", false, "parse only")
+		strip   = flags.Bool("s", false, "strip debug information")
+		verbose = flags.Bool("v", false, "show version information")
+		asJSON  = flags.Bool("j", false, "list as JSON")
+		asm     = flags.Bool("a", false, "assemble bytecode listings")
+		listing count
+	)
+	flags.Var(&listing, "l", "list (use -l -l for full listing)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glua compile [options] [filenames]")
+		fmt.Fprintln(os.Stderr, "Available options are:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "  --\tstop handling options")
+		fmt.Fprintln(os.Stderr, "  -\tstop handling options and process stdin")
+	}
+	flags.Parse(args)
+
+	if *verbose {
+		fmt.Println(version)
+		if flags.NArg() == 0 {
+			return
+		}
+	}
+	if flags.NArg() == 0 {
+		fail(fmt.Errorf("no input files given"))
+	}
+	var (
+		chunk *code.Chunk
+		err   error
+	)
+	if *asm {
+		chunk, err = assemble(flags.Args())
+	} else {
+		chunk, err = luac.Bundle(luac.Defaults, flags.Args())
+	}
+	if list, ok := err.(luac.Diagnostics); ok {
+		diagnose(list, flags.Args())
+	}
+	if err != nil {
+		fail(err)
+	}
+	switch {
+	case *asJSON:
+		if err := chunk.PrintJSON(os.Stdout); err != nil {
+			fail(err)
+		}
+	case listing > 0:
+		chunk.Print(os.Stdout, listing > 1)
+	}
+	if *parse {
+		return
+	}
+	var out bytes.Buffer
+	if _, err := chunk.Dump(&out, *strip); err != nil {
+		fail(err)
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(out.Bytes())
+	} else {
+		err = ioutil.WriteFile(*output, out.Bytes(), 0666)
+	}
+	if err != nil {
+		fail(fmt.Errorf("cannot write %s: %v", *output, err))
+	}
+}
+
+// assemble assembles the bytecode listings files ("-" for stdin) into a
+// single chunk (see code.Assemble and luac.Combine).
+func assemble(files []string) (*code.Chunk, error) {
+	chunks := make([]*code.Chunk, len(files))
+	for i, file := range files {
+		var (
+			chunk *code.Chunk
+			err   error
+		)
+		if file == "-" {
+			chunk, err = code.Assemble("=stdin", os.Stdin)
+		} else {
+			chunk, err = code.Assemble("@"+file, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks[i] = chunk
+	}
+	return luac.Combine(chunks...), nil
+}
+
+// diagnose renders the diagnostics of the compilation of files, with the
+// source lines of the files, and exits.
+func diagnose(list luac.Diagnostics, files []string) {
+	for _, d := range list {
+		var src []byte
+		for _, file := range files {
+			if file == d.File {
+				src, _ = ioutil.ReadFile(file)
+				break
+			}
+		}
+		d.Render(os.Stderr, src)
+	}
+	os.Exit(1)
+}
+
+// fail reports the error err of a subcommand and exits.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "glua: %v\n", err)
+	os.Exit(1)
+}
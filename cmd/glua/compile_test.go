@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+)
+
+func TestCompile(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"a.lua":   "local x = 1\nprint('a', x, ...)\n",
+		"b.lua":   "print('b')\nreturn 'b'\n",
+		"bad.lua": "x = = 1\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	undump := func(t *testing.T, file string) *code.Chunk {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := code.Undump("=test", b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chunk
+	}
+
+	t.Run("Output", func(t *testing.T) {
+		if _, errs, status := glua(t, dir, "", nil, "compile", "-o", "a.out", "a.lua"); status != 0 {
+			t.Fatalf("exit status %d: %s", status, errs)
+		}
+		if out, errs, _ := glua(t, dir, "", nil, "a.out", "x"); out != "a\t1\tx\n" {
+			t.Errorf("running a.out: got %q, want %q (%s)", out, "a\t1\tx\n", errs)
+		}
+		if main := undump(t, "a.out").Main; len(main.Locals) == 0 || main.Source != "@a.lua" {
+			t.Errorf("debug information missing: source %q, locals %v", main.Source, main.Locals)
+		}
+	})
+	t.Run("Default", func(t *testing.T) {
+		glua(t, dir, "", nil, "compile", "b.lua")
+		if _, err := os.Stat(filepath.Join(dir, "luac.out")); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Strip", func(t *testing.T) {
+		glua(t, dir, "", nil, "compile", "-s", "-o", "s.out", "a.lua")
+		main := undump(t, "s.out").Main
+		if len(main.Locals) != 0 || len(main.PcLine) != 0 || main.Source != "=test" {
+			t.Errorf("not stripped: source %q, %d locals, %d lines", main.Source, len(main.Locals), len(main.PcLine))
+		}
+	})
+	t.Run("Combine", func(t *testing.T) {
+		glua(t, dir, "", nil, "compile", "-o", "ab.out", "a.lua", "b.lua")
+		out, errs, status := glua(t, dir, "", nil, "ab.out")
+		if want := "a\t1\nb\n"; out != want || status != 0 {
+			t.Errorf("running ab.out: got %q, want %q (%s)", out, want, errs)
+		}
+	})
+	t.Run("Stdin", func(t *testing.T) {
+		out, _, _ := glua(t, dir, "return 1", nil, "compile", "-o", "-", "-")
+		if !strings.HasPrefix(out, "\x1bLua") {
+			t.Errorf("output %q is not a binary chunk", out)
+		}
+	})
+	t.Run("Parse", func(t *testing.T) {
+		glua(t, dir, "", nil, "compile", "-p", "-o", "p.out", "a.lua")
+		if _, err := os.Stat(filepath.Join(dir, "p.out")); !os.IsNotExist(err) {
+			t.Errorf("-p wrote p.out (%v)", err)
+		}
+	})
+	t.Run("Listing", func(t *testing.T) {
+		out, _, _ := glua(t, dir, "", nil, "compile", "-p", "-l", "a.lua")
+		if !strings.HasPrefix(out, "\nmain <a.lua:0,0>") || strings.Contains(out, "constants (") {
+			t.Errorf("-l: got\n%s", out)
+		}
+		out, _, _ = glua(t, dir, "", nil, "compile", "-p", "-l", "-l", "a.lua")
+		if !strings.Contains(out, "constants (") || !strings.Contains(out, "locals (1)") {
+			t.Errorf("-l -l: got\n%s", out)
+		}
+	})
+	t.Run("JSON", func(t *testing.T) {
+		out, _, _ := glua(t, dir, "", nil, "compile", "-p", "-j", "a.lua")
+		if !strings.Contains(out, `"source": "@a.lua"`) || !strings.Contains(out, `"op": "GETTABUP"`) {
+			t.Errorf("-j: got\n%s", out)
+		}
+	})
+	t.Run("Assemble", func(t *testing.T) {
+		listing, _, _ := glua(t, dir, "", nil, "compile", "-p", "-l", "-l", "a.lua")
+		if err := os.WriteFile(filepath.Join(dir, "a.s"), []byte(listing), 0666); err != nil {
+			t.Fatal(err)
+		}
+		glua(t, dir, "", nil, "compile", "-a", "-o", "s.out", "a.s")
+		if out, errs, _ := glua(t, dir, "", nil, "s.out"); out != "a\t1\n" {
+			t.Errorf("running s.out: got %q, want %q (%s)", out, "a\t1\n", errs)
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		_, errs, status := glua(t, dir, "", nil, "compile", "bad.lua")
+		if status != 1 || !strings.Contains(errs, "bad.lua:1:") || !strings.Contains(errs, "x = = 1") {
+			t.Errorf("exit status %d, stderr:\n%s", status, errs)
+		}
+		if _, errs, status = glua(t, dir, "", nil, "compile"); status != 1 || !strings.Contains(errs, "no input files") {
+			t.Errorf("no input: exit status %d, stderr %q", status, errs)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/format"
+)
+
+// reformat runs "glua fmt", which formats Lua files like gofmt:
+//
+//	glua fmt [-d] [-w] [paths]
+//
+// The files, and the .lua files of the directories, are formatted by
+// format.Source; without paths, stdin is formatted. The formatted source is
+// printed, unless option -w writes it back to the files, or option -d shows
+// the changes, with "diff -u". Syntax errors are shown with their source
+// lines (see luac.Diagnostic).
+func reformat(args []string) {
+	var (
+		flags = flag.NewFlagSet("glua fmt", flag.ExitOnError)
+		diffs = flags.Bool("d", false, "display diffs instead of formatted sources")
+		write = flags.Bool("w", false, "write result to the source files")
+		f     formatter
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glua fmt [options] [paths]")
+		fmt.Fprintln(os.Stderr, "Available options are:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	f.diff, f.write = *diffs, *write
+
+	if flags.NArg() == 0 {
+		if f.write {
+			fail(fmt.Errorf("cannot use -w with standard input"))
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fail(err)
+		}
+		f.format("=stdin", "<standard input>", src)
+	}
+	for _, path := range flags.Args() {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || file != path && !strings.HasSuffix(file, ".lua") {
+				return nil
+			}
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			f.format("@"+file, file, src)
+			return nil
+		})
+		if err != nil {
+			f.report(err, nil)
+		}
+	}
+	if f.failed {
+		os.Exit(1)
+	}
+}
+
+// formatter formats the files of "glua fmt".
+type formatter struct {
+	diff   bool // show the changes
+	write  bool // write the files back
+	failed bool // whether an error was reported
+}
+
+// format formats the source src of the chunk named name, read from file.
+func (f *formatter) format(name, file string, src []byte) {
+	out, err := format.Source(name, src)
+	if err != nil {
+		f.report(err, src)
+		return
+	}
+	if !f.diff && !f.write {
+		os.Stdout.Write(out)
+		return
+	}
+	if bytes.Equal(src, out) {
+		return
+	}
+	if f.diff {
+		d, err := diff(file, src, out)
+		if err != nil {
+			f.report(fmt.Errorf("computing diff: %v", err), nil)
+			return
+		}
+		os.Stdout.Write(d)
+	}
+	if f.write {
+		info, err := os.Stat(file)
+		if err == nil {
+			err = ioutil.WriteFile(file, out, info.Mode().Perm())
+		}
+		if err != nil {
+			f.report(err, nil)
+		}
+	}
+}
+
+// report reports the error err, rendering syntax errors with the source
+// lines of src.
+func (f *formatter) report(err error, src []byte) {
+	f.failed = true
+	if list, ok := err.(luac.Diagnostics); ok {
+		list.Render(os.Stderr, src)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "glua: %v\n", err)
+}
+
+// diff returns the changes from src to out, the formatted source of file,
+// as shown by "diff -u".
+func diff(file string, src, out []byte) ([]byte, error) {
+	f1, err := writeTemp(src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTemp(out)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+
+	data, err := exec.Command("diff", "-u", "-L", file+".orig", "-L", file, f1, f2).CombinedOutput()
+	if len(data) > 0 { // diff exits with status 1 when the files differ
+		return data, nil
+	}
+	return nil, err
+}
+
+func writeTemp(data []byte) (string, error) {
+	f, err := ioutil.TempFile("", "glua")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return f.Name(), err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	const (
+		src  = "local x=1\nif x then print( 'a' ) end\n"
+		want = "local x = 1\nif x then print(\"a\") end\n"
+	)
+	dir := t.TempDir()
+	write := func(t *testing.T, name, src string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(t *testing.T, name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	t.Run("Stdin", func(t *testing.T) {
+		if out, errs, _ := glua(t, dir, src, nil, "fmt"); out != want {
+			t.Errorf("got %q, want %q (%s)", out, want, errs)
+		}
+	})
+	t.Run("File", func(t *testing.T) {
+		write(t, "a.lua", src)
+		if out, _, _ := glua(t, dir, "", nil, "fmt", "a.lua"); out != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+		if read(t, "a.lua") != src {
+			t.Errorf("a.lua changed without -w")
+		}
+	})
+	t.Run("Write", func(t *testing.T) {
+		os.MkdirAll(filepath.Join(dir, "w", "sub"), 0777)
+		write(t, "w/b.lua", src)
+		write(t, "w/sub/c.lua", want)
+		write(t, "w/d.txt", src)
+		out, errs, status := glua(t, dir, "", nil, "fmt", "-w", "w")
+		if out != "" || status != 0 {
+			t.Errorf("got %q, exit status %d (%s)", out, status, errs)
+		}
+		for name, want := range map[string]string{"w/b.lua": want, "w/sub/c.lua": want, "w/d.txt": src} {
+			if got := read(t, name); got != want {
+				t.Errorf("%s: got %q, want %q", name, got, want)
+			}
+		}
+	})
+	t.Run("Diff", func(t *testing.T) {
+		if _, err := exec.LookPath("diff"); err != nil {
+			t.Skip("no diff command")
+		}
+		write(t, "e.lua", src)
+		out, errs, _ := glua(t, dir, "", []string{"PATH=" + os.Getenv("PATH")}, "fmt", "-d", "e.lua", "w")
+		if !strings.HasPrefix(out, "--- e.lua.orig\n+++ e.lua\n") || !strings.Contains(out, "\n-local x=1\n-if x then print( 'a' ) end\n+local x = 1\n") || strings.Contains(out, "b.lua") {
+			t.Errorf("got diff\n%s\n(%s)", out, errs)
+		}
+		if read(t, "e.lua") != src {
+			t.Errorf("e.lua changed without -w")
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		write(t, "bad.lua", "x = = 1\n")
+		_, errs, status := glua(t, dir, "", nil, "fmt", "-w", "bad.lua")
+		if status != 1 || !strings.Contains(errs, "bad.lua:1:5: error: unexpected symbol near '='\n\tx = = 1\n") {
+			t.Errorf("exit status %d, stderr:\n%s", status, errs)
+		}
+		if read(t, "bad.lua") != "x = = 1\n" {
+			t.Errorf("bad.lua changed")
+		}
+		if _, errs, status = glua(t, dir, "", nil, "fmt", "-w"); status != 1 || !strings.Contains(errs, "cannot use -w with standard input") {
+			t.Errorf("-w with stdin: exit status %d, stderr %q", status, errs)
+		}
+	})
+}
@@ -1,33 +1,267 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
 
 	"github.com/Azure/golua/lua"
 	"github.com/Azure/golua/lua/lua5"
-	"github.com/Azure/golua/lua/luac"
 )
 
-var _ = fmt.Println
+const version = "glua (Lua 5.3)"
 
-func must(err error) {
+// commands are the subcommands, run as "glua command [args]".
+var commands = map[string]func(args []string){
+	"compile": compile,
+	"fmt":     reformat,
+}
+
+// options are the options of a command line.
+type options struct {
+	interactive bool // -i
+	version     bool // -v (or -i)
+	noEnv       bool // -E
+	script      int  // index of the script in argv (len(argv) if none)
+
+	// -e and -l options, run in order.
+	actions []func(*lua.Thread) error
+}
+
+// parseArgs handles the options of the command line argv like collectargs
+// in lua.c: options end at the first argument which is not one, at "--"
+// or at "-" (the script is then stdin). The arguments of -e and -l may be
+// joined to them (e.g. -estat, -lname).
+//
+// On error, the option in error is returned.
+func parseArgs(argv []string) (opts *options, bad string) {
+	opts = new(options)
+	i := 1
+	for ; i < len(argv); i++ {
+		opt := argv[i]
+		if !strings.HasPrefix(opt, "-") { // not an option?
+			break
+		}
+		switch opt {
+		case "--":
+			i++
+			opts.script = i
+			return opts, ""
+		case "-":
+			opts.script = i
+			return opts, ""
+		case "-E":
+			opts.noEnv = true
+		case "-i":
+			opts.interactive = true
+			opts.version = true
+		case "-v":
+			opts.version = true
+		default:
+			if opt[1] != 'e' && opt[1] != 'l' {
+				return nil, opt
+			}
+			arg := opt[2:]
+			if arg == "" { // argument not joined to the option?
+				if i++; i == len(argv) || strings.HasPrefix(argv[i], "-") {
+					return nil, opt
+				}
+				arg = argv[i]
+			}
+			if opt[1] == 'e' {
+				opts.actions = append(opts.actions, func(ls *lua.Thread) error {
+					return dostring(ls, arg, "=(command line)")
+				})
+			} else {
+				opts.actions = append(opts.actions, func(ls *lua.Thread) error {
+					return dolibrary(ls, arg)
+				})
+			}
+		}
+	}
+	opts.script = i
+	return opts, ""
+}
+
+// usage reports the bad option of the command line and exits.
+func usage(bad string) {
+	prog := os.Args[0]
+	if bad[1] == 'e' || bad[1] == 'l' {
+		fmt.Fprintf(os.Stderr, "%s: '%s' needs argument\n", prog, bad)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: unrecognized option '%s'\n", prog, bad)
+	}
+	fmt.Fprintf(os.Stderr, `usage: %[1]s [options] [script [args]]
+       %[1]s compile [options] [filenames]
+       %[1]s fmt [options] [paths]
+Available options are:
+  -e stat  execute string 'stat'
+  -i       enter interactive mode after executing 'script'
+  -l name  require library 'name'
+  -v       show version information
+  -E       ignore environment variables
+  --       stop handling options
+  -        stop handling options and execute stdin
+`, prog)
+	os.Exit(1)
+}
+
+// check reports err and exits, if err is not nil.
+func check(ls *lua.Thread, err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "glua: %v\n", err)
+		report(ls, err)
 		os.Exit(1)
 	}
 }
 
+// main runs glua like the reference lua interpreter:
+//
+//	glua [options] [script [args]]
+//
+// The options are handled in order; the script (or stdin, if it is "-")
+// is then called with args, which are also stored in the global table
+// 'arg' along with the script name at index 0 and the interpreter and its
+// options at negative indexes. Without script nor option -e or -v, glua
+// runs in interactive mode if stdin is a terminal, or else executes stdin.
+//
+// Unless -E is given, the code in GLUA_INIT (or LUA_INIT) runs first; if
+// it starts with '@', it names the file to run instead.
+//
+// glua exits with status 1 if a chunk fails, and 0 otherwise.
+//
+// The first argument may also name a subcommand (see commands), e.g.
+// "glua compile" (see compile) or "glua fmt" (see reformat).
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+	opts, bad := parseArgs(os.Args)
+	if bad != "" {
+		usage(bad)
+	}
 
 	var config = &lua.Config{
 		Stdlib: lua5.Stdlib,
+		NoEnv:  opts.noEnv,
 	}
 
-	fn := luac.Must(luac.Bundle(luac.Defaults, flag.Args()))
 	ls := lua.Must(lua.Init(config))
-	_, err := ls.Call(ls.Load(fn))
-	must(err)
+
+	var (
+		args   = os.Args[opts.script:]
+		script = opts.script
+	)
+	if len(args) == 0 {
+		script = 0 // (no script)
+	}
+	ls.SetGlobal("arg", argtable(os.Args, script))
+
+	if opts.version {
+		fmt.Println(version)
+	}
+	if !opts.noEnv {
+		check(ls, doinit(ls))
+	}
+	for _, action := range opts.actions {
+		check(ls, action(ls))
+	}
+	if len(args) > 0 {
+		check(ls, doscript(ls, args[0], args[1:]))
+	}
+	switch {
+	case opts.interactive:
+		repl(ls)
+	case len(args) == 0 && len(opts.actions) == 0 && !opts.version:
+		if isTerminal(int(os.Stdin.Fd())) {
+			fmt.Println(version)
+			repl(ls)
+		} else {
+			check(ls, doscript(ls, "-", nil))
+		}
+	}
+}
+
+// argtable returns the 'arg' table of the command line argv whose script
+// name is at index script (0 if none).
+func argtable(argv []string, script int) *lua.Table {
+	arg := lua.NewTableSize(len(argv)-script, script)
+	for i, s := range argv {
+		arg.Set(lua.Int(i-script), lua.String(s))
+	}
+	return arg
+}
+
+// doinit runs the code of the first of the GLUA_INIT_5_3, GLUA_INIT,
+// LUA_INIT_5_3 and LUA_INIT environment variables which is set.
+func doinit(ls *lua.Thread) error {
+	for _, name := range []string{"GLUA_INIT_5_3", "GLUA_INIT", "LUA_INIT_5_3", "LUA_INIT"} {
+		if init, ok := os.LookupEnv(name); ok {
+			if strings.HasPrefix(init, "@") {
+				return doscript(ls, init[1:], nil)
+			}
+			return dostring(ls, init, "="+name)
+		}
+	}
+	return nil
+}
+
+// dostring runs the chunk stat named name.
+func dostring(ls *lua.Thread, stat, name string) error {
+	fn, err := lua.Load(ls, name, []byte(stat), "bt")
+	if err != nil {
+		return err
+	}
+	_, err = docall(ls, fn)
+	return err
+}
+
+// dolibrary runs "name = require(name)".
+func dolibrary(ls *lua.Thread, name string) error {
+	rets, err := docall(ls, ls.Global("require"), lua.String(name))
+	if err != nil {
+		return err
+	}
+	if len(rets) > 0 {
+		ls.SetGlobal(name, rets[0])
+	}
+	return nil
+}
+
+// doscript runs the script file ("-" for stdin) with args.
+func doscript(ls *lua.Thread, file string, args []string) error {
+	var (
+		fn  *lua.Func
+		err error
+	)
+	if file == "-" {
+		var src []byte
+		if src, err = io.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("reading stdin: %s", err)
+		}
+		fn, err = lua.Load(ls, "=stdin", src, "bt")
+	} else {
+		fn, err = lua.LoadFile(ls, file)
+	}
+	if err != nil {
+		return err
+	}
+	vs := make([]lua.Value, len(args))
+	for i, arg := range args {
+		vs[i] = lua.String(arg)
+	}
+	_, err = docall(ls, fn, vs...)
+	return err
+}
+
+// docall calls fn with args; an interrupt (^C) stops the call.
+func docall(ls *lua.Thread, fn lua.Value, args ...lua.Value) ([]lua.Value, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return ls.CallContext(ctx, fn, args...)
 }
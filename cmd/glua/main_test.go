@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+// TestMain runs glua's main instead of the tests when the test binary is
+// run by glua (see glua).
+func TestMain(m *testing.M) {
+	if os.Getenv("GLUA_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// glua runs glua with args and stdin in dir, returning its output and exit
+// status.
+func glua(t *testing.T, dir, stdin string, env []string, args ...string) (stdout, stderr string, status int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{"GLUA_TEST_MAIN=1"}, env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errs bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errs
+	if err := cmd.Run(); err != nil {
+		e, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		status = e.ExitCode()
+	}
+	return out.String(), errs.String(), status
+}
+
+func TestCommandLine(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"script.lua": "print(#arg, arg[0], arg[-1], ...)\n",
+		"mod.lua":    "return {x = 42}\n",
+		"init.lua":   "print('init file')\n",
+		"fail.lua":   "error('boom')\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		name   string
+		args   []string
+		env    []string
+		stdin  string
+		out    string
+		err    string
+		status int
+	}{
+		{name: "Execute", args: []string{"-e", "print(1)", "-e", "print(2)"}, out: "1\n2\n"},
+		{name: "Script", args: []string{"script.lua", "a", "b"}, out: "2\tscript.lua\t" + os.Args[0] + "\ta\tb\n"},
+		{name: "Options", args: []string{"-e", "x=1", "script.lua", "a"}, out: "1\tscript.lua\tx=1\ta\n"},
+		{name: "DashDash", args: []string{"--", "script.lua", "-e"}, out: "1\tscript.lua\t--\t-e\n"},
+		{name: "Stdin", args: []string{"-", "a"}, stdin: "print('stdin', ...)", out: "stdin\ta\n"},
+		{name: "NoArgs", stdin: "print(#arg, arg[0])", out: "0\t" + os.Args[0] + "\n"},
+		{name: "Library", args: []string{"-l", "mod", "-e", "print(mod.x)"}, out: "42\n"},
+		{name: "Version", args: []string{"-v"}, out: version + "\n"},
+		{name: "Init", args: []string{"-e", "print(2)"}, env: []string{"LUA_INIT=print(1)"}, out: "1\n2\n"},
+		{name: "InitFile", args: []string{"-e", ""}, env: []string{"GLUA_INIT=@init.lua"}, out: "init file\n"},
+		{name: "InitOrder", env: []string{"LUA_INIT=print(1)", "GLUA_INIT_5_3=print(2)"}, args: []string{"-e", ""}, out: "2\n"},
+		{name: "NoEnv", args: []string{"-E", "-e", "print(2)"}, env: []string{"LUA_INIT=print(1)"}, out: "2\n"},
+		{name: "Error", args: []string{"-e", "error('boom')", "-e", "print(2)"}, err: "(command line):1: boom", status: 1},
+		{name: "ScriptError", args: []string{"fail.lua"}, err: "fail.lua:1: boom", status: 1},
+		{name: "InitError", args: []string{"-e", "print(2)"}, env: []string{"LUA_INIT=error('init')"}, err: "LUA_INIT:1: init", status: 1},
+		{name: "NoScript", args: []string{"nosuch.lua"}, err: "nosuch.lua", status: 1},
+		{name: "SyntaxError", args: []string{"-e", "x = = 1"}, err: "(command line):1:", status: 1},
+		{name: "Joined", args: []string{"-lmod", "-eprint(mod.x)", "-e", "print(1)"}, out: "42\n1\n"},
+		{name: "JoinedScript", args: []string{"-ex=1", "script.lua", "a"}, out: "1\tscript.lua\t-ex=1\ta\n"},
+		{name: "ScriptOptions", args: []string{"script.lua", "-e", "print(1)"}, out: "2\tscript.lua\t" + os.Args[0] + "\t-e\tprint(1)\n"},
+		{name: "NeedsArgument", args: []string{"-e"}, err: "'-e' needs argument", status: 1},
+		{name: "OptionArgument", args: []string{"-l", "-v"}, err: "'-l' needs argument", status: 1},
+		{name: "Unrecognized", args: []string{"-x"}, err: "unrecognized option '-x'", status: 1},
+		{name: "ExtraCharacters", args: []string{"-vE"}, err: "unrecognized option '-vE'", status: 1},
+		{name: "BadDashDash", args: []string{"--x"}, err: "unrecognized option '--x'", status: 1},
+		{name: "NotRun", args: []string{"-e", "print(1)", "-x"}, err: "usage:", status: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out, errs, status := glua(t, dir, test.stdin, test.env, test.args...)
+			if status != test.status {
+				t.Errorf("exit status %d, want %d (stderr %q)", status, test.status, errs)
+			}
+			if out != test.out {
+				t.Errorf("stdout %q, want %q", out, test.out)
+			}
+			if test.err == "" && errs != "" || !strings.Contains(errs, test.err) {
+				t.Errorf("stderr %q, want %q", errs, test.err)
+			}
+		})
+	}
+}
+
+func TestArgTable(t *testing.T) {
+	argv := []string{"glua", "-e", "x=1", "script.lua", "a", "b"}
+	arg := argtable(argv, 3)
+	for i, want := range argv {
+		if got := arg.Get(lua.Int(i - 3)); got != lua.String(want) {
+			t.Errorf("arg[%d] = %v, want %q", i-3, got, want)
+		}
+	}
+	if n := arg.Length(); n != 2 {
+		t.Errorf("#arg = %d, want 2", n)
+	}
+	if got := argtable([]string{"glua"}, 0).Get(lua.Int(0)); got != lua.String("glua") {
+		t.Errorf("no script: arg[0] = %v, want %q", got, "glua")
+	}
+}
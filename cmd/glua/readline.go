@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// errCancel is returned by readLine when the user cancels the line (^C).
+var errCancel = errors.New("cancelled")
+
+// lineReader reads the lines typed in the REPL.
+//
+// If stdin is a terminal, lines are edited in place with the usual keys
+// (arrows, Home/End, ^A/^E, ^K/^U, ...), the lines read are kept in a
+// history file (browsed with Up/Down or ^P/^N) and Tab completes the
+// word before the cursor with the candidates returned by complete.
+type lineReader struct {
+	in       *bufio.Reader
+	out      io.Writer
+	tty      bool     // is stdin a terminal?
+	history  []string // lines read, oldest first
+	file     string   // history file ("" if none)
+	complete func(string) []string
+}
+
+func newLineReader(complete func(string) []string) *lineReader {
+	r := &lineReader{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		complete: complete,
+	}
+	if r.tty = isTerminal(int(os.Stdin.Fd())); r.tty {
+		r.file = historyFile()
+		r.loadHistory()
+	}
+	return r
+}
+
+// historyFile returns the name of the history file: $GLUA_HISTORY or
+// ~/.glua_history.
+func historyFile() string {
+	if file := os.Getenv("GLUA_HISTORY"); file != "" {
+		return file
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".glua_history")
+	}
+	return ""
+}
+
+func (r *lineReader) loadHistory() {
+	if r.file == "" {
+		return
+	}
+	b, err := os.ReadFile(r.file)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+		r.saveHistory()
+	}
+}
+
+// saveHistory rewrites the history file; errors are ignored.
+func (r *lineReader) saveHistory() {
+	if r.file != "" {
+		os.WriteFile(r.file, []byte(strings.Join(r.history, "\n")+"\n"), 0600)
+	}
+}
+
+// addHistory adds line to the history, unless it is empty or repeats the
+// last line.
+func (r *lineReader) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(r.history) > 0 && r.history[len(r.history)-1] == line) {
+		return
+	}
+	if r.history = append(r.history, line); len(r.history) > maxHistory {
+		r.history = r.history[1:]
+		r.saveHistory()
+		return
+	}
+	if r.file != "" {
+		if f, err := os.OpenFile(r.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
+			fmt.Fprintln(f, line)
+			f.Close()
+		}
+	}
+}
+
+// readLine reads a line, displaying prompt. It returns io.EOF at the end of
+// the input (or on ^D on an empty line) and errCancel if the line is
+// cancelled.
+func (r *lineReader) readLine(prompt string) (string, error) {
+	if !r.tty {
+		fmt.Fprint(r.out, prompt)
+		line, err := r.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fd := int(os.Stdin.Fd())
+	saved, err := makeRaw(fd)
+	if err != nil {
+		r.tty = false
+		return r.readLine(prompt)
+	}
+	e := &editor{r: r, prompt: prompt, hist: len(r.history)}
+	line, err := e.edit()
+	restoreTerm(fd, saved)
+	fmt.Fprint(r.out, "\n")
+	if err == nil {
+		r.addHistory(line)
+	}
+	return line, err
+}
+
+// editor edits a line on the terminal.
+type editor struct {
+	r      *lineReader
+	prompt string
+	line   []rune
+	pos    int    // cursor position in line
+	hist   int    // index of the line in the history (len if new)
+	edited string // new line, saved while browsing the history
+	tabs   int    // number of consecutive tabs
+}
+
+func (e *editor) edit() (string, error) {
+	e.refresh()
+	for {
+		c, _, err := e.r.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if c != '\t' {
+			e.tabs = 0
+		}
+		switch c {
+		case '\r', '\n':
+			return string(e.line), nil
+		case 3: // ^C
+			fmt.Fprint(e.r.out, "^C")
+			return "", errCancel
+		case 4: // ^D
+			if len(e.line) == 0 {
+				return "", io.EOF
+			}
+			e.delete(e.pos)
+		case 1: // ^A
+			e.pos = 0
+		case 5: // ^E
+			e.pos = len(e.line)
+		case 2: // ^B
+			e.move(-1)
+		case 6: // ^F
+			e.move(+1)
+		case 11: // ^K
+			e.line = e.line[:e.pos]
+		case 21: // ^U
+			e.line, e.pos = e.line[e.pos:], 0
+		case 16: // ^P
+			e.browse(-1)
+		case 14: // ^N
+			e.browse(+1)
+		case 8, 127: // backspace
+			if e.pos > 0 {
+				e.pos--
+				e.delete(e.pos)
+			}
+		case '\t':
+			e.tabs++
+			e.completion()
+		case 27: // escape sequence
+			e.escape()
+		default:
+			if c >= ' ' && c != utf8.RuneError {
+				e.insert(c)
+			}
+		}
+		e.refresh()
+	}
+}
+
+// escape handles the escape sequences of the cursor keys.
+func (e *editor) escape() {
+	c, _, _ := e.r.in.ReadRune()
+	if c != '[' && c != 'O' {
+		return
+	}
+	c, _, _ = e.r.in.ReadRune()
+	switch c {
+	case 'A':
+		e.browse(-1)
+	case 'B':
+		e.browse(+1)
+	case 'C':
+		e.move(+1)
+	case 'D':
+		e.move(-1)
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.line)
+	case '1', '3', '4', '7', '8': // ESC [ n ~
+		if t, _, _ := e.r.in.ReadRune(); t == '~' {
+			switch c {
+			case '1', '7':
+				e.pos = 0
+			case '4', '8':
+				e.pos = len(e.line)
+			case '3':
+				e.delete(e.pos)
+			}
+		}
+	}
+}
+
+func (e *editor) insert(c rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = c
+	e.pos++
+}
+
+func (e *editor) delete(i int) {
+	if i < len(e.line) {
+		e.line = append(e.line[:i], e.line[i+1:]...)
+	}
+}
+
+func (e *editor) move(d int) {
+	if pos := e.pos + d; pos >= 0 && pos <= len(e.line) {
+		e.pos = pos
+	}
+}
+
+// browse replaces the line by the previous (d < 0) or next line of the
+// history.
+func (e *editor) browse(d int) {
+	hist := e.hist + d
+	if hist < 0 || hist > len(e.r.history) {
+		return
+	}
+	if e.hist == len(e.r.history) {
+		e.edited = string(e.line)
+	}
+	e.hist = hist
+	if hist == len(e.r.history) {
+		e.line = []rune(e.edited)
+	} else {
+		e.line = []rune(e.r.history[hist])
+	}
+	e.pos = len(e.line)
+}
+
+// completion completes the word before the cursor: a single candidate
+// replaces it, several ones extend it to their common prefix; a second
+// tab lists them.
+func (e *editor) completion() {
+	if e.r.complete == nil {
+		return
+	}
+	head := string(e.line[:e.pos])
+	cands := e.r.complete(head)
+	if len(cands) == 0 {
+		return
+	}
+	word := cands[0]
+	for _, cand := range cands[1:] {
+		word = commonPrefix(word, cand)
+	}
+	start := wordStart(head)
+	if len(cands) > 1 && word == head[start:] {
+		if e.tabs > 1 {
+			fmt.Fprintf(e.r.out, "\r\n%s\r\n", strings.Join(cands, "  ")) // (raw mode)
+		}
+		return
+	}
+	tail := e.line[e.pos:]
+	e.line = append([]rune(head[:start]+word), tail...)
+	e.pos = len(e.line) - len(tail)
+}
+
+// refresh redraws the line and puts the cursor in place.
+func (e *editor) refresh() {
+	fmt.Fprintf(e.r.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(e.r.out, "\x1b[%dD", n)
+	}
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/lua/luac"
+)
+
+const (
+	// default prompts, overridden by the _PROMPT and _PROMPT2 globals.
+	prompt1 = "> "
+	prompt2 = ">> "
+
+	// name of the chunks read by the REPL.
+	replName = "=stdin"
+)
+
+// repl runs the read-eval-print loop: each line is evaluated as an
+// expression, whose values are printed, or else as a statement; a chunk
+// which is incomplete (e.g. "for i = 1, 3 do") continues on the next
+// lines. ^C interrupts the running code or cancels the input.
+func repl(ls *lua.Thread) {
+	r := newLineReader(func(head string) []string { return complete(ls, head) })
+	for {
+		fn, err := read(ls, r)
+		switch err {
+		case nil:
+		case io.EOF:
+			fmt.Fprintln(os.Stdout)
+			return
+		case errCancel:
+			continue
+		default:
+			report(ls, err)
+			continue
+		}
+		rets, err := docall(ls, fn)
+		if err != nil {
+			report(ls, err)
+			continue
+		}
+		if len(rets) > 0 {
+			vs := make([]string, len(rets))
+			for i, v := range rets {
+				vs[i] = tostring(ls, v)
+			}
+			fmt.Fprintln(os.Stdout, strings.Join(vs, "\t"))
+		}
+	}
+}
+
+// read reads and compiles the next chunk: the first line is tried as an
+// expression ("return <line>") first; statements continue on the next
+// lines while the compiler reports errors at the end of the input.
+func read(ls *lua.Thread, r *lineReader) (*lua.Func, error) {
+	line, err := r.readLine(prompt(ls, "_PROMPT", prompt1))
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(line, "=") { // (Lua 5.2 style "=expr")
+		line = line[1:]
+	}
+	if fn, err := lua.Load(ls, replName, []byte("return "+line), "t"); err == nil {
+		return fn, nil
+	}
+	for {
+		fn, err := lua.Load(ls, replName, []byte(line), "t")
+		if err == nil || !incomplete(err) {
+			return fn, err
+		}
+		more, err := r.readLine(prompt(ls, "_PROMPT2", prompt2))
+		if err != nil {
+			return nil, err
+		}
+		line += "\n" + more
+	}
+}
+
+// incomplete reports whether the compilation error err is caused by an
+// incomplete chunk (i.e. it was found at the end of the input).
+func incomplete(err error) bool {
+	var list luac.Diagnostics
+	return errors.As(err, &list) && len(list) > 0 && list[0].AtEOF
+}
+
+// prompt returns the string value of global name, or def.
+func prompt(ls *lua.Thread, name, def string) string {
+	if s, ok := ls.Global(name).(lua.String); ok {
+		return string(s)
+	}
+	return def
+}
+
+// report prints the error err; like lua, error objects which are neither
+// strings, numbers nor values with a '__tostring' metamethod are only
+// described by their type.
+func report(ls *lua.Thread, err error) {
+	var msg string
+	switch v := lua.ErrorValue(err); {
+	case errors.Is(err, context.Canceled):
+		msg = "interrupted!"
+	default:
+		msg = fmt.Sprintf("(error object is a %s value)", lua.TypeName(v))
+		switch v.(type) {
+		case lua.String, lua.Int, lua.Float:
+			msg = tostring(ls, v)
+		default:
+			if ls.TypeOf(v).Method("__tostring") != nil {
+				msg = tostring(ls, v)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "glua: %s\n", msg)
+}
+
+// tostring converts v to a string with the global tostring function.
+func tostring(ls *lua.Thread, v lua.Value) string {
+	if fn := ls.Global("tostring"); fn != nil {
+		if rets, err := ls.CallN(fn, []lua.Value{v}, 1); err == nil {
+			if s, ok := lua.ToString(rets[0]); ok {
+				return string(s)
+			}
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// keywords are the Lua keywords, completed at the start of words.
+var keywords = []string{
+	"and", "break", "do", "else", "elseif", "end", "false", "for",
+	"function", "goto", "if", "in", "local", "nil", "not", "or",
+	"repeat", "return", "then", "true", "until", "while",
+}
+
+// complete returns the completions of the last word of head (e.g. "str"
+// or "string.fo"), i.e. the keywords, globals or table fields it
+// prefixes; fields include the ones inherited through '__index'.
+func complete(ls *lua.Thread, head string) (cands []string) {
+	var (
+		word  = head[wordStart(head):]
+		path  []string
+		sep   = strings.LastIndexAny(word, ".:")
+		table = ls.Globals()
+	)
+	if sep >= 0 {
+		path = strings.Split(word[:sep], ".")
+	}
+	for _, name := range path {
+		t, ok := table.Get(lua.String(name)).(*lua.Table)
+		if !ok {
+			return nil
+		}
+		table = t
+	}
+	prefix := word[:sep+1]
+	seen := make(map[string]bool)
+	for i := 0; table != nil && i < 100; i++ { // (avoid '__index' loops)
+		for k, _, _ := table.Next(nil); k != nil; k, _, _ = table.Next(k) {
+			if s, ok := k.(lua.String); ok && strings.HasPrefix(string(s), word[sep+1:]) && isName(string(s)) && !seen[string(s)] {
+				seen[string(s)] = true
+				cands = append(cands, prefix+string(s))
+			}
+		}
+		meta := table.Meta()
+		if meta == nil {
+			break
+		}
+		table, _ = meta.Get(lua.String("__index")).(*lua.Table)
+	}
+	if sep < 0 {
+		for _, kw := range keywords {
+			if strings.HasPrefix(kw, word) {
+				cands = append(cands, kw)
+			}
+		}
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+// wordStart returns the start of the last word of head, made of names
+// separated by dots or colons.
+func wordStart(head string) int {
+	i := len(head)
+	for i > 0 && (isNameChar(head[i-1]) || head[i-1] == '.' || head[i-1] == ':') {
+		i--
+	}
+	return i
+}
+
+func isName(s string) bool {
+	if s == "" || ('0' <= s[0] && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
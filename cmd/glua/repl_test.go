@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/lua/lua5"
+)
+
+func newThread(t *testing.T) *lua.Thread {
+	ls, err := lua.Init(&lua.Config{Stdlib: lua5.Stdlib, NoEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls
+}
+
+func TestIncomplete(t *testing.T) {
+	ls := newThread(t)
+	for _, test := range []struct {
+		src  string
+		want bool
+	}{
+		{"for i = 1, 2 do", true},
+		{"function f()", true},
+		{"x = ", true},
+		{"s = [[abc", true},
+		{"t = {1, 2,", true},
+		{"x = = 1", false},
+		{"return 1 )", false},
+		{"local 1", false},
+	} {
+		_, err := lua.Load(ls, replName, []byte(test.src), "t")
+		if err == nil {
+			t.Errorf("%q: compiled", test.src)
+			continue
+		}
+		if got := incomplete(err); got != test.want {
+			t.Errorf("incomplete(%q) = %v, want %v (%v)", test.src, got, test.want, err)
+		}
+	}
+	if incomplete(errors.New("<eof>")) {
+		t.Errorf("incomplete: error without diagnostics")
+	}
+}
+
+func TestRead(t *testing.T) {
+	ls := newThread(t)
+	for _, test := range []struct {
+		name  string
+		input string
+		want  []lua.Value
+		err   bool
+	}{
+		{"Expression", "1 + 2\n", []lua.Value{lua.Int(3)}, false},
+		{"Equals", "=1, 'a'\n", []lua.Value{lua.Int(1), lua.String("a")}, false},
+		{"Statement", "x = 4\n", nil, false},
+		{"Continued", "local n = 0 for i = 1, 3 do\nn = n + i\nend return n\n", []lua.Value{lua.Int(6)}, false},
+		{"Lines", "function f(x)\nreturn x * 2\nend\n", nil, false},
+		{"Error", "x = = 1\n", nil, true},
+		{"EOF", "for i = 1, 3 do\n", nil, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := &lineReader{in: bufio.NewReader(strings.NewReader(test.input)), out: ioutil.Discard}
+			fn, err := read(ls, r)
+			if (err != nil) != test.err {
+				t.Fatalf("read: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			rets, err := docall(ls, fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rets) == 0 {
+				rets = nil
+			}
+			if !reflect.DeepEqual(rets, test.want) {
+				t.Errorf("got %v, want %v", rets, test.want)
+			}
+		})
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	ls := newThread(t)
+	r := &lineReader{in: bufio.NewReader(strings.NewReader("local n = 0\nfor i = 1, 3 do\nn = n + i\nend\nreturn n\n")), out: ioutil.Discard}
+	// each complete chunk is read on its own.
+	for i := 0; i < 3; i++ {
+		if _, err := read(ls, r); err != nil {
+			t.Fatalf("chunk %d: %v", i+1, err)
+		}
+	}
+	if _, err := read(ls, r); err != io.EOF {
+		t.Errorf("read: got %v, want EOF", err)
+	}
+}
+
+func TestEditor(t *testing.T) {
+	history := []string{"first", "second"}
+	for _, test := range []struct {
+		name string
+		keys string
+		want string
+		err  error
+	}{
+		{"Line", "abc\r", "abc", nil},
+		{"Backspace", "abd\x7fc\r", "abc", nil},
+		{"Move", "ac\x02b\r", "abc", nil},
+		{"Arrows", "ac\x1b[Db\x1b[Cd\r", "abcd", nil},
+		{"Home", "bc\x01a\x05d\r", "abcd", nil},
+		{"Kill", "abcdef\x01\x06\x06\x06\x0b\r", "abc", nil},
+		{"KillHead", "xyzabc\x01\x06\x06\x06\x15\r", "abc", nil},
+		{"Delete", "abxc\x02\x02\x04\r", "abc", nil},
+		{"Previous", "\x10\r", "second", nil},
+		{"PreviousTwice", "\x10\x10\r", "first", nil},
+		{"Up", "\x1b[A\x1b[A\x1b[B\r", "second", nil},
+		{"Edited", "new\x10\x0e\r", "new", nil},
+		{"Cancel", "abc\x03", "", errCancel},
+		{"EOF", "\x04", "", io.EOF},
+		{"Complete", "pr\t(1)\r", "print(1)", nil},
+		{"CompleteField", "x = math.ce\t\r", "x = math.ceil", nil},
+		{"CompletePrefix", "math.ma\t\r", "math.max", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := &lineReader{
+				in:      bufio.NewReader(strings.NewReader(test.keys)),
+				out:     ioutil.Discard,
+				history: history,
+				complete: func(head string) []string {
+					return complete(newThread(t), head)
+				},
+			}
+			e := &editor{r: r, hist: len(r.history)}
+			line, err := e.edit()
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if line != test.want {
+				t.Errorf("got %q, want %q", line, test.want)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	ls := newThread(t)
+	fn, err := lua.Load(ls, "=test", []byte("t = setmetatable({}, {__index = math})"), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := docall(ls, fn); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		head string
+		want []string
+	}{
+		{"pri", []string{"print"}},
+		{"x = math.ce", []string{"math.ceil"}},
+		{"math.ma", []string{"math.max", "math.maxinteger"}},
+		{"t:ab", []string{"t:abs"}},
+		{"f(loc", []string{"local"}},
+		{"nosuch.x", nil},
+	} {
+		got := complete(ls, test.head)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("complete(%q) = %q, want %q", test.head, got, test.want)
+		}
+	}
+}
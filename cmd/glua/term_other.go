@@ -0,0 +1,18 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// termState is the state of a terminal.
+type termState struct{}
+
+// isTerminal reports whether fd is a terminal: terminals are not supported
+// on this platform, lines are read without editing.
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw mode not supported")
+}
+
+func restoreTerm(fd int, state *termState) error { return nil }
@@ -81,8 +81,13 @@ func (args *Tuple) Bool(arg int) (Bool, error) {
 //
 // Accessors with options
 //
+// The option is returned if the argument is absent or nil.
+//
 
 func (args *Tuple) StringOpt(arg int, opt String) String {
+	if IsNil(args.Arg(arg)) {
+		return opt
+	}
 	if v, err := args.String(arg); err == nil {
 		return v
 	}
@@ -90,6 +95,9 @@ func (args *Tuple) StringOpt(arg int, opt String) String {
 }
 
 func (args *Tuple) NumberOpt(arg int, opt Number) Number {
+	if IsNil(args.Arg(arg)) {
+		return opt
+	}
 	if v, err := args.Number(arg); err == nil {
 		return v
 	}
@@ -97,6 +105,9 @@ func (args *Tuple) NumberOpt(arg int, opt Number) Number {
 }
 
 func (args *Tuple) FloatOpt(arg int, opt Float) Float {
+	if IsNil(args.Arg(arg)) {
+		return opt
+	}
 	if v, err := args.Float(arg); err == nil {
 		return v
 	}
@@ -104,6 +115,9 @@ func (args *Tuple) FloatOpt(arg int, opt Float) Float {
 }
 
 func (args *Tuple) IntOpt(arg int, opt Int) Int {
+	if IsNil(args.Arg(arg)) {
+		return opt
+	}
 	if v, err := args.Int(arg); err == nil {
 		return v
 	}
@@ -111,6 +125,9 @@ func (args *Tuple) IntOpt(arg int, opt Int) Int {
 }
 
 func (args *Tuple) BoolOpt(arg int, opt Bool) Bool {
+	if IsNil(args.Arg(arg)) {
+		return opt
+	}
 	if v, err := args.Bool(arg); err == nil {
 		return v
 	}
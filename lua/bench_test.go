@@ -0,0 +1,101 @@
+package lua_test
+
+import (
+	"testing"
+
+	"github.com/Azure/golua/lua/luac"
+)
+
+// Workloads of the benchmarks, adapted from the tests in lua/test/lua.
+var workloads = []struct {
+	name string
+	src  string
+}{
+	// The bit32 library of bitwise.lua, written with bitwise operators.
+	{"Bitwise", `
+		local bit = {}
+		function bit.band (x, y) return ((x or -1) & (y or -1)) & 0xFFFFFFFF end
+		function bit.bxor (x, y) return ((x or 0) ~ (y or 0)) & 0xFFFFFFFF end
+		function bit.lrotate (a, b)
+			b = b & 31
+			a = a & 0xFFFFFFFF
+			a = (a << b) | (a >> (32 - b))
+			return a & 0xFFFFFFFF
+		end
+		function bit.rrotate (a, b) return bit.lrotate(a, -b) end
+		local x = 0x12345678
+		for i = 1, 20000 do
+			x = bit.bxor(bit.lrotate(x, 7), bit.band(i, 0xFF))
+			x = bit.rrotate(x, 3)
+		end
+		return x
+	`},
+	// The permutations of sort.lua.
+	{"Perm", `
+		local n = 0
+		local function perm (s, k)
+			k = k or #s
+			if k == 1 then
+				for i = #s, 2, -1 do if s[i] == s[i-1] then n = n + 1 end end
+			else
+				for i = 1, k do
+					s[i], s[k] = s[k], s[i]
+					perm(s, k - 1)
+					s[i], s[k] = s[k], s[i]
+				end
+			end
+		end
+		perm{1,2,3,4,5,6,7}
+		return n
+	`},
+	// The sieve of coroutine.lua, without coroutines.
+	{"Sieve", `
+		local n, composite, a = 20000, {}, {}
+		for i = 2, n do
+			if not composite[i] then
+				a[#a+1] = i
+				for j = i*i, n, i do composite[j] = true end
+			end
+		end
+		return #a
+	`},
+	// Global and field reads in a loop, as in math.lua.
+	{"Globals", `
+		local s = 0.0
+		for i = 1, 20000 do
+			s = s + math.abs(i - 10000) * math.pi + math.abs(-i)
+		end
+		return s
+	`},
+	// Global and field reads only.
+	{"Fields", `
+		local obj = {n = 0}
+		function obj:get() return self.n end
+		local x
+		for i = 1, 20000 do
+			x = math.pi
+			x = string.rep
+			x = obj:get()
+		end
+		return x
+	`},
+}
+
+func BenchmarkWorkloads(b *testing.B) {
+	for _, w := range workloads {
+		b.Run(w.name, func(b *testing.B) {
+			chunk, err := luac.Compile(luac.Defaults, "="+w.name, w.src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ls := newThread(b, nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ls.Exec(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
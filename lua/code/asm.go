@@ -0,0 +1,448 @@
+package code
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Assemble assembles the bytecode listing src named name into a chunk;
+// src may be a string, []byte or io.Reader, or nil to read the file name.
+//
+// The listing has the format of the full listing of Chunk.Print (e.g. the
+// output of "glua compile -l -l"), so that assembling the listing of a
+// chunk gives back the chunk:
+//
+//	main <hello.lua:0,0> (4 instructions at 0xc000010000)
+//	0+ params, 2 slots, 1 upvalue, 0 locals, 2 constants, 0 functions
+//		1	[1]	GETTABUP 	0 0 -1	; _ENV "print"
+//		2	[1]	LOADK    	1 -2	; "hello"
+//		3	[1]	CALL     	0 2 1
+//		4	[1]	RETURN   	0 1
+//	constants (2) for 0xc000010000:
+//		1	"print"
+//		2	"hello"
+//	locals (0) for 0xc000010000:
+//	upvalues (1) for 0xc000010000:
+//		0	_ENV	1	0
+//
+// Each function starts with its header lines, followed by its instructions
+// and then by its constants, locals and upvalues. Nested functions follow
+// their parent, in order: the number of functions of each header tells
+// which functions are nested in which.
+//
+// Instructions are written as their opcode and operands; their pc and
+// source line ("[-]" if none) are optional, and the text after ';' is a
+// comment. Constant operands are numbered from -1. The jump of JMP,
+// FORPREP, FORLOOP and TFORLOOP may also be given as a label, defined
+// by a line "name:" before the target instruction.
+//
+// All the functions of the chunk get name as their source. The code of
+// the chunk is checked by Verify.
+func Assemble(name string, src interface{}) (chunk *Chunk, err error) {
+	b, err := readSource(name, src)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(Error)
+			if !ok {
+				panic(r)
+			}
+			chunk, err = nil, e
+		}
+	}()
+	asm := &assembler{name: name}
+	scan := bufio.NewScanner(b)
+	scan.Buffer(nil, 1<<30)
+	for scan.Scan() {
+		asm.line++
+		asm.parse(scan.Text())
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	main := asm.link()
+	if err := Verify(main); err != nil {
+		return nil, err
+	}
+	return &Chunk{main}, nil
+}
+
+var (
+	asmHeader  = regexp.MustCompile(`^(main|function)\s*<.*:(\d+),(\d+)>`)
+	asmParams  = regexp.MustCompile(`^(\d+)(\+?) params?, (\d+) slots?, (\d+) upvalues?, (\d+) locals?, (\d+) constants?, (\d+) functions?$`)
+	asmSection = regexp.MustCompile(`^(constants|locals|upvalues)\s*\((\d+)\)`)
+	asmLabel   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*:$`)
+)
+
+// assembler assembles a listing, one line at a time.
+type assembler struct {
+	name  string
+	line  int        // current line
+	funcs []*asmFunc // functions, in order
+	fn    *asmFunc   // current function
+	sect  string     // current section ("" for the code)
+}
+
+// asmFunc is a function being assembled.
+type asmFunc struct {
+	proto  *Proto
+	line   int            // line of the header
+	counts map[string]int // numbers of items, from the header
+	sects  map[string]int // numbers of items, from the section headers
+	labels map[string]int // label -> pc
+	jumps  []asmJump      // jumps to labels
+	lines  []int32        // source lines (-1 if none)
+}
+
+// asmJump is a jump to a label.
+type asmJump struct {
+	pc    int
+	label string
+	line  int
+}
+
+func (asm *assembler) parse(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" || text[0] == ';' {
+		return
+	}
+	if m := asmHeader.FindStringSubmatch(text); m != nil {
+		asm.finish()
+		asm.fn = &asmFunc{
+			proto: &Proto{
+				Source: asm.name,
+				SrcPos: asm.atoi(m[2]),
+				EndPos: asm.atoi(m[3]),
+			},
+			line:   asm.line,
+			sects:  make(map[string]int),
+			labels: make(map[string]int),
+		}
+		asm.funcs = append(asm.funcs, asm.fn)
+		asm.sect = ""
+		return
+	}
+	fn := asm.fn
+	asm.check(fn != nil, "missing function header")
+	if m := asmParams.FindStringSubmatch(text); m != nil {
+		asm.check(fn.counts == nil && asm.sect == "" && len(fn.proto.Instrs) == 0, "unexpected function header")
+		fn.proto.ParamN = asm.atoi(m[1])
+		fn.proto.Vararg = m[2] == "+"
+		fn.proto.StackN = asm.atoi(m[3])
+		fn.counts = map[string]int{
+			"upvalues":  asm.atoi(m[4]),
+			"locals":    asm.atoi(m[5]),
+			"constants": asm.atoi(m[6]),
+			"functions": asm.atoi(m[7]),
+		}
+		return
+	}
+	asm.check(fn.counts != nil, "missing function header")
+	if m := asmSection.FindStringSubmatch(text); m != nil {
+		_, seen := fn.sects[m[1]]
+		asm.check(!seen, "duplicate %s", m[1])
+		asm.sect = m[1]
+		fn.sects[m[1]] = asm.atoi(m[2])
+		return
+	}
+	switch asm.sect {
+	case "":
+		asm.instr(text)
+	case "constants":
+		asm.kst(text)
+	case "locals":
+		fields := asm.fields(text, len(fn.proto.Locals), 4)
+		fn.proto.Locals = append(fn.proto.Locals, &Local{
+			Name: strings.Join(fields[1:len(fields)-2], " "),
+			Live: int32(asm.atoi(fields[len(fields)-2]) - 1),
+			Dead: int32(asm.atoi(fields[len(fields)-1]) - 1),
+		})
+	case "upvalues":
+		fields := asm.fields(text, len(fn.proto.UpVars), 3)
+		stack := fields[len(fields)-2]
+		asm.check(stack == "0" || stack == "1", "invalid upvalue %s", text)
+		fn.proto.UpVars = append(fn.proto.UpVars, &UpVar{
+			Name:  strings.Join(fields[1:len(fields)-2], " "),
+			Stack: stack == "1",
+			Index: asm.atoi(fields[len(fields)-1]),
+		})
+	}
+}
+
+// fields returns the fields of the entry text of a locals or upvalues
+// section, which must be numbered index and have at least min fields.
+func (asm *assembler) fields(text string, index, min int) []string {
+	fields := strings.Fields(text)
+	asm.check(len(fields) >= min, "invalid %s entry %s", asm.sect, text)
+	asm.check(fields[0] == strconv.Itoa(index), "%s entry %s out of order", asm.sect, fields[0])
+	return fields
+}
+
+// instr assembles the instruction (or label) text.
+func (asm *assembler) instr(text string) {
+	fn := asm.fn
+	if i := strings.IndexByte(text, ';'); i >= 0 {
+		text = text[:i]
+	}
+	fields := strings.Fields(text)
+	for len(fields) > 0 && asmLabel.MatchString(fields[0]) {
+		label := strings.TrimSuffix(fields[0], ":")
+		_, dup := fn.labels[label]
+		asm.check(!dup, "duplicate label %s", label)
+		fn.labels[label] = len(fn.proto.Instrs)
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if _, err := strconv.Atoi(fields[0]); err == nil { // pc
+		fields = fields[1:]
+	}
+	line := int32(-1)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+		if n := strings.TrimSuffix(fields[0][1:], "]"); n != "-" {
+			line = int32(asm.atoi(n))
+		}
+		fields = fields[1:]
+	}
+	asm.check(len(fields) > 0, "missing opcode")
+	op := asm.opcode(fields[0])
+	args := fields[1:]
+
+	var (
+		mask = op.Mask()
+		want = 1
+	)
+	switch op.Mode() {
+	case ModeABC:
+		if !mask.B(ArgN) {
+			want++
+		}
+		if !mask.C(ArgN) {
+			want++
+		}
+	case ModeABx:
+		if !mask.B(ArgN) {
+			want++
+		}
+	case ModeAsBx:
+		want = 2
+	}
+	asm.check(len(args) == want, "%v expects %d operands", op, want)
+
+	var inst Instr
+	switch op.Mode() {
+	case ModeABC:
+		a := asm.arg(args[0], 0, MaxArgA)
+		b, c := 0, 0
+		if args = args[1:]; !mask.B(ArgN) {
+			b, args = asm.rk(args[0]), args[1:]
+		}
+		if !mask.C(ArgN) {
+			c = asm.rk(args[0])
+		}
+		inst = MakeABC(op, a, b, c)
+	case ModeABx:
+		bx := 0
+		if len(args) > 1 {
+			bx = asm.arg(args[1], Kst(MaxArgBX), MaxArgBX)
+			if bx < 0 {
+				bx = Kst(bx)
+			}
+		}
+		inst = MakeABx(op, asm.arg(args[0], 0, MaxArgA), bx)
+	case ModeAsBx:
+		sbx := 0
+		if asmLabel.MatchString(args[1] + ":") {
+			fn.jumps = append(fn.jumps, asmJump{len(fn.proto.Instrs), args[1], asm.line})
+		} else {
+			sbx = asm.arg(args[1], -MaxArgSBX, MaxArgBX-MaxArgSBX)
+		}
+		inst = MakeAsBx(op, asm.arg(args[0], 0, MaxArgA), sbx)
+	case ModeAx:
+		ax := asm.arg(args[0], Kst(MaxArgAX), MaxArgAX)
+		if ax < 0 {
+			ax = Kst(ax)
+		}
+		inst = MakeAx(op, ax)
+	}
+	fn.proto.Instrs = append(fn.proto.Instrs, inst)
+	fn.lines = append(fn.lines, line)
+}
+
+// opcode returns the opcode named name.
+func (asm *assembler) opcode(name string) Opcode {
+	for op := Opcode(0); op <= EXTRAARG; op++ {
+		if strings.EqualFold(op.String(), name) {
+			return op
+		}
+	}
+	asm.check(false, "unknown opcode %s", name)
+	return 0
+}
+
+// rk returns the B or C operand s (a register, or a constant if negative).
+func (asm *assembler) rk(s string) int {
+	x := asm.arg(s, Kst(MaxIndexRK), MaxArgB)
+	if x < 0 {
+		return RK(Kst(x))
+	}
+	return x
+}
+
+// arg returns the operand s, which must be within min and max.
+func (asm *assembler) arg(s string, min, max int) int {
+	x, err := strconv.Atoi(s)
+	asm.check(err == nil, "invalid operand %s", s)
+	asm.check(min <= x && x <= max, "operand %s out of range", s)
+	return x
+}
+
+// kst assembles the entry text of a constants section.
+func (asm *assembler) kst(text string) {
+	fn := asm.fn
+	i := strings.IndexAny(text, " \t")
+	asm.check(i > 0, "invalid constant %s", text)
+	asm.check(text[:i] == strconv.Itoa(len(fn.proto.Consts)+1), "constant %s out of order", text[:i])
+
+	var (
+		val  = strings.TrimSpace(text[i:])
+		rest string
+		kst  Const
+	)
+	if strings.HasPrefix(val, `"`) {
+		q, err := strconv.QuotedPrefix(val)
+		asm.check(err == nil, "invalid string constant %s", val)
+		s, _ := strconv.Unquote(q)
+		kst, rest = s, val[len(q):]
+	} else {
+		if i := strings.IndexAny(val, " \t;"); i >= 0 {
+			val, rest = val[:i], val[i:]
+		}
+		switch val {
+		case "nil":
+			kst = nil
+		case "true", "false":
+			kst = val == "true"
+		case "inf":
+			kst = math.Inf(+1)
+		case "-inf":
+			kst = math.Inf(-1)
+		case "nan":
+			kst = math.NaN()
+		default:
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				kst = n
+			} else if f, err := strconv.ParseFloat(val, 64); err == nil {
+				kst = f
+			} else {
+				asm.check(false, "invalid constant %s", val)
+			}
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	asm.check(rest == "" || rest[0] == ';', "unexpected %s after constant", rest)
+	fn.proto.Consts = append(fn.proto.Consts, kst)
+}
+
+// finish completes the current function: jumps to labels are resolved,
+// and the sections are checked against the header.
+func (asm *assembler) finish() {
+	fn := asm.fn
+	if fn == nil {
+		return
+	}
+	if fn.counts == nil {
+		asm.fail(fn.line, "missing function header")
+	}
+	for _, jump := range fn.jumps {
+		to, ok := fn.labels[jump.label]
+		if !ok {
+			asm.fail(jump.line, "undefined label %s", jump.label)
+		}
+		fn.proto.Instrs[jump.pc].SetSBX(to - (jump.pc + 1))
+	}
+	// (line information is stripped if no instruction has a line)
+	for _, line := range fn.lines {
+		if line >= 0 {
+			fn.proto.PcLine = make([]int32, len(fn.lines))
+			break
+		}
+	}
+	for pc := range fn.proto.PcLine {
+		if line := fn.lines[pc]; line > 0 {
+			fn.proto.PcLine[pc] = line
+		}
+	}
+	var (
+		p     = fn.proto
+		found = map[string]int{
+			"constants": len(p.Consts),
+			"locals":    len(p.Locals),
+			"upvalues":  len(p.UpVars),
+		}
+	)
+	for _, sect := range []string{"constants", "locals", "upvalues"} {
+		n, ok := fn.sects[sect]
+		if !ok && fn.counts[sect] > 0 {
+			asm.fail(fn.line, "missing %s (full listing required)", sect)
+		}
+		if ok && (n != found[sect] || n != fn.counts[sect]) {
+			asm.fail(fn.line, "%d %s declared, %d found", fn.counts[sect], sect, found[sect])
+		}
+	}
+	asm.fn = nil
+}
+
+// link nests the functions and returns the main function.
+func (asm *assembler) link() *Proto {
+	asm.finish()
+	if len(asm.funcs) == 0 {
+		asm.fail(asm.line, "missing main function")
+	}
+	stack := asm.funcs[:1]
+	for _, fn := range asm.funcs[1:] {
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if len(top.proto.Protos) < top.counts["functions"] {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			asm.fail(fn.line, "function not nested in any function")
+		}
+		top := stack[len(stack)-1]
+		top.proto.Protos = append(top.proto.Protos, fn.proto)
+		stack = append(stack, fn)
+	}
+	for _, fn := range asm.funcs {
+		if n := fn.counts["functions"]; len(fn.proto.Protos) != n {
+			asm.fail(fn.line, "%d functions declared, %d found", n, len(fn.proto.Protos))
+		}
+	}
+	return asm.funcs[0].proto
+}
+
+func (asm *assembler) atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	asm.check(err == nil, "invalid number %s", s)
+	return n
+}
+
+// check raises an error at the current line if cond is false.
+func (asm *assembler) check(cond bool, format string, args ...interface{}) {
+	if !cond {
+		asm.fail(asm.line, format, args...)
+	}
+}
+
+func (asm *assembler) fail(line int, format string, args ...interface{}) {
+	panic(Error(fmt.Sprintf("%s:%d: %s", chunkID(asm.name), line, fmt.Sprintf(format, args...))))
+}
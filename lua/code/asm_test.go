@@ -0,0 +1,124 @@
+package code_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+)
+
+// addresses matches the addresses of the functions in listings, which
+// differ between chunks.
+var addresses = regexp.MustCompile(`(at|for|;) 0x[0-9a-f]+`)
+
+func printListing(chunk *code.Chunk) string {
+	var b strings.Builder
+	chunk.Print(&b, true)
+	return addresses.ReplaceAllString(b.String(), "$1 0x0")
+}
+
+func TestAssembleCorpus(t *testing.T) {
+	for name, chunk := range corpus(t) {
+		src := printListing(chunk)
+		back, err := code.Assemble("@"+name, src)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := printListing(back); got != src {
+			t.Errorf("%s: listing of assembled chunk differs:\n%s", name, diffLines(src, got))
+		}
+		for _, strip := range []bool{false, true} {
+			var want, got bytes.Buffer
+			chunk.Dump(&want, strip)
+			back.Dump(&got, strip)
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("%s (strip %t): assembled chunk dumps differently", name, strip)
+			}
+		}
+	}
+}
+
+// diffLines returns the first line which differs in a and b.
+func diffLines(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return "-" + x[i] + "\n+" + y[i]
+		}
+	}
+	return "(different lengths)"
+}
+
+func TestAssemble(t *testing.T) {
+	const src = `
+; sum of 1..n
+main <sum.s:0,0>
+1 param, 5 slots, 0 upvalues, 1 local, 2 constants, 0 functions
+	LOADK    1 -1	; 0
+	LOADK    2 -2
+	MOVE     3 0
+	JMP      0 test
+loop:	ADD      1 1 2
+	ADD      2 2 -2
+test:	LE       1 2 3
+	JMP      0 loop
+	RETURN   1 2
+constants (2)
+	1	0
+	2	1
+locals (1)
+	0	n	1	10
+upvalues (0)
+`
+	chunk, err := code.Assemble("=sum", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := chunk.Main
+	if main.ParamN != 1 || main.StackN != 5 || main.Source != "=sum" || len(main.Instrs) != 9 {
+		t.Fatalf("got %d params, %d slots, source %q, %d instructions", main.ParamN, main.StackN, main.Source, len(main.Instrs))
+	}
+	for pc, want := range map[int]int{3: 2, 7: -4} {
+		if inst := main.Instrs[pc]; inst.Code() != code.JMP || inst.SBX() != want {
+			t.Errorf("pc %d: got %v, want JMP to %+d", pc+1, inst, want)
+		}
+	}
+	if len(main.PcLine) != 0 {
+		t.Errorf("got lines %v, want none", main.PcLine)
+	}
+	if loc := main.Locals[0]; loc.Name != "n" || loc.Live != 0 || loc.Dead != 9 {
+		t.Errorf("got local %+v", *loc)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	const header = "main <t:0,0>\n0+ params, 2 slots, 0 upvalues, 0 locals, 0 constants, 0 functions\n"
+	const sections = "constants (0)\nlocals (0)\nupvalues (0)\n"
+	for _, test := range []struct {
+		name, src, err string
+	}{
+		{"NoHeader", "RETURN 0 1\n", "t:1: missing function header"},
+		{"NoMain", "", "missing main function"},
+		{"Opcode", header + "NOPE 0 1\n" + sections, "t:3: unknown opcode NOPE"},
+		{"Operands", header + "RETURN 0\n" + sections, "RETURN expects 2 operands"},
+		{"Range", header + "MOVE 0 1000\n" + sections, "operand 1000 out of range"},
+		{"Label", header + "JMP 0 nowhere\nRETURN 0 1\n" + sections, "t:3: undefined label nowhere"},
+		{"Duplicate", header + "a: a: RETURN 0 1\n" + sections, "duplicate label a"},
+		{"Sections", strings.Replace(header, "0 constants", "1 constant", 1) + "RETURN 0 1\n", "missing constants (full listing required)"},
+		{"Count", header + "RETURN 0 1\nconstants (1)\n1\t2\nlocals (0)\nupvalues (0)\n", "0 constants declared, 1 found"},
+		{"Constant", header + "RETURN 0 1\nconstants (1)\n1\t\"abc\nlocals (0)\nupvalues (0)\n", "invalid string constant"},
+		{"Order", header + "RETURN 0 1\nconstants (2)\n2\t1\n1\t2\nlocals (0)\nupvalues (0)\n", "constant 2 out of order"},
+		{"Functions", strings.Replace(header, "0 functions", "1 function", 1) + "RETURN 0 1\n" + sections, "1 functions declared, 0 found"},
+		{"Verify", header + "MOVE 0 1\n" + sections, "t:"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := code.Assemble("=t", test.src)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
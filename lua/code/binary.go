@@ -3,7 +3,9 @@ package code
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 )
 
 const (
@@ -17,8 +19,6 @@ const (
 	signature = "\x1bLua"
 )
 
-var order = binary.LittleEndian
-
 var (
 	head = [...]byte{0x1B, 0x4C, 0x75, 0x61}
 	tail = [...]byte{0x19, 0x93, '\r', '\n', 0x1A, '\n'}
@@ -62,56 +62,137 @@ const (
 	LUA_GO_CLOSURE = LUA_TYPE_FUNC | (2 << 4)   // go closure
 )
 
-type header struct {
-	Signature  [4]byte
-	Version    byte
-	Format     byte
-	LuacData   [6]byte
-	GoIntSize  byte
-	SizetSize  byte
-	InstrSize  byte
-	LuaIntSize byte
-	LuaNumSize byte
-	LuacIntEnc int64
-	LuacNumEnc float64
+// Layout describes the layout of binary chunks: their byte order and the
+// sizes of the C types of the reference implementation which they encode,
+// i.e. int, size_t, Instruction, lua_Integer and lua_Number (each either
+// 4 or 8 bytes long; 4 bytes numbers are floats).
+//
+// The layout of the chunks dumped by luac 5.3 depends on its platform (e.g.
+// 32-bit or big-endian ones); it is recorded in their header so that Undump
+// reads chunks of any layout.
+type Layout struct {
+	Order       binary.ByteOrder
+	IntSize     int
+	SizetSize   int
+	InstrSize   int
+	IntegerSize int
+	NumberSize  int
+}
+
+// DefaultLayout is the layout of chunks dumped by luac 5.3 on 64-bit
+// little-endian platforms (e.g. amd64), used by Chunk.Dump.
+var DefaultLayout = Layout{
+	Order:       binary.LittleEndian,
+	IntSize:     CINT_SIZE,
+	SizetSize:   CSIZET_SIZE,
+	InstrSize:   INSTRUCTION_SIZE,
+	IntegerSize: LUA_INTEGER_SIZE,
+	NumberSize:  LUA_NUMBER_SIZE,
 }
 
+// check returns an error if the layout is not supported.
+func (l Layout) check() error {
+	if l.Order == nil {
+		return errors.New("missing byte order")
+	}
+	for _, size := range []struct {
+		name string
+		size int
+	}{
+		{"int", l.IntSize},
+		{"size_t", l.SizetSize},
+		{"Instruction", l.InstrSize},
+		{"lua_Integer", l.IntegerSize},
+		{"lua_Number", l.NumberSize},
+	} {
+		if size.size != 4 && size.size != 8 {
+			return fmt.Errorf("unsupported %s size %d", size.name, size.size)
+		}
+	}
+	return nil
+}
+
+// source encodes/decodes a binary chunk with layout fmt.
 type source struct {
-	ord   binary.ByteOrder
+	fmt   Layout
 	src   *bytes.Buffer
 	name  string
 	strip bool
 }
 
-func (bin *source) write(data interface{}) error {
-	return binary.Write(bin.src, bin.ord, data)
+func (bin *source) write(data interface{}) {
+	must(binary.Write(bin.src, bin.fmt.Order, data))
+}
+
+// writeInt writes n as a C int.
+func (bin *source) writeInt(n int) {
+	if bin.fmt.IntSize == 4 {
+		check(n == int(int32(n)), "int overflow")
+		bin.write(int32(n))
+	} else {
+		bin.write(int64(n))
+	}
+}
+
+// writeSize writes n as a C size_t.
+func (bin *source) writeSize(n int) {
+	if bin.fmt.SizetSize == 4 {
+		check(n == int(uint32(n)), "size_t overflow")
+		bin.write(uint32(n))
+	} else {
+		bin.write(uint64(n))
+	}
+}
+
+func (bin *source) writeInstr(inst Instr) {
+	if bin.fmt.InstrSize == 4 {
+		bin.write(uint32(inst))
+	} else {
+		bin.write(uint64(inst))
+	}
+}
+
+// writeInteger writes i as a lua_Integer.
+func (bin *source) writeInteger(i int64) {
+	if bin.fmt.IntegerSize == 4 {
+		check(i == int64(int32(i)), fmt.Sprintf("integer constant %d overflows lua_Integer", i))
+		bin.write(int32(i))
+	} else {
+		bin.write(i)
+	}
 }
 
-func (bin *source) writeHeader() error {
-	h := &header{
-		Version:    LUAC_VERSION,
-		Format:     LUAC_FORMAT,
-		GoIntSize:  CINT_SIZE,
-		SizetSize:  CSIZET_SIZE,
-		InstrSize:  INSTRUCTION_SIZE,
-		LuaIntSize: LUA_INTEGER_SIZE,
-		LuaNumSize: LUA_NUMBER_SIZE,
-		LuacIntEnc: LUAC_INT,
-		LuacNumEnc: LUAC_NUM,
+// writeNumber writes f as a lua_Number.
+func (bin *source) writeNumber(f float64) {
+	if bin.fmt.NumberSize == 4 {
+		bin.write(float32(f))
+	} else {
+		bin.write(f)
 	}
-	copy(h.Signature[:], LUA_SIGNATURE)
-	copy(h.LuacData[:], LUAC_DATA)
-	return bin.write(h)
+}
+
+func (bin *source) writeHeader() {
+	bin.write(head)
+	bin.write(byte(LUAC_VERSION))
+	bin.write(byte(LUAC_FORMAT))
+	bin.write(tail)
+	bin.write(byte(bin.fmt.IntSize))
+	bin.write(byte(bin.fmt.SizetSize))
+	bin.write(byte(bin.fmt.InstrSize))
+	bin.write(byte(bin.fmt.IntegerSize))
+	bin.write(byte(bin.fmt.NumberSize))
+	bin.writeInteger(LUAC_INT)
+	bin.writeNumber(LUAC_NUM)
 }
 
 func (bin *source) writeProto(fn *Proto, srcID string) {
 	if bin.strip || fn.Source == srcID {
-		bin.writeStr("")
+		bin.writeName("")
 	} else {
-		bin.writeStr(fn.Source)
+		bin.writeName(fn.Source)
 	}
-	bin.write(uint32(fn.SrcPos))
-	bin.write(uint32(fn.EndPos))
+	bin.writeInt(fn.SrcPos)
+	bin.writeInt(fn.EndPos)
 	bin.write(byte(fn.ParamN))
 	bin.write(byte(b2i(fn.Vararg)))
 	bin.write(byte(fn.StackN))
@@ -124,14 +205,14 @@ func (bin *source) writeProto(fn *Proto, srcID string) {
 }
 
 func (bin *source) writeInstrs(fn *Proto) {
-	bin.write(uint32(len(fn.Instrs)))
+	bin.writeInt(len(fn.Instrs))
 	for _, inst := range fn.Instrs {
-		bin.write(inst)
+		bin.writeInstr(inst)
 	}
 }
 
 func (bin *source) writeConsts(fn *Proto) {
-	bin.write(uint32(len(fn.Consts)))
+	bin.writeInt(len(fn.Consts))
 	for _, kst := range fn.Consts {
 		switch kst := kst.(type) {
 		case string:
@@ -143,13 +224,13 @@ func (bin *source) writeConsts(fn *Proto) {
 			bin.writeStr(string(kst))
 		case float64:
 			bin.write(byte(LUA_NUM_FLOAT))
-			bin.write(float64(kst))
+			bin.writeNumber(kst)
 		case bool:
 			bin.write(byte(LUA_TYPE_BOOL))
 			bin.write(byte(b2i(bool(kst))))
 		case int64:
 			bin.write(byte(LUA_NUM_INT))
-			bin.write(int64(kst))
+			bin.writeInteger(kst)
 		default:
 			if kst == nil {
 				bin.write(byte(LUA_TYPE_NIL))
@@ -161,7 +242,7 @@ func (bin *source) writeConsts(fn *Proto) {
 }
 
 func (bin *source) writeUpVars(fn *Proto) {
-	bin.write(uint32(len(fn.UpVars)))
+	bin.writeInt(len(fn.UpVars))
 	for _, up := range fn.UpVars {
 		bin.write(byte(b2i(up.Stack)))
 		bin.write(byte(up.Index))
@@ -169,9 +250,9 @@ func (bin *source) writeUpVars(fn *Proto) {
 }
 
 func (bin *source) writeProtos(fn *Proto) {
-	bin.write(uint32(len(fn.Protos)))
-	for _, fn := range fn.Protos {
-		bin.writeProto(fn, "")
+	bin.writeInt(len(fn.Protos))
+	for _, p := range fn.Protos {
+		bin.writeProto(p, fn.Source)
 	}
 }
 
@@ -186,262 +267,321 @@ func (bin *source) writeDebug(fn *Proto) {
 		localsN = 0
 		upvarsN = 0
 	}
-	bin.write(uint32(pclines))
+	bin.writeInt(pclines)
 	for i := 0; i < pclines; i++ {
-		bin.write(uint32(fn.PcLine[i]))
+		bin.writeInt(int(fn.PcLine[i]))
 	}
-	bin.write(uint32(localsN))
+	bin.writeInt(localsN)
 	for i := 0; i < localsN; i++ {
-		bin.writeStr(fn.Locals[i].Name)
-		bin.write(uint32(fn.Locals[i].Live))
-		bin.write(uint32(fn.Locals[i].Dead))
+		bin.writeName(fn.Locals[i].Name)
+		bin.writeInt(int(fn.Locals[i].Live))
+		bin.writeInt(int(fn.Locals[i].Dead))
 	}
-	bin.write(uint32(upvarsN))
+	bin.writeInt(upvarsN)
 	for i := 0; i < upvarsN; i++ {
-		bin.writeStr(fn.UpVars[i].Name)
+		bin.writeName(fn.UpVars[i].Name)
 	}
 }
 
+// writeStr writes string s.
 func (bin *source) writeStr(s string) {
+	if size := len(s) + 1; size < 0xFF {
+		bin.write(byte(size))
+	} else {
+		bin.write(byte(0xFF))
+		bin.writeSize(size)
+	}
+	bin.write([]byte(s))
+}
+
+// writeName writes the source or debug name s; like luac, the missing names
+// of stripped chunks and the sources of nested prototypes ("") are written
+// as NULL strings, unlike empty string constants.
+func (bin *source) writeName(s string) {
 	if s == "" {
-		bin.write(byte(1))
+		bin.write(byte(0))
 	} else {
-		if size := len(s) + 1; size < 0xFF {
-			bin.write(byte(size))
-		} else {
-			bin.write(byte(0xFF))
-			bin.write(uint64(size))
-		}
-		bin.write([]byte(s))
+		bin.writeStr(s)
 	}
 }
 
-func checkHeader(buf *bytes.Buffer) {
-	var h header
-	must(binary.Read(buf, order, &h))
-	check(h.Signature == head, "not a precompiled chunk")
-	check(h.Version == LUAC_VERSION, "version mismatch")
-	check(h.Format == LUAC_FORMAT, "format mismatch")
-	check(h.LuacData == tail, "corrupted")
-	check(h.GoIntSize == CINT_SIZE, "int size mismatch")
-	check(h.SizetSize == CSIZET_SIZE, "size_t size mismatch")
-	check(h.InstrSize == INSTRUCTION_SIZE, "instruction size mismatch")
-	check(h.LuaIntSize == LUA_INTEGER_SIZE, "lua integer size mismatch")
-	check(h.LuaNumSize == LUA_NUMBER_SIZE, "lua number size mismatch")
-	check(h.LuacIntEnc == LUAC_INT, "endianess mismatch")
-	check(h.LuacNumEnc == LUAC_NUM, "float format mismatch")
+func (bin *source) read(data interface{}) {
+	must(binary.Read(bin.src, bin.fmt.Order, data))
 }
 
-func decodeChunk(r *bytes.Buffer, main *Proto, src string) {
-	checkHeader(r)
+func (bin *source) readByte() byte {
+	b, err := bin.src.ReadByte()
+	must(err)
+	return b
+}
+
+// readUint reads an unsigned integer of size bytes.
+func (bin *source) readUint(size int) uint64 {
+	if size == 4 {
+		var u32 uint32
+		bin.read(&u32)
+		return uint64(u32)
+	}
+	var u64 uint64
+	bin.read(&u64)
+	return u64
+}
+
+// readInt reads a C int.
+func (bin *source) readInt() int {
+	if bin.fmt.IntSize == 4 {
+		var i32 int32
+		bin.read(&i32)
+		return int(i32)
+	}
+	var i64 int64
+	bin.read(&i64)
+	return int(i64)
+}
+
+func (bin *source) readInstr() Instr {
+	u := bin.readUint(bin.fmt.InstrSize)
+	check(u == uint64(Instr(u)), "corrupted")
+	return Instr(u)
+}
+
+// readInteger reads a lua_Integer.
+func (bin *source) readInteger() int64 {
+	if bin.fmt.IntegerSize == 4 {
+		var i32 int32
+		bin.read(&i32)
+		return int64(i32)
+	}
+	var i64 int64
+	bin.read(&i64)
+	return i64
+}
+
+// readNumber reads a lua_Number.
+func (bin *source) readNumber() float64 {
+	if bin.fmt.NumberSize == 4 {
+		var f32 float32
+		bin.read(&f32)
+		return float64(f32)
+	}
+	var f64 float64
+	bin.read(&f64)
+	return f64
+}
+
+// readHeader reads the chunk header and sets the layout of the chunk
+// from it; the byte order is the one in which LUAC_INT is encoded.
+func (bin *source) readHeader() {
+	var (
+		sig  [len(head)]byte
+		data [len(tail)]byte
+	)
+	bin.read(&sig)
+	check(sig == head, "not a")
+	check(bin.readByte() == LUAC_VERSION, "version mismatch in")
+	check(bin.readByte() == LUAC_FORMAT, "format mismatch in")
+	bin.read(&data)
+	check(data == tail, "corrupted")
+	for _, size := range []struct {
+		name string
+		size *int
+	}{
+		{"int", &bin.fmt.IntSize},
+		{"size_t", &bin.fmt.SizetSize},
+		{"Instruction", &bin.fmt.InstrSize},
+		{"lua_Integer", &bin.fmt.IntegerSize},
+		{"lua_Number", &bin.fmt.NumberSize},
+	} {
+		*size.size = int(bin.readByte())
+		check(*size.size == 4 || *size.size == 8, size.name+" size mismatch in")
+	}
+	check(bin.src.Len() >= bin.fmt.IntegerSize, "truncated")
+	probe := bin.src.Bytes()[:bin.fmt.IntegerSize]
+	switch {
+	case int64(binary.LittleEndian.Uint32(probe)) == LUAC_INT:
+		bin.fmt.Order = binary.LittleEndian
+	case int64(binary.BigEndian.Uint32(probe[len(probe)-4:])) == LUAC_INT:
+		bin.fmt.Order = binary.BigEndian
+	}
+	check(bin.fmt.Order != nil && bin.readInteger() == LUAC_INT, "endianness mismatch in")
+	check(bin.readNumber() == LUAC_NUM, "float format mismatch in")
+}
+
+func (bin *source) readChunk(main *Proto) {
+	bin.readHeader()
 
 	// decode size_upvalues (?)
-	_, err := r.ReadByte()
-	must(err)
+	bin.readByte()
 
 	// decode main prototype
-	decodeProto(r, main, src)
+	bin.readProto(main, bin.name)
 }
 
-func decodeProto(r *bytes.Buffer, p *Proto, src string) {
+func (bin *source) readProto(p *Proto, src string) {
 	// decode source name string (b[0] == length)
-	// b[0] == 0xFF ? uint64 : size
-	if p.Source = decodeString(r); p.Source == "" || p.Source == "=stdin" {
+	// b[0] == 0xFF ? size_t : size
+	//
+	// stripped chunks and nested prototypes defined in the same source as
+	// their parent have no source name.
+	if p.Source = bin.readStr(); p.Source == "" {
 		p.Source = src
 	}
 
 	// decode line start
-	{
-		var u32 uint32
-		must(binary.Read(r, order, &u32))
-		p.SrcPos = int(u32)
-	}
+	p.SrcPos = bin.readInt()
 
 	// decode line end
-	{
-		var u32 uint32
-		must(binary.Read(r, order, &u32))
-		p.EndPos = int(u32)
-	}
+	p.EndPos = bin.readInt()
 
 	// decode number of parameters
-	{
-		var u8 byte
-		must(binary.Read(r, order, &u8))
-		p.ParamN = int(u8)
-	}
+	p.ParamN = int(bin.readByte())
 
 	// decode is varadic
-	{
-		var u8 byte
-		must(binary.Read(r, order, &u8))
-		p.Vararg = (u8 == 1)
-	}
+	p.Vararg = (bin.readByte() == 1)
 
 	// decode maximum stack size
-	{
-		var u8 byte
-		must(binary.Read(r, order, &u8))
-		p.StackN = int(u8)
-	}
+	p.StackN = int(bin.readByte())
 
 	// decode instruction bytecode
 	//
-	// leading 4-bytes is number of instructions
+	// leading int is number of instructions
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
+		num := bin.readCount(bin.fmt.InstrSize)
 		p.Instrs = make([]Instr, num)
 		for i := range p.Instrs {
-			var u32 uint32
-			must(binary.Read(r, order, &u32))
-			p.Instrs[i] = Instr(u32)
+			p.Instrs[i] = bin.readInstr()
 		}
 	}
 
 	// decode constants
 	//
-	// leading 4-bytes is number of constants
+	// leading int is number of constants
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
+		num := bin.readCount(1)
 		p.Consts = make([]Const, num)
 		for i := range p.Consts {
-			p.Consts[i] = decodeConst(r)
+			p.Consts[i] = bin.readConst()
 		}
 	}
 
 	// decode upvalues
 	//
-	// leading 4-bytes is number of upvalues
+	// leading int is number of upvalues
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
+		num := bin.readCount(2)
 		p.UpVars = make([]*UpVar, num)
 		for i := range p.UpVars {
 			var up UpVar
 			// decode upvalue instack
-			{
-				var u8 byte
-				must(binary.Read(r, order, &u8))
-				up.Stack = (u8 == 1)
-			}
+			up.Stack = (bin.readByte() == 1)
 			// decode upvalue index
-			{
-				var u8 byte
-				must(binary.Read(r, order, &u8))
-				up.Index = int(u8)
-			}
+			up.Index = int(bin.readByte())
 			p.UpVars[i] = &up
-
 		}
 	}
 
 	// decode nested closure prototypes
 	//
-	// leading 4-bytes is number of prototypes
+	// leading int is number of prototypes
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
+		num := bin.readCount(1)
 		p.Protos = make([]*Proto, num)
 		for i := range p.Protos {
 			var fn Proto
-			decodeProto(r, &fn, src)
+			bin.readProto(&fn, p.Source)
 			p.Protos[i] = &fn
 		}
 	}
 
 	// decode line info (pc -> line)
 	//
-	// leading 4-bytes is number of pcln entries
+	// leading int is number of pcln entries
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
+		num := bin.readCount(bin.fmt.IntSize)
 		p.PcLine = make([]int32, num)
 		for i := range p.PcLine {
-			must(binary.Read(r, order, &p.PcLine[i]))
+			p.PcLine[i] = int32(bin.readInt())
 		}
 	}
 
 	// decode local variables
 	//
-	// leading 4-bytes is number of local entries
+	// leading int is number of local entries
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
+		num := bin.readCount(1 + 2*bin.fmt.IntSize)
 		p.Locals = make([]*Local, num)
 		for i := range p.Locals {
-			local := &Local{Name: decodeString(r)}
-			must(binary.Read(r, order, &local.Live))
-			must(binary.Read(r, order, &local.Dead))
+			local := &Local{Name: bin.readStr()}
+			local.Live = int32(bin.readInt())
+			local.Dead = int32(bin.readInt())
 			p.Locals[i] = local
 		}
 	}
 
 	// decode upvalue names
 	//
-	// leading 4-bytes is number of name entries
+	// leading int is number of name entries
 	{
-		var num uint32
-		must(binary.Read(r, order, &num))
-		for i := range p.UpVars {
-			p.UpVars[i].Name = decodeString(r)
+		num := bin.readCount(1)
+		check(num <= len(p.UpVars), "corrupted")
+		for i := 0; i < num; i++ {
+			p.UpVars[i].Name = bin.readStr()
 		}
 	}
 }
 
-func decodeConst(r *bytes.Buffer) Const {
-	t, err := r.ReadByte()
-	must(err)
-	switch t {
+func (bin *source) readConst() Const {
+	switch t := bin.readByte(); t {
 	case LUA_TYPE_NIL: // NIL
 		return nil
 
 	case LUA_TYPE_BOOL: // BOOL
-		b, err := r.ReadByte()
-		must(err)
-		if b == 0 {
+		switch bin.readByte() {
+		case 0:
 			return false
-		}
-		if b == 1 {
+		case 1:
 			return true
 		}
-		panic(fmt.Errorf("invalid bool constant: %d", b))
 
 	case LUA_NUM_INT: // INT
-		var i64 int64
-		must(binary.Read(r, order, &i64))
-		return i64
+		return bin.readInteger()
 
 	case LUA_NUM_FLOAT: // FLOAT
-		var f64 float64
-		must(binary.Read(r, order, &f64))
-		return f64
+		return bin.readNumber()
 
 	case LUA_STR_SHORT, LUA_STR_LONG: // STRING
-		return decodeString(r)
+		return bin.readStr()
 	}
-	panic(fmt.Errorf("unexpected constant type: %d", t))
+	panic(errors.New("corrupted"))
 }
 
-func decodeString(r *bytes.Buffer) string {
-	b, err := r.ReadByte()
-	must(err)
-	switch {
-	case b == 0x00:
+// readCount reads the number of entries of a list whose entries are at
+// least size bytes long, failing early on truncated chunks.
+func (bin *source) readCount(size int) int {
+	num := bin.readInt()
+	check(num >= 0, "corrupted")
+	if uint64(num)*uint64(size) > uint64(bin.src.Len()) {
+		panic(io.ErrUnexpectedEOF)
+	}
+	return num
+}
+
+func (bin *source) readStr() string {
+	switch b := bin.readByte(); b {
+	case 0x00:
 		return ""
-	case b == 0xFF:
-		var u64 uint64
-		must(binary.Read(r, order, &u64))
-		return string(r.Next(int(u64) - 1))
+	case 0xFF:
+		return bin.readBytes(bin.readUint(bin.fmt.SizetSize) - 1)
 	default:
-		return string(r.Next(int(b) - 1))
+		return bin.readBytes(uint64(b) - 1)
 	}
 }
 
-func (bin *source) error(why string) {
-	msg := fmt.Sprintf("%s: %s precompiled chunk", bin.name, why)
-	panic(Error(msg))
+func (bin *source) readBytes(n uint64) string {
+	if n > uint64(bin.src.Len()) {
+		panic(io.ErrUnexpectedEOF)
+	}
+	return string(bin.src.Next(int(n)))
 }
 
 func isValid(data []byte) bool {
@@ -450,7 +590,7 @@ func isValid(data []byte) bool {
 
 func check(cond bool, mesg string) {
 	if !cond {
-		panic(fmt.Errorf(mesg))
+		panic(errors.New(mesg))
 	}
 }
 
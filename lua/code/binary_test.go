@@ -0,0 +1,107 @@
+package code_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// layouts are the layouts of the golden chunks of testdata/dump.
+var layouts = map[string]code.Layout{
+	"amd64": code.DefaultLayout,
+	"386":   {Order: binary.LittleEndian, IntSize: 4, SizetSize: 4, InstrSize: 4, IntegerSize: 8, NumberSize: 8},
+	"s390x": {Order: binary.BigEndian, IntSize: 4, SizetSize: 8, InstrSize: 4, IntegerSize: 8, NumberSize: 8},
+	"lua32": {Order: binary.LittleEndian, IntSize: 4, SizetSize: 4, InstrSize: 4, IntegerSize: 4, NumberSize: 4},
+}
+
+// TestDumpGolden undumps the golden chunks "layout/name.luac" of
+// testdata/dump, dumped by luac 5.3 for each layout (with -s for
+// "layout/name.s.luac", see testdata/dump/README), and checks that
+// dumping them again in their layout gives back the same bytes.
+func TestDumpGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/dump/*/*.luac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("no golden chunks: run testdata/dump/gen.sh with luac 5.3 builds")
+	}
+	for _, file := range files {
+		name := filepath.Base(filepath.Dir(file))
+		layout, ok := layouts[name]
+		if !ok {
+			t.Errorf("%s: unknown layout %q", file, name)
+			continue
+		}
+		want, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := code.Undump("=golden", want)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		var got bytes.Buffer
+		if _, err := chunk.DumpLayout(&got, strings.HasSuffix(file, ".s.luac"), layout); err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s: dump differs at byte %d", file, mismatch(got.Bytes(), want))
+		}
+	}
+}
+
+// TestDumpLayouts checks that the chunks of the corpus dumped in each
+// layout are undumped and dumped again unchanged.
+func TestDumpLayouts(t *testing.T) {
+	for name, chunk := range corpus(t) {
+		for lname, layout := range layouts {
+			for _, strip := range []bool{false, true} {
+				var bin bytes.Buffer
+				if _, err := chunk.DumpLayout(&bin, strip, layout); err != nil {
+					if layout.IntegerSize == 4 && strings.Contains(err.Error(), "overflows lua_Integer") {
+						continue // not representable with LUA_32BITS
+					}
+					t.Fatalf("%s (%s): %v", name, lname, err)
+				}
+				back, err := code.Undump("@"+name, bin.Bytes())
+				if err != nil {
+					t.Fatalf("%s (%s): %v", name, lname, err)
+				}
+				var again bytes.Buffer
+				if _, err := back.DumpLayout(&again, strip, layout); err != nil {
+					t.Fatalf("%s (%s): %v", name, lname, err)
+				}
+				if !bytes.Equal(again.Bytes(), bin.Bytes()) {
+					t.Errorf("%s (%s, strip %t): undumped chunk dumps differently at byte %d", name, lname, strip, mismatch(again.Bytes(), bin.Bytes()))
+				}
+			}
+		}
+	}
+}
+
+// mismatch returns the offset of the first byte differing in a and b.
+func mismatch(a, b []byte) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
+
+func compileFile(file string) (*code.Chunk, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return luac.Compile(luac.Defaults, "@"+filepath.Base(file), src)
+}
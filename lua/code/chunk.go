@@ -2,32 +2,76 @@ package code
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"strings"
 )
 
 type Chunk struct {
 	Main *Proto
 }
 
-// func Undump(name string, src interface{}) (*Chunk, error) {
-// 	if len(name) > 1 && (name[0] == '@' || name[0] == '=') {
-// 		name = name[1:]
-// 	}
-// 	b, err := readSource(name, src)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	var main Proto
-// 	decodeChunk(b, &main, name)
-// 	// TODO: verifycode
-// 	return &Chunk{&main}, nil
-// }
+// Undump loads the precompiled chunk src (as produced by Chunk.Dump or
+// luac) named name; src may be a string, []byte or io.Reader, or nil to
+// read the file name. Chunks of any supported Layout are loaded.
+//
+// The prototypes of stripped chunks get name as their source. The code of
+// the chunk is checked by Verify.
+func Undump(name string, src interface{}) (chunk *Chunk, err error) {
+	b, err := readSource(name, src)
+	if err != nil {
+		return nil, err
+	}
+	var main Proto
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			why := e.Error()
+			if e == io.EOF || e == io.ErrUnexpectedEOF {
+				why = "truncated"
+			}
+			chunk, err = nil, Error(fmt.Sprintf("%s: %s precompiled chunk", chunkID(name), why))
+		}
+	}()
+	r := &source{src: b, name: name}
+	r.readChunk(&main)
+	if err := Verify(&main); err != nil {
+		return nil, Error(fmt.Sprintf("%s: bad code in precompiled chunk (%v)", chunkID(name), err))
+	}
+	return &Chunk{&main}, nil
+}
 
+// Dump writes the chunk to out as a binary chunk in the DefaultLayout; debug
+// information is stripped if strip is set.
 func (chunk *Chunk) Dump(out io.Writer, strip bool) (int, error) {
-	w := &source{ord: order, src: new(bytes.Buffer), strip: strip}
-	n := byte(len(chunk.Main.UpVars))
-	must(w.writeHeader())
-	must(w.write(n))
+	return chunk.DumpLayout(out, strip, DefaultLayout)
+}
+
+// DumpLayout is like Dump but writes the chunk with the given layout, e.g.
+// to load it with Lua 5.3 on another platform.
+//
+// Integer constants which do not fit in the layout's lua_Integer are an
+// error; float constants are rounded to the layout's lua_Number.
+func (chunk *Chunk) DumpLayout(out io.Writer, strip bool, layout Layout) (n int, err error) {
+	if err := layout.check(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			n, err = 0, e
+		}
+	}()
+	w := &source{fmt: layout, src: new(bytes.Buffer), strip: strip}
+	w.writeHeader()
+	w.write(byte(len(chunk.Main.UpVars)))
 	w.writeProto(chunk.Main, "")
 	return out.Write(w.src.Bytes())
 }
@@ -35,3 +79,39 @@ func (chunk *Chunk) Dump(out io.Writer, strip bool) (int, error) {
 func (chunk *Chunk) Print(w io.Writer, full bool) {
 	printFunc(w, chunk.Main, full)
 }
+
+// readSource returns the bytes of src, or of the file name if src is nil.
+func readSource(name string, src interface{}) (*bytes.Buffer, error) {
+	var (
+		b   []byte
+		err error
+	)
+	switch src := src.(type) {
+	case io.Reader:
+		b, err = ioutil.ReadAll(src)
+	case string:
+		b = []byte(src)
+	case []byte:
+		b = src
+	case nil:
+		name = chunkID(name)
+		b, err = ioutil.ReadFile(name)
+	default:
+		return nil, fmt.Errorf("invalid source: %T", src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", name, err)
+	}
+	return bytes.NewBuffer(b), nil
+}
+
+// chunkID returns the name of chunk name used in messages.
+func chunkID(name string) string {
+	switch {
+	case strings.HasPrefix(name, "@"), strings.HasPrefix(name, "="):
+		return name[1:]
+	case strings.HasPrefix(name, signature):
+		return "binary string"
+	}
+	return name
+}
@@ -0,0 +1,76 @@
+package code_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+)
+
+// corpus returns the chunks compiled from the tests in lua/test/lua.
+func corpus(t *testing.T) map[string]*code.Chunk {
+	t.Helper()
+	files, err := filepath.Glob("../test/lua/*.lua")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no tests in lua/test/lua: %v", err)
+	}
+	chunks := make(map[string]*code.Chunk)
+	for _, file := range files {
+		chunk, err := compileFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks[filepath.Base(file)] = chunk
+	}
+	return chunks
+}
+
+func TestUndump(t *testing.T) {
+	for name, chunk := range corpus(t) {
+		for _, strip := range []bool{false, true} {
+			var dump bytes.Buffer
+			if _, err := chunk.Dump(&dump, strip); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			back, err := code.Undump("@"+name, dump.Bytes())
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var again bytes.Buffer
+			if _, err := back.Dump(&again, strip); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(again.Bytes(), dump.Bytes()) {
+				t.Errorf("%s (strip %t): undumped chunk dumps differently", name, strip)
+			}
+		}
+	}
+}
+
+func TestUndumpErrors(t *testing.T) {
+	var dump bytes.Buffer
+	if _, err := corpus(t)["sort.lua"].Dump(&dump, false); err != nil {
+		t.Fatal(err)
+	}
+	b := dump.Bytes()
+	tests := []struct {
+		name string
+		src  []byte
+		want string
+	}{
+		{"Truncated", b[:len(b)/2], "bad: truncated precompiled chunk"},
+		{"Header", b[:8], "truncated precompiled chunk"},
+		{"Version", append([]byte("\x1bLua\x52"), b[5:]...), "version mismatch"},
+		{"Text", []byte("return 1"), "not a precompiled chunk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := code.Undump("=bad", tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want error %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package code
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// PrintJSON writes the listing of the chunk (see Print) to w as a JSON
+// document, for tools: the main function is an object with the function's
+// header, instructions, constants, locals and upvalues, and its nested
+// functions, recursively.
+//
+// Instructions have their opcode, their operands as listed by Print
+// (constants are numbered from -1), their source line if the chunk has
+// debug information, and what their operands refer to: constants, upvalue
+// names, jump targets and nested function indexes.
+func (chunk *Chunk) PrintJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonFunc(chunk.Main))
+}
+
+type (
+	jsonProto struct {
+		Source string       `json:"source"`
+		SrcPos int          `json:"linedefined"`
+		EndPos int          `json:"lastlinedefined"`
+		ParamN int          `json:"params"`
+		Vararg bool         `json:"vararg"`
+		StackN int          `json:"slots"`
+		Instrs []jsonInstr  `json:"instructions"`
+		Consts []jsonConst  `json:"constants"`
+		Locals []jsonLocal  `json:"locals"`
+		UpVars []jsonUpVar  `json:"upvalues"`
+		Protos []*jsonProto `json:"functions"`
+	}
+
+	jsonInstr struct {
+		PC     int         `json:"pc"` // (from 1, as listed)
+		Line   int32       `json:"line,omitempty"`
+		Op     string      `json:"op"`
+		Args   []int       `json:"args"`
+		Consts []jsonConst `json:"constants,omitempty"`
+		UpVar  *string     `json:"upvalue,omitempty"`
+		Target int         `json:"target,omitempty"`
+		Proto  *int        `json:"function,omitempty"`
+	}
+
+	jsonConst struct {
+		Type  string      `json:"type"`
+		Value interface{} `json:"value"`
+	}
+
+	jsonLocal struct {
+		Name string `json:"name"`
+		Live int32  `json:"startpc"` // first pc where the local is active
+		Dead int32  `json:"endpc"`   // first pc where it is dead
+	}
+
+	jsonUpVar struct {
+		Name  string `json:"name"`
+		Stack bool   `json:"instack"`
+		Index int    `json:"idx"`
+	}
+)
+
+func jsonFunc(fn *Proto) *jsonProto {
+	p := &jsonProto{
+		Source: fn.Source,
+		SrcPos: fn.SrcPos,
+		EndPos: fn.EndPos,
+		ParamN: fn.ParamN,
+		Vararg: fn.Vararg,
+		StackN: fn.StackN,
+		Instrs: make([]jsonInstr, len(fn.Instrs)),
+		Consts: make([]jsonConst, len(fn.Consts)),
+		Locals: make([]jsonLocal, len(fn.Locals)),
+		UpVars: make([]jsonUpVar, len(fn.UpVars)),
+		Protos: make([]*jsonProto, len(fn.Protos)),
+	}
+	for pc := range fn.Instrs {
+		p.Instrs[pc] = jsonCode(fn, pc)
+	}
+	for i, kst := range fn.Consts {
+		p.Consts[i] = jsonKst(kst)
+	}
+	for i, loc := range fn.Locals {
+		p.Locals[i] = jsonLocal{loc.Name, loc.Live + 1, loc.Dead + 1}
+	}
+	for i, up := range fn.UpVars {
+		p.UpVars[i] = jsonUpVar{up.Name, up.Stack, up.Index}
+	}
+	for i, fn := range fn.Protos {
+		p.Protos[i] = jsonFunc(fn)
+	}
+	return p
+}
+
+func jsonCode(fn *Proto, pc int) jsonInstr {
+	var (
+		inst = fn.Instrs[pc]
+		op   = inst.Code()
+		mask = op.Mask()
+	)
+	j := jsonInstr{
+		PC:   pc + 1,
+		Op:   op.String(),
+		Args: operands(inst),
+	}
+	if line := funcLine(fn, pc); line > 0 {
+		j.Line = line
+	}
+	kst := func(k int) {
+		if k < len(fn.Consts) {
+			j.Consts = append(j.Consts, jsonKst(fn.Consts[k]))
+		}
+	}
+	upvar := func(u int) {
+		if u < len(fn.UpVars) {
+			j.UpVar = &fn.UpVars[u].Name
+		}
+	}
+	if op.Mode() == ModeABC {
+		if mask.B(ArgK) && IsKst(inst.B()) {
+			kst(ToKst(inst.B()))
+		}
+		if mask.C(ArgK) && IsKst(inst.C()) {
+			kst(ToKst(inst.C()))
+		}
+	}
+	switch op {
+	case LOADK:
+		kst(inst.BX())
+	case EXTRAARG:
+		if pc > 0 && fn.Instrs[pc-1].Code() == LOADKX {
+			kst(inst.AX())
+		}
+	case GETUPVAL, SETUPVAL, GETTABUP:
+		upvar(inst.B())
+	case SETTABUP:
+		upvar(inst.A())
+	case JMP, FORPREP, FORLOOP, TFORLOOP:
+		j.Target = pc + 2 + inst.SBX()
+	case CLOSURE:
+		bx := inst.BX()
+		j.Proto = &bx
+	}
+	return j
+}
+
+// jsonKst returns the JSON form of constant kst; floats which JSON cannot
+// represent (infinities and NaN) are given as strings.
+func jsonKst(kst Const) jsonConst {
+	switch kst := kst.(type) {
+	case string:
+		return jsonConst{"string", kst}
+	case float64:
+		switch {
+		case math.IsInf(kst, +1):
+			return jsonConst{"float", "inf"}
+		case math.IsInf(kst, -1):
+			return jsonConst{"float", "-inf"}
+		case math.IsNaN(kst):
+			return jsonConst{"float", "nan"}
+		}
+		return jsonConst{"float", kst}
+	case int64:
+		return jsonConst{"integer", kst}
+	case bool:
+		return jsonConst{"boolean", kst}
+	}
+	return jsonConst{"nil", nil}
+}
@@ -0,0 +1,180 @@
+package code_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// listing is the JSON listing of a function (see Chunk.PrintJSON).
+type listing struct {
+	Source string `json:"source"`
+	SrcPos int    `json:"linedefined"`
+	EndPos int    `json:"lastlinedefined"`
+	Params int    `json:"params"`
+	Vararg bool   `json:"vararg"`
+	Slots  int    `json:"slots"`
+	Instrs []struct {
+		PC     int            `json:"pc"`
+		Line   int32          `json:"line"`
+		Op     string         `json:"op"`
+		Args   []int          `json:"args"`
+		Consts []listingConst `json:"constants"`
+		UpVar  *string        `json:"upvalue"`
+		Target int            `json:"target"`
+		Proto  *int           `json:"function"`
+	} `json:"instructions"`
+	Consts []listingConst `json:"constants"`
+	Locals []struct {
+		Name  string `json:"name"`
+		Start int32  `json:"startpc"`
+		End   int32  `json:"endpc"`
+	} `json:"locals"`
+	UpVars []struct {
+		Name  string `json:"name"`
+		Stack bool   `json:"instack"`
+		Index int    `json:"idx"`
+	} `json:"upvalues"`
+	Protos []*listing `json:"functions"`
+}
+
+type listingConst struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func printJSON(t *testing.T, chunk *code.Chunk) *listing {
+	t.Helper()
+	var b bytes.Buffer
+	if err := chunk.PrintJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var l listing
+	if err := json.Unmarshal(b.Bytes(), &l); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b.Bytes())
+	}
+	return &l
+}
+
+// checkListing checks that the listing l describes the function fn.
+func checkListing(t *testing.T, name string, fn *code.Proto, l *listing) {
+	t.Helper()
+	if l.Source != fn.Source || l.SrcPos != fn.SrcPos || l.EndPos != fn.EndPos || l.Params != fn.ParamN || l.Vararg != fn.Vararg || l.Slots != fn.StackN {
+		t.Errorf("%s: header %s <%d,%d> %d %t %d", name, l.Source, l.SrcPos, l.EndPos, l.Params, l.Vararg, l.Slots)
+	}
+	if len(l.Instrs) != len(fn.Instrs) || len(l.Consts) != len(fn.Consts) || len(l.Locals) != len(fn.Locals) || len(l.UpVars) != len(fn.UpVars) || len(l.Protos) != len(fn.Protos) {
+		t.Fatalf("%s: %d instructions, %d constants, %d locals, %d upvalues, %d functions", name, len(l.Instrs), len(l.Consts), len(l.Locals), len(l.UpVars), len(l.Protos))
+	}
+	for pc, inst := range l.Instrs {
+		op := fn.Instrs[pc].Code()
+		if inst.PC != pc+1 || inst.Op != op.String() {
+			t.Errorf("%s: pc %d: got %d %s, want %s", name, pc+1, inst.PC, inst.Op, op)
+		}
+		if pc < len(fn.PcLine) && inst.Line != fn.PcLine[pc] {
+			t.Errorf("%s: pc %d: line %d, want %d", name, pc+1, inst.Line, fn.PcLine[pc])
+		}
+		if (inst.Proto != nil) != (op == code.CLOSURE) || inst.Proto != nil && *inst.Proto >= len(fn.Protos) {
+			t.Errorf("%s: pc %d: function %v", name, pc+1, inst.Proto)
+		}
+		if inst.Target < 0 || inst.Target > len(fn.Instrs) {
+			t.Errorf("%s: pc %d: target %d", name, pc+1, inst.Target)
+		}
+	}
+	for i, loc := range l.Locals {
+		if want := fn.Locals[i]; loc.Name != want.Name || loc.Start != want.Live+1 || loc.End != want.Dead+1 {
+			t.Errorf("%s: local %d: %v", name, i, loc)
+		}
+	}
+	for i, up := range l.UpVars {
+		if want := fn.UpVars[i]; up.Name != want.Name || up.Stack != want.Stack || up.Index != want.Index {
+			t.Errorf("%s: upvalue %d: %v", name, i, up)
+		}
+	}
+	for i, p := range l.Protos {
+		checkListing(t, name, fn.Protos[i], p)
+	}
+}
+
+func TestPrintJSONCorpus(t *testing.T) {
+	for name, chunk := range corpus(t) {
+		checkListing(t, name, chunk.Main, printJSON(t, chunk))
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	const src = `local t = {}
+for i = 1, 3 do
+	t[i] = "x" .. i
+end
+local function f(y) return t, y + 1.5 end
+print(f(true))`
+	chunk, err := luac.Compile(luac.Defaults, "=json", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := printJSON(t, chunk)
+	checkListing(t, "json", chunk.Main, l)
+
+	var (
+		ops []string
+		kst []listingConst
+	)
+	for _, inst := range l.Instrs {
+		ops = append(ops, inst.Op)
+		switch inst.Op {
+		case "FORPREP", "FORLOOP":
+			if got := l.Instrs[inst.Target-1].Op; inst.Op == "FORPREP" && got != "FORLOOP" || inst.Op == "FORLOOP" && got != "LOADK" {
+				t.Errorf("%s %d: target %d is %s", inst.Op, inst.PC, inst.Target, got)
+			}
+		case "LOADK":
+			kst = append(kst, inst.Consts...)
+		case "GETTABUP":
+			if inst.UpVar == nil || *inst.UpVar != "_ENV" || len(inst.Consts) != 1 || inst.Consts[0].Value != "print" {
+				t.Errorf("GETTABUP %d: upvalue %v, constants %v", inst.PC, inst.UpVar, inst.Consts)
+			}
+		case "CLOSURE":
+			if inst.Proto == nil || *inst.Proto != 0 {
+				t.Errorf("CLOSURE %d: function %v", inst.PC, inst.Proto)
+			}
+		}
+		if inst.Line == 0 {
+			t.Errorf("%s %d: no line", inst.Op, inst.PC)
+		}
+	}
+	want := []string{"NEWTABLE", "LOADK", "LOADK", "LOADK", "FORPREP", "LOADK", "MOVE", "CONCAT", "SETTABLE", "FORLOOP", "CLOSURE", "GETTABUP", "MOVE", "LOADBOOL", "CALL", "CALL", "RETURN"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("got instructions %v, want %v", ops, want)
+	}
+	if want := []listingConst{{"integer", 1.0}, {"integer", 3.0}, {"integer", 1.0}, {"string", "x"}}; !reflect.DeepEqual(kst, want) {
+		t.Errorf("got LOADK constants %v, want %v", kst, want)
+	}
+
+	f := l.Protos[0]
+	if f.SrcPos != 5 || f.Params != 1 || len(f.UpVars) != 1 || f.UpVars[0].Name != "t" || !f.UpVars[0].Stack {
+		t.Errorf("function: %+v", f)
+	}
+	if len(f.Locals) != 1 || f.Locals[0].Name != "y" || f.Locals[0].Start != 1 {
+		t.Errorf("function locals: %v", f.Locals)
+	}
+	if len(f.Consts) != 1 || f.Consts[0].Type != "float" || f.Consts[0].Value != 1.5 {
+		t.Errorf("function constants: %v", f.Consts)
+	}
+}
+
+func TestPrintJSONConsts(t *testing.T) {
+	chunk, err := luac.Compile(luac.Defaults, "=json", "return")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk.Main.Consts = []code.Const{math.Inf(1), math.Inf(-1), math.NaN(), 0.5, int64(-2), "s", true, nil}
+	got := printJSON(t, chunk).Consts
+	want := []listingConst{{"float", "inf"}, {"float", "-inf"}, {"float", "nan"}, {"float", 0.5}, {"integer", -2.0}, {"string", "s"}, {"boolean", true}, {"nil", nil}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got constants %v, want %v", got, want)
+	}
+}
@@ -3,6 +3,9 @@ package code
 import (
 	"fmt"
 	"io"
+	"math"
+	"strconv"
+	"strings"
 )
 
 func printHeader(w io.Writer, fn *Proto) {
@@ -51,7 +54,7 @@ func printConst(w io.Writer, kst Const) {
 	case string:
 		fmt.Fprintf(w, "%q", kst)
 	case float64:
-		fmt.Fprintf(w, "%f", kst)
+		fmt.Fprint(w, formatFloat(kst))
 	case bool:
 		fmt.Fprintf(w, "%t", kst)
 	case int64:
@@ -65,6 +68,24 @@ func printConst(w io.Writer, kst Const) {
 	}
 }
 
+// formatFloat formats the float constant f so that it reads back exactly
+// (see Assemble), e.g. "0.1", "1e+100" or "3.0" (but "inf" and "nan").
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
 func printDebug(w io.Writer, fn *Proto) {
 	fmt.Fprintf(w, "constants (%d) for %p:\n", len(fn.Consts), fn)
 	for i, kst := range fn.Consts {
@@ -95,8 +116,6 @@ func printDebug(w io.Writer, fn *Proto) {
 func printCode(w io.Writer, fn *Proto) {
 	for pc := 0; pc < len(fn.Instrs); pc++ {
 		inst := fn.Instrs[pc]
-		mode := inst.Code().Mode()
-		mask := inst.Code().Mask()
 
 		fmt.Fprintf(w, "\t%d\t", pc+1)
 		if line := funcLine(fn, pc); line > 0 {
@@ -106,35 +125,11 @@ func printCode(w io.Writer, fn *Proto) {
 		}
 		fmt.Fprintf(w, "%-9s\t", inst.Code())
 
-		switch mode {
-		case ModeAsBx:
-			fmt.Fprintf(w, "%d %d", inst.A(), inst.SBX())
-		case ModeABC:
-			fmt.Fprintf(w, "%d", inst.A())
-			if !mask.B(ArgN) {
-				if IsKst(inst.B()) {
-					fmt.Fprintf(w, " %d", Kst(ToKst(inst.B())))
-				} else {
-					fmt.Fprintf(w, " %d", inst.B())
-				}
-			}
-			if !mask.C(ArgN) {
-				if IsKst(inst.C()) {
-					fmt.Fprintf(w, " %d", Kst(ToKst(inst.C())))
-				} else {
-					fmt.Fprintf(w, " %d", inst.C())
-				}
-			}
-		case ModeABx:
-			fmt.Fprintf(w, "%d", inst.A())
-			if mask.B(ArgK) {
-				fmt.Fprintf(w, " %d", Kst(inst.BX()))
-			}
-			if mask.B(ArgU) {
-				fmt.Fprintf(w, " %d", inst.BX())
+		for i, arg := range operands(inst) {
+			if i > 0 {
+				fmt.Fprint(w, " ")
 			}
-		case ModeAx:
-			fmt.Fprintf(w, "%d", Kst(inst.AX()))
+			fmt.Fprintf(w, "%d", arg)
 		}
 
 		switch inst.Code() {
@@ -214,6 +209,43 @@ func printCode(w io.Writer, fn *Proto) {
 	}
 }
 
+// operands returns the operands of inst as listed: the operands which are
+// not used are omitted, constants are numbered from -1 (see Kst).
+func operands(inst Instr) (args []int) {
+	mask := inst.Code().Mask()
+	switch inst.Mode() {
+	case ModeAsBx:
+		args = append(args, inst.A(), inst.SBX())
+	case ModeABC:
+		args = append(args, inst.A())
+		if !mask.B(ArgN) {
+			args = append(args, rk(inst.B()))
+		}
+		if !mask.C(ArgN) {
+			args = append(args, rk(inst.C()))
+		}
+	case ModeABx:
+		args = append(args, inst.A())
+		if mask.B(ArgK) {
+			args = append(args, Kst(inst.BX()))
+		}
+		if mask.B(ArgU) {
+			args = append(args, inst.BX())
+		}
+	case ModeAx:
+		args = append(args, Kst(inst.AX()))
+	}
+	return args
+}
+
+// rk returns the listed operand of the register or constant x.
+func rk(x int) int {
+	if IsKst(x) {
+		return Kst(ToKst(x))
+	}
+	return x
+}
+
 func printFunc(w io.Writer, fn *Proto, full bool) {
 	printHeader(w, fn)
 	printCode(w, fn)
@@ -0,0 +1,31 @@
+#!/bin/sh
+# gen.sh dumps the golden chunks of TestDumpGolden with luac 5.3 builds for
+# each layout, given as layout=command pairs (see README):
+#
+#	./gen.sh amd64=luac 386=luac32 "s390x=qemu-s390x -L /usr/s390x-linux-gnu luac" lua32=luac-32bits
+#
+# The chunks of the sources of this directory and of the Lua test suite
+# (lua/test/lua) are written to layout/name.luac, and to layout/name.s.luac
+# when stripped.
+set -e
+
+dir=$(cd "$(dirname "$0")" && pwd)
+suite=$(cd "$dir/../../../test/lua" && pwd)
+
+for arg; do
+	layout=${arg%%=*}
+	luac=${arg#*=}
+	case $layout in
+	amd64|386|s390x|lua32) ;;
+	*) echo "gen.sh: unknown layout $layout" >&2; exit 2 ;;
+	esac
+	$luac -v | grep -q '^Lua 5\.3' || { echo "gen.sh: $luac is not luac 5.3" >&2; exit 1; }
+	mkdir -p "$dir/$layout"
+	for src in "$dir"/*.lua "$suite"/*.lua; do
+		name=$(basename "$src" .lua)
+		# compile from the source's directory so that chunks are named "@name.lua"
+		(cd "$(dirname "$src")" &&
+			$luac -o "$dir/$layout/$name.luac" "$name.lua" &&
+			$luac -s -o "$dir/$layout/$name.s.luac" "$name.lua")
+	done
+done
@@ -0,0 +1 @@
+return 1, 2.5, "", "hi", nil, true
@@ -0,0 +1,250 @@
+package code
+
+import (
+	"fmt"
+)
+
+// maxStack is the bound on the number of registers of a function
+// (MAXREGS in the reference compiler): stack sizes are below it.
+const maxStack = 255
+
+// Verify checks that the function prototype p and its nested prototypes
+// are well formed, so that running untrusted code (e.g. from a binary
+// chunk) cannot crash or corrupt the VM: every operand of every instruction
+// must be valid for its opcode (see Mask and Mode), i.e. registers within
+// StackN, constant, upvalue and prototype indexes within the function's
+// Consts, UpVars and Protos, and jump targets within Instrs.
+//
+// Verify does not check that the code is the output of a Lua compiler; a
+// verified function may still raise errors when run.
+func Verify(p *Proto) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	verify(p, nil)
+	return nil
+}
+
+// verifier checks the instructions of a function prototype.
+type verifier struct {
+	fn *Proto
+	pc int
+}
+
+func verify(fn, parent *Proto) {
+	v := &verifier{fn: fn, pc: -1}
+	v.check(fn.StackN < maxStack, "stack size %d too large", fn.StackN)
+	v.check(fn.ParamN <= fn.StackN, "%d parameters for stack size %d", fn.ParamN, fn.StackN)
+	v.check(len(fn.PcLine) == 0 || len(fn.PcLine) == len(fn.Instrs), "%d line entries for %d instructions", len(fn.PcLine), len(fn.Instrs))
+	for i, kst := range fn.Consts {
+		switch kst.(type) {
+		case nil, bool, int64, float64, string:
+		default:
+			v.check(false, "invalid constant %d of type %T", i, kst)
+		}
+	}
+	if parent != nil {
+		for i, up := range fn.UpVars {
+			if up.Stack {
+				v.check(up.Index < parent.StackN, "upvalue %d refers to register %d out of range", i, up.Index)
+			} else {
+				v.check(up.Index < len(parent.UpVars), "upvalue %d refers to upvalue %d out of range", i, up.Index)
+			}
+		}
+	}
+	n := len(fn.Instrs)
+	v.check(n > 0 && fn.Instrs[n-1].Code() == RETURN, "missing final RETURN")
+	for v.pc = 0; v.pc < n; v.pc++ {
+		v.instr(fn.Instrs[v.pc])
+	}
+	for _, p := range fn.Protos {
+		verify(p, fn)
+	}
+}
+
+func (v *verifier) instr(inst Instr) {
+	op := inst.Code()
+	v.check(op <= EXTRAARG, "invalid opcode %d", op)
+
+	var (
+		fn   = v.fn
+		mask = op.Mask()
+		a    = inst.A()
+	)
+	switch op {
+	case JMP:
+		// A-1 is the level of the upvalues to close, if A > 0.
+		v.reg(a - 1)
+	case EQ, LT, LE:
+		// A is the expected result of the comparison.
+	case SETTABUP:
+		v.upvar(a)
+	case EXTRAARG:
+		v.check(v.pc > 0, "unexpected EXTRAARG")
+		prev := fn.Instrs[v.pc-1]
+		v.check(prev.Code() == LOADKX || (prev.Code() == SETLIST && prev.C() == 0), "unexpected EXTRAARG")
+		return
+	default:
+		v.reg(a)
+	}
+
+	switch op.Mode() {
+	case ModeABC:
+		v.arg(inst.B(), ArgMask((mask>>4)&3))
+		v.arg(inst.C(), ArgMask((mask>>2)&3))
+	case ModeABx:
+		switch op {
+		case LOADK:
+			v.kst(inst.BX())
+		case CLOSURE:
+			v.check(inst.BX() < len(fn.Protos), "prototype index %d out of range", inst.BX())
+		}
+	case ModeAsBx:
+		v.jump(inst.SBX())
+	}
+
+	// test instructions are followed by a jump
+	if mask&(1<<7) != 0 {
+		v.check(v.pc+1 < len(fn.Instrs) && fn.Instrs[v.pc+1].Code() == JMP, "missing jump after test")
+	}
+
+	// open instructions (with B = 0) use the values up to the top set by the
+	// previous open call or vararg; conversely, open calls and varargs are
+	// followed by an open instruction (e.g. f(g()) or return ...).
+	if v.opens(inst) {
+		v.check(v.pc+1 < len(fn.Instrs), "missing instruction using open results")
+		next := fn.Instrs[v.pc+1]
+		v.check(v.uses(next) && next.A() <= a, "invalid instruction using open results")
+	}
+	if v.uses(inst) {
+		v.check(v.pc > 0 && v.opens(fn.Instrs[v.pc-1]), "missing open results")
+	}
+
+	b, c := inst.B(), inst.C()
+	switch op {
+	case LOADKX:
+		v.check(v.pc+1 < len(fn.Instrs) && fn.Instrs[v.pc+1].Code() == EXTRAARG, "missing EXTRAARG")
+		v.kst(fn.Instrs[v.pc+1].AX())
+	case LOADBOOL:
+		if c != 0 { // skips the next instruction
+			v.jump(1)
+		}
+	case LOADNIL:
+		v.reg(a + b)
+	case GETUPVAL, SETUPVAL, GETTABUP:
+		v.upvar(b)
+	case SELF:
+		v.reg(a + 1)
+	case CONCAT:
+		v.check(b < c, "invalid CONCAT range %d..%d", b, c)
+	case CALL, TAILCALL:
+		if b > 0 {
+			v.reg(a + b - 1)
+		}
+		if c > 0 {
+			v.reg(a + c - 2)
+		}
+	case RETURN, VARARG:
+		if b > 0 {
+			v.reg(a + b - 2)
+		}
+		if op == VARARG {
+			v.check(fn.Vararg, "VARARG in a function which is not vararg")
+		}
+	case FORPREP, FORLOOP:
+		v.reg(a + 3)
+	case TFORCALL:
+		// (the iterator is called at R(A+3), with 2 arguments)
+		v.reg(a + 5)
+		v.reg(a + 2 + c)
+		v.check(v.pc+1 < len(fn.Instrs) && fn.Instrs[v.pc+1].Code() == TFORLOOP, "missing TFORLOOP")
+	case TFORLOOP:
+		v.reg(a + 1)
+	case SETLIST:
+		if b > 0 {
+			v.reg(a + b)
+		}
+		if c == 0 {
+			v.check(v.pc+1 < len(fn.Instrs) && fn.Instrs[v.pc+1].Code() == EXTRAARG, "missing EXTRAARG")
+		}
+	}
+}
+
+// arg checks the B or C argument x of an iABC instruction.
+func (v *verifier) arg(x int, mode ArgMask) {
+	switch mode {
+	case ArgR:
+		v.reg(x)
+	case ArgK:
+		if IsKst(x) {
+			v.kst(ToKst(x))
+		} else {
+			v.reg(x)
+		}
+	}
+}
+
+// opens reports whether inst sets the top for the next instruction.
+func (v *verifier) opens(inst Instr) bool {
+	switch inst.Code() {
+	case TAILCALL: // (followed by RETURN for Go functions)
+		return true
+	case CALL:
+		return inst.C() == 0
+	case VARARG:
+		return inst.B() == 0
+	}
+	return false
+}
+
+// uses reports whether inst uses the top set by the previous instruction.
+func (v *verifier) uses(inst Instr) bool {
+	switch inst.Code() {
+	case CALL, TAILCALL, RETURN, SETLIST:
+		return inst.B() == 0
+	}
+	return false
+}
+
+func (v *verifier) reg(r int) {
+	v.check(r < v.fn.StackN, "register %d out of range (stack size %d)", r, v.fn.StackN)
+}
+
+func (v *verifier) kst(k int) {
+	v.check(k < len(v.fn.Consts), "constant %d out of range", k)
+}
+
+func (v *verifier) upvar(u int) {
+	v.check(u < len(v.fn.UpVars), "upvalue %d out of range", u)
+}
+
+// jump checks the jump of sBx instructions from the current instruction:
+// the target must be an instruction which does not depend on the previous
+// one (i.e. neither EXTRAARG nor an instruction using open results).
+func (v *verifier) jump(sbx int) {
+	to := v.pc + 1 + sbx
+	v.check(to >= 0 && to < len(v.fn.Instrs), "jump to %d out of range", to+1)
+	inst := v.fn.Instrs[to]
+	v.check(inst.Code() != EXTRAARG && !v.uses(inst), "jump to %d into an instruction's operands", to+1)
+}
+
+// check raises an error with the given message if cond is false.
+func (v *verifier) check(cond bool, format string, args ...interface{}) {
+	if cond {
+		return
+	}
+	where := fmt.Sprintf("%s:%d", chunkID(v.fn.Source), v.fn.SrcPos)
+	if v.pc >= 0 {
+		where += fmt.Sprintf(": pc %d", v.pc+1)
+		if op := v.fn.Instrs[v.pc].Code(); op <= EXTRAARG {
+			where += fmt.Sprintf(" (%v)", op)
+		}
+	}
+	panic(Error(fmt.Sprintf("%s: %s", where, fmt.Sprintf(format, args...))))
+}
@@ -0,0 +1,158 @@
+package code_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+)
+
+func TestVerifyCorpus(t *testing.T) {
+	for name, chunk := range corpus(t) {
+		if err := code.Verify(chunk.Main); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(p *code.Proto)
+		error string // "" if valid
+	}{
+		{"Valid", func(p *code.Proto) {}, ""},
+		{"Register", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeABx(code.LOADK, 2, 0)
+		}, "pc 1 (LOADK): register 2 out of range (stack size 2)"},
+		{"Constant", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeABx(code.LOADK, 0, 1)
+		}, "constant 1 out of range"},
+		{"RKConstant", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeABC(code.ADD, 0, code.RK(0), code.RK(3))
+		}, "constant 3 out of range"},
+		{"Upvalue", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeABC(code.GETTABUP, 0, 1, code.RK(0))
+		}, "upvalue 1 out of range"},
+		{"Jump", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeAsBx(code.JMP, 0, 5)
+		}, "jump to 7 out of range"},
+		{"BackJump", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeAsBx(code.JMP, 0, -2)
+		}, "jump to 0 out of range"},
+		{"Closure", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeABx(code.CLOSURE, 0, 0)
+		}, "prototype index 0 out of range"},
+		{"Opcode", func(p *code.Proto) {
+			p.Instrs[0] = code.Instr(code.EXTRAARG + 1)
+		}, "invalid opcode"},
+		{"Return", func(p *code.Proto) {
+			p.Instrs = p.Instrs[:1]
+		}, "missing final RETURN"},
+		{"Test", func(p *code.Proto) {
+			p.Instrs[0] = code.MakeABC(code.TEST, 0, 0, 0)
+		}, "missing jump after test"},
+		{"Vararg", func(p *code.Proto) {
+			p.Vararg = false
+			p.Instrs[0] = code.MakeABC(code.VARARG, 0, 2, 0)
+		}, "VARARG in a function which is not vararg"},
+		{"StackSize", func(p *code.Proto) {
+			p.StackN = 255
+		}, "stack size 255 too large"},
+		{"Nested", func(p *code.Proto) {
+			p.Protos = []*code.Proto{{
+				StackN: 2,
+				UpVars: []*code.UpVar{{Stack: true, Index: 2}},
+				Instrs: []code.Instr{code.MakeABC(code.RETURN, 0, 1, 0)},
+			}}
+		}, "upvalue 0 refers to register 2 out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &code.Proto{
+				Source: "=test",
+				Vararg: true,
+				StackN: 2,
+				Consts: []code.Const{int64(1)},
+				UpVars: []*code.UpVar{{Name: "_ENV", Stack: true}},
+				Instrs: []code.Instr{
+					code.MakeABx(code.LOADK, 0, 0),
+					code.MakeABC(code.RETURN, 0, 2, 0),
+					code.MakeABC(code.RETURN, 0, 1, 0),
+				},
+			}
+			tt.edit(p)
+			err := code.Verify(p)
+			switch {
+			case tt.error == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.error != "" && (err == nil || !strings.Contains(err.Error(), tt.error)):
+				t.Errorf("got %v, want error %q", err, tt.error)
+			}
+		})
+	}
+}
+
+// TestVerifyStackLimit checks that functions using nearly all the
+// registers the compiler allows survive a dump and undump.
+func TestVerifyStackLimit(t *testing.T) {
+	tests := []struct {
+		call  string
+		stack int
+	}{
+		{"print(%s)", 251},            // 190 locals, the function and 60 arguments
+		{"local t = {} t:m(%s)", 253}, // 191 locals, the method, self and 60 arguments
+	}
+	for _, tt := range tests {
+		var src strings.Builder
+		for i := 0; i < 190; i++ {
+			fmt.Fprintf(&src, "local v%d = %d\n", i, i)
+		}
+		args := make([]string, 60)
+		for i := range args {
+			args[i] = fmt.Sprint("v", i)
+		}
+		fmt.Fprintf(&src, tt.call+"\n", strings.Join(args, ", "))
+		chunk, err := luac.Compile(luac.Defaults, "=limit", src.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := chunk.Main.StackN; n != tt.stack {
+			t.Fatalf("%s: stack size %d, want %d", tt.call, n, tt.stack)
+		}
+		var bin bytes.Buffer
+		if _, err := chunk.Dump(&bin, false); err != nil {
+			t.Fatal(err)
+		}
+		back, err := code.Undump("=limit", bin.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.call, err)
+		}
+		if n := back.Main.StackN; n != tt.stack {
+			t.Errorf("%s: undumped stack size %d, want %d", tt.call, n, tt.stack)
+		}
+	}
+}
+
+func TestUndumpVerify(t *testing.T) {
+	chunk := &code.Chunk{Main: &code.Proto{
+		Vararg: true,
+		StackN: 2,
+		UpVars: []*code.UpVar{{Name: "_ENV", Stack: true}},
+		Instrs: []code.Instr{
+			code.MakeABC(code.MOVE, 0, 200, 0),
+			code.MakeABC(code.RETURN, 0, 1, 0),
+		},
+	}}
+	var bin bytes.Buffer
+	if _, err := chunk.Dump(&bin, true); err != nil {
+		t.Fatal(err)
+	}
+	_, err := code.Undump("=bad", bin.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "bad: bad code in precompiled chunk") {
+		t.Errorf("got %v, want a verification error", err)
+	}
+}
@@ -44,6 +44,10 @@ const (
 	// Value must be < 255.
 	maxCalls = 255
 
+	// Maximum depth of nested calls; every Lua call also nests Go calls
+	// in the interpreter, so this bounds the goroutine's stack.
+	maxDepth = 200000
+
 	// Maximum valid index and maximum size of stack.
 	stackMax = 1000000
 
@@ -66,7 +70,23 @@ type Config struct {
 	GoPath Path
 	Path   Path
 	Trace  bool
-	NoEnv  bool
+
+	// NoEnv ignores the environment variables (e.g. GLUA_PATH) which
+	// override the default configuration.
+	NoEnv bool
+
+	// Budget is the number of VM instructions each call from Go into
+	// the main thread may execute (see Thread.SetBudget); 0 means no
+	// limit.
+	Budget int64
+
+	// MemoryLimit is the approximate number of bytes the runtime may
+	// use before raising "not enough memory" errors; 0 means no limit.
+	MemoryLimit int64
+
+	// NoRecover lets panics in Go functions crash the host instead of
+	// raising a *PanicError, e.g. to debug them during development.
+	NoRecover bool
 }
 
 func (config *Config) init(rt *runtime) {
@@ -83,6 +103,9 @@ func (config *Config) init(rt *runtime) {
 }
 
 func envvar(config *Config, envVar, defVal string) (path string) {
+	if config.NoEnv {
+		return defVal
+	}
 	versioned := fmt.Sprintf("%s%s", envVar, "_5_3")
 	if path = os.Getenv(versioned); path == "" {
 		path = os.Getenv(envVar)
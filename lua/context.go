@@ -0,0 +1,72 @@
+package lua
+
+import (
+	"context"
+
+	"github.com/Azure/golua/lua/code"
+)
+
+// Number of instructions executed between polls of the call's context.
+const ctxPoll = 1024
+
+// CallContext calls the function value fv with args like Call; if ctx
+// is done before the call completes, the interpreter stops running
+// Lua code and the call fails with ctx.Err().
+//
+// The context is available to Go functions through Thread.Ctx.
+func (t *Thread) CallContext(ctx context.Context, fv Value, args ...Value) ([]Value, error) {
+	return t.ls.callctx(ctx, fv, args, -1)
+}
+
+// CallNContext is like CallN but honours the cancellation of ctx
+// (see CallContext).
+func (t *Thread) CallNContext(ctx context.Context, fv Value, args []Value, want int) ([]Value, error) {
+	return t.ls.callctx(ctx, fv, args, want)
+}
+
+// ExecContext is like Exec but honours the cancellation of ctx
+// (see CallContext).
+func (t *Thread) ExecContext(ctx context.Context, chunk *code.Chunk, args ...Value) ([]Value, error) {
+	return t.ls.callctx(ctx, t.Load(chunk), args, -1)
+}
+
+// Ctx returns the context of the running call (see CallContext);
+// context.Background() if the call was not made with a context.
+//
+// Go functions performing blocking operations should honour its
+// cancellation.
+func (t *Thread) Ctx() context.Context {
+	if t.ls.ctx != nil {
+		return t.ls.ctx
+	}
+	return context.Background()
+}
+
+func (ls *thread) callctx(ctx context.Context, fn Value, args []Value, want int) ([]Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	prev := ls.ctx
+	ls.ctx, ls.done = ctx, ctx.Done()
+	defer func() {
+		if ls.ctx = prev; prev != nil {
+			ls.done = prev.Done()
+		} else {
+			ls.done = nil
+		}
+	}()
+	return ls.call(fn, args, want)
+}
+
+// cancelled reports the error of the call's context if it is done;
+// the context is polled every ctxPoll instructions.
+func (ls *thread) cancelled() error {
+	if ls.ticks++; ls.ticks%ctxPoll == 0 {
+		select {
+		case <-ls.done:
+			return ls.ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package lua_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// load compiles src and loads it as a function on thread ls.
+func load(t *testing.T, ls *lua.Thread, src string) *lua.Func {
+	t.Helper()
+	chunk, err := luac.Compile(luac.Defaults, "=test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls.Load(chunk)
+}
+
+func TestCallContext(t *testing.T) {
+	const loop = "pcall(function() while true do end end)"
+	calls := []struct {
+		name string
+		call func(context.Context, *lua.Thread, string) ([]lua.Value, error)
+	}{
+		{"CallContext", func(ctx context.Context, ls *lua.Thread, src string) ([]lua.Value, error) {
+			return ls.CallContext(ctx, load(t, ls, src))
+		}},
+		{"CallNContext", func(ctx context.Context, ls *lua.Thread, src string) ([]lua.Value, error) {
+			return ls.CallNContext(ctx, load(t, ls, src), nil, 1)
+		}},
+		{"ExecContext", func(ctx context.Context, ls *lua.Thread, src string) ([]lua.Value, error) {
+			chunk, err := luac.Compile(luac.Defaults, "=test", src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ls.ExecContext(ctx, chunk)
+		}},
+	}
+	for _, c := range calls {
+		t.Run(c.name, func(t *testing.T) {
+			ls := newThread(t, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := c.call(ctx, ls, "return 1"); !errors.Is(err, context.Canceled) {
+				t.Errorf("canceled before the call: got error %v, want context.Canceled", err)
+			}
+
+			ctx, cancel = context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			if _, err := c.call(ctx, ls, loop); !errors.Is(err, context.Canceled) {
+				t.Errorf("canceled during the call: got error %v, want context.Canceled", err)
+			}
+
+			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if _, err := c.call(ctx, ls, loop); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("deadline: got error %v, want context.DeadlineExceeded", err)
+			}
+
+			rets, err := c.call(context.Background(), ls, "return 1")
+			if err != nil || len(rets) != 1 || rets[0] != lua.Int(1) {
+				t.Errorf("call after a canceled one: got %v, %v; want [1]", rets, err)
+			}
+		})
+	}
+}
+
+func TestCtx(t *testing.T) {
+	type key struct{}
+	ls := newThread(t, nil)
+	var got []context.Context
+	ls.SetGlobal("ctx", lua.NewGoFunc("ctx", func(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+		got = append(got, ls.Ctx())
+		return nil, nil
+	}))
+	fn := load(t, ls, "ctx()")
+
+	ctx := context.WithValue(context.Background(), key{}, "outer")
+	if _, err := ls.CallContext(ctx, fn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.Call(fn); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value(key{}) != "outer" || got[1] != context.Background() {
+		t.Errorf("got contexts %v, want the call's context then context.Background()", got)
+	}
+}
@@ -1,14 +1,22 @@
 package lua
 
 import (
-	// "strings"
 	"fmt"
-	// "os"
-	// "github.com/Azure/golua/lua/code"
+	"sort"
+	"strings"
+
+	"github.com/Azure/golua/lua/code"
 )
 
-// var _ = fmt.Println
-// var _ = os.Exit
+// maxID gives the maximum size for the description of the source
+// of a function in debug information.
+const maxID = 60
+
+// Number of levels shown at the top and bottom of a traceback.
+const (
+	levels1 = 10
+	levels2 = 11
+)
 
 type Debug interface {
 	// Options(what string) Debug
@@ -18,15 +26,19 @@ type Debug interface {
 	ParamN() int
 	UpVarN() int
 	Name() string
+	NameWhat() string
 	Kind() string
 	Span() (int, int)
 	Vararg() bool
 	Tailcall() bool
 	Where() string
+	Func() Value
+	ActiveLines() []int
 }
 
 type debug struct {
 	ci *call
+	fn Value
 
 	source   string // (S)
 	short    string // (S)
@@ -36,22 +48,26 @@ type debug struct {
 	what     string // (n) 'global', 'local', 'field', 'method' (namewhat)
 	kind     string // (S) 'Lua', 'Go', 'main', 'tail' (what)
 	span     [2]int // (S) (linedefined/lastlinedefined)
+	lines    []int  // (L) lines with code (activelines)
 	upvarN   int    // (u) number of upvalues
 	paramN   int    // (u) number of parameters
 	vararg   bool   // (u) (isvararg)
 	tailcall bool   // (t) (istailcall)
 }
 
-func (dbg *debug) CurrentLine() int { return dbg.line }
-func (dbg *debug) SourceID() string { return dbg.source }
-func (dbg *debug) ShortSrc() string { return dbg.short }
-func (dbg *debug) ParamN() int      { return dbg.paramN }
-func (dbg *debug) UpVarN() int      { return dbg.upvarN }
-func (dbg *debug) Name() string     { return dbg.name }
-func (dbg *debug) Kind() string     { return dbg.kind }
-func (dbg *debug) Span() (int, int) { return dbg.span[0], dbg.span[1] }
-func (dbg *debug) Vararg() bool     { return dbg.vararg }
-func (dbg *debug) Tailcall() bool   { return dbg.tailcall }
+func (dbg *debug) CurrentLine() int   { return dbg.line }
+func (dbg *debug) SourceID() string   { return dbg.source }
+func (dbg *debug) ShortSrc() string   { return dbg.short }
+func (dbg *debug) ParamN() int        { return dbg.paramN }
+func (dbg *debug) UpVarN() int        { return dbg.upvarN }
+func (dbg *debug) Name() string       { return dbg.name }
+func (dbg *debug) NameWhat() string   { return dbg.what }
+func (dbg *debug) Kind() string       { return dbg.kind }
+func (dbg *debug) Span() (int, int)   { return dbg.span[0], dbg.span[1] }
+func (dbg *debug) Vararg() bool       { return dbg.vararg }
+func (dbg *debug) Tailcall() bool     { return dbg.tailcall }
+func (dbg *debug) Func() Value        { return dbg.fn }
+func (dbg *debug) ActiveLines() []int { return dbg.lines }
 
 func (dbg *debug) Where() string {
 	if dbg != nil {
@@ -64,281 +80,503 @@ func (dbg *debug) Where() string {
 	return ""
 }
 
-// func funcNameFromGlobals(fr *frame, dbg *debug) (name string) {
-// 	var (
-// 		ld = fr.ls.global.loaded
-// 		found bool
-// 	)
-// 	if fs := fr.call.fs; fs != nil && fs.closure != nil {
-// 		name, found = searchField(ld, fs.closure, 2)
-// 	} else {
-// 		if fn, ok := fr.call.fn.(Value); ok {
-// 			name, found = searchField(ld, fn, 2)
-// 		}
-// 	}
-// 	if found && strings.HasPrefix(name, "_G.") {
-// 		name = name[3:]
-// 	}
-// 	return name
-// }
-
-// func searchField(env, fn Value, level int) (name string, found bool) {
-// 	if tbl, ok := env.(*Table); ok && level > 0 {
-// 		tbl.foreach(func(k, v Value) bool {
-// 			if k, isstr := k.(String); isstr {
-// 				if found = equals(fn, v); found {
-// 					name = string(k)
-// 					return false
-// 				}
-// 			}
-// 			var s string
-// 			s, found = searchField(v, fn, level-1)
-// 			if found {
-// 				name = fmt.Sprintf("%s.%s", k, s)
-// 				return false
-// 			}
-// 			return true
-// 		})
-// 	}
-// 	return name, found
-// }
-
-// func funcNameFromCode(ci *call) (name, what string) {
-// 	if fn, ok := ci.fn.(*Proto); ok {
-// 		if ci.flag & hooked != 0 {
-// 			return "?", "hook"
-// 		}
-// 		var (
-// 			inst = fn.Instrs[ci.pc]
-// 			meta event
-// 		)
-// 		switch inst.Code() {
-// 			case code.SELF, code.GETTABUP, code.GETTABLE:
-// 				meta = _index
-// 			case code.SETTABUP, code.SETTABLE:
-// 				meta = _newindex
-// 			case code.CALL, code.TAILCALL:
-// 				return objectName(ci, fn, ci.pc, inst.A())
-// 			case code.TFORCALL:
-// 				return "for iterator", "for iterator"
-// 			case code.IDIV,
-// 				code.DIV,
-// 				code.ADD,
-// 				code.SUB,
-// 				code.MUL,
-// 				code.MOD,
-// 				code.POW,
-// 				code.SHL,
-// 				code.SHR,
-// 				code.BOR,
-// 				code.BXOR,
-// 				code.BAND:
-// 				meta = event(inst.Code()-code.ADD) + _add
-// 			case code.CONCAT:
-// 				meta = _concat
-// 			case code.BNOT:
-// 				meta = _bnot
-// 			case code.UNM:
-// 				meta = _unm
-// 			case code.LEN:
-// 				meta = _len
-// 			case code.EQ:
-// 				meta = _eq
-// 			case code.LT:
-// 				meta = _lt
-// 			case code.LE:
-// 				meta = _le
-// 			default:
-// 				return
-// 		}
-// 		return events[meta], "metamethod"
-// 	}
-// 	return "", ""
-// }
-
-// func objectName(ci *call, fn *Proto, lastpc, reg int) (name, what string) {
-// 	if name = localName(fn, lastpc, reg+1); name != "" {
-// 		return name, "local"
-// 	}
-// 	// try symbolic execution
-// 	if pc := findSetRegister(ci, fn, lastpc, reg); pc != -1 { // instruction found?
-// 		switch inst := fn.Instrs[pc]; inst.Code() {
-// 			case code.GETTABUP:
-// 				var (
-// 					t = inst.B() // table index
-// 					k = inst.C() // key index
-// 				)
-// 				if what = "?"; t < len(fn.UpVars) {
-// 					if up := fn.UpVars[t]; up.Name != "" {
-// 						if up.Name == EnvID {
-// 							what = "global"
-// 						} else {
-// 							what = "field"
-// 						}
-// 					}
-// 				}
-// 				return findNameRK(ci, fn, pc, k), what
-// 			case code.GETTABLE:
-// 				var (
-// 					t = inst.B() // table index
-// 					k = inst.C() // key index
-// 				)
-// 				switch localName(fn, pc, t+1) {
-// 					case EnvID:
-// 						what = "global"
-// 					default:
-// 						what = "field"
-// 				}
-// 				return findNameRK(ci, fn, pc, k), what
-// 			case code.GETUPVAL:
-// 				if name = "?"; inst.B() < len(fn.UpVars) {
-// 					if up := fn.UpVars[inst.B()]; up.Name != "" {
-// 						name = up.Name
-// 					}
-// 				}
-// 				return name, "upvalue"
-// 			case code.LOADKX:
-// 				kst := fn.kst(fn.Instrs[pc+1].AX())
-// 				if s, ok := kst.(String); ok {
-// 					return string(s), "constant"
-// 				}
-// 			case code.LOADK:
-// 				kst := fn.kst(inst.BX())
-// 				if s, ok := kst.(String); ok {
-// 					return string(s), "constant"
-// 				}
-// 			case code.SELF:
-// 				return findNameRK(ci, fn, pc, inst.C()), "method"
-// 			case code.MOVE:
-// 				if inst.B() < inst.A() { // move from 'b' to 'a'
-// 					return objectName(ci, fn, pc, inst.B()) // get name for 'b'
-// 				}
-// 		}
-// 	}
-// 	return "", ""
-// }
-
-// // localName looks for the n-th local variable at line 'line' in function 'func'.
-// //
-// // Returns the local variable name or "" if not found.
-// func localName(fn *Proto, pc, n int) (name string) {
-// 	for i := 0; i < len(fn.Locals) && fn.Locals[i].Live <= int32(pc); i++ {
-// 		if int32(pc) < fn.Locals[i].Dead { // is variable active?
-// 			if n--; n == 0 {
-// 				return fn.Locals[i].Name
-// 			}
-// 		}
-// 	}
-// 	// not found
-// 	return ""
-// }
-
-// // findNameRK finds a name for the RK value 'rk'.
-// func findNameRK(ci *call, fn *Proto, pc, rk int) (name string) {
-// 	if code.IsKst(rk) { // is 'rk' a constant?
-// 		if s, ok := fn.kst(code.ToKst(rk)).(String); ok { // literal constant?
-// 			return string(s) // it is its own name
-// 		}
-// 		// else no reasonable name found
-// 		return ""
-// 	}
-// 	// else 'rk' is a register
-// 	name, what := objectName(ci, fn, pc, rk)
-// 	if what == "constant" {
-// 		return name
-// 	}
-// 	return "?"
-// }
-
-// // findSetRegister tries to find the last instruction before 'lastpc' that modified register 'reg'.
-// func findSetRegister(ci *call, fn *Proto, lastpc, reg int) (pc int) {
-// 	var (
-// 		set = -1 // keep last instruction that changed 'reg'
-// 		jmp = 0  // any code before this address is conditional
-// 	)
-// 	filterPC := func(pc, jmp int) int {
-// 		if pc < jmp { // is code conditional (inside a jump)?
-// 			return -1 // cannt know who sets that register
-// 		}
-// 		return pc // current position sets that register
-// 	}
-// 	for pc := 0; pc < lastpc; pc++ {
-// 		switch inst := fn.Instrs[pc]; inst.Code() {
-// 			case code.CALL, code.TAILCALL:
-// 				if reg >= inst.A() { // affect all registers above base
-// 					set = filterPC(pc, jmp)
-// 				}
-// 			case code.TFORCALL:
-// 				if reg >= inst.A() + 2 { // affect all registers above its base
-// 					set = filterPC(pc, jmp)
-// 				}
-// 			case code.LOADNIL:
-// 				if a, b := inst.A(), inst.B(); reg >= a && reg <= a + b {
-// 					// set register from 'a' to 'a+b'
-// 					set = filterPC(pc, jmp)
-// 				}
-// 			case code.JMP:
-// 				// jump is forward and do not skip 'lastpc'?
-// 				if dst := pc + 1 + inst.SBX(); dst > pc && dst <= lastpc {
-// 					if dst > jmp {
-// 						jmp = dst // update jump target
-// 					}
-// 				}
-// 			default:
-// 				if inst.Code().Mask().SetA() && (reg == inst.A()) {
-// 					// any instruction that set A
-// 					set = filterPC(pc, jmp)
-// 				}
-// 		}
-// 	}
-// 	return set
-// }
-
-// func sourceInfo(dbg *debug) *debug {
-// 	if fn, ok := dbg.ci.fn.(*Func); ok {
-// 		if dbg.source = fn.Source; fn.Source == "" {
-// 			dbg.source = "=?"
-// 		}
-// 		if dbg.kind = "Lua"; fn.SrcPos == 0 {
-// 			dbg.kind = "main"
-// 		}
-// 		dbg.span[0] = fn.SrcPos
-// 		dbg.span[1] = fn.EndPos
-// 		dbg.line = -1
-// 		if len(fn.PcLine) > 0 {
-// 			dbg.line = int(fn.PcLine[ci.pc-1])
-// 		}
-// 	} else {
-// 		dbg.source  = "=[Go]"
-// 		dbg.kind    = "Go"
-// 		dbg.line    = -1
-// 		dbg.span[0] = -1
-// 		dbg.span[1] = -1
-// 	}
-// 	dbg.short = chunkID(dbg.source)
-// 	return dbg
-// }
-
-// func funcInfo(dbg *debug) *debug {
-// 	if fn, ok := ci.fn.(*Proto); ok {
-// 		dbg.tailcall = ci.flag & tailcall != 0
-// 		dbg.upvarN   = len(fn.UpVars)
-// 		dbg.paramN   = fn.ParamN
-// 		dbg.vararg   = fn.Vararg
-// 	} else {
-// 		dbg.vararg = true
-// 	}
-// 	return dbg
-// }
-
-// func funcName(dbg *debug) *debug {
-// 	if caller := ci.fr.call; isLua(caller) {
-// 		if (ci.flag & tailcall == 0) {
-// 			dbg.name, dbg.what = funcNameFromCode(caller)
-// 		}
-// 	}
-// 	if dbg.what == "" {
-// 		dbg.name = "<unset>"
-// 	}
-// 	return dbg
-// }
+// options fills in the fields of dbg selected by the option letters
+// in want; unknown options are ignored.
+func (dbg *debug) options(want string) *debug {
+	dbg.line = -1
+	for _, opt := range want {
+		switch opt {
+		case 'S':
+			dbg.sourceInfo()
+		case 'l':
+			if dbg.ci != nil {
+				dbg.line = dbg.ci.line()
+			}
+		case 'u':
+			dbg.funcInfo()
+		case 't':
+			dbg.tailcall = dbg.ci != nil && dbg.ci.flag&tailcall != 0
+		case 'n':
+			dbg.funcName()
+		case 'L':
+			dbg.activeLines()
+		}
+	}
+	return dbg
+}
+
+func (dbg *debug) sourceInfo() {
+	if fn, ok := dbg.fn.(*Func); ok {
+		if dbg.source = fn.proto.Source; dbg.source == "" {
+			dbg.source = "=?"
+		}
+		if dbg.kind = "Lua"; fn.proto.SrcPos == 0 {
+			dbg.kind = "main"
+		}
+		dbg.span[0] = fn.proto.SrcPos
+		dbg.span[1] = fn.proto.EndPos
+	} else {
+		dbg.source = "=[Go]"
+		dbg.kind = "Go"
+		dbg.span[0] = -1
+		dbg.span[1] = -1
+	}
+	dbg.short = chunkID(dbg.source)
+}
+
+func (dbg *debug) funcInfo() {
+	switch fn := dbg.fn.(type) {
+	case *Func:
+		dbg.upvarN = len(fn.proto.UpVars)
+		dbg.paramN = fn.proto.ParamN
+		dbg.vararg = fn.proto.Vararg
+	case *GoFunc:
+		dbg.upvarN = len(fn.up)
+		dbg.vararg = true
+	}
+}
+
+func (dbg *debug) funcName() {
+	if ci := dbg.ci; ci != nil && ci.flag&tailcall == 0 && ci.fr != nil {
+		if caller := ci.fr.call; caller != nil {
+			if _, ok := caller.fn.(*Func); ok {
+				dbg.name, dbg.what = funcNameFromCode(caller)
+			}
+		}
+	}
+}
+
+func (dbg *debug) activeLines() {
+	if fn, ok := dbg.fn.(*Func); ok {
+		seen := make(map[int]bool, len(fn.proto.PcLine))
+		for _, line := range fn.proto.PcLine {
+			if !seen[int(line)] {
+				dbg.lines = append(dbg.lines, int(line))
+				seen[int(line)] = true
+			}
+		}
+		sort.Ints(dbg.lines)
+	}
+}
+
+// line returns the line currently executing in the call; -1 if no
+// line information is available (e.g. Go functions).
+func (ci *call) line() int {
+	if fn, ok := ci.fn.(*Func); ok {
+		if ci.pc < len(fn.proto.PcLine) {
+			return int(fn.proto.PcLine[ci.pc])
+		}
+	}
+	return -1
+}
+
+// local returns the name and stack slot of the n'th local variable
+// of the call; negative n refers to the call's vararg values.
+//
+// Returns "" and nil if there is no such variable.
+func (ci *call) local(ls *thread, n int) (string, *reg) {
+	switch fn := ci.fn.(type) {
+	case *Func:
+		if n < 0 { // access to vararg values?
+			if fn.proto.Vararg && -n <= ci.va {
+				return "(*vararg)", &ls.stack[ci.sb-ci.va-n-1]
+			}
+			return "", nil
+		}
+		if n > 0 && ci.sb+n <= ls.limit(ci) {
+			name := localName(fn.proto, ci.pc, n)
+			if name == "" {
+				name = "(*temporary)"
+			}
+			return name, &ls.stack[ci.sb+n-1]
+		}
+	case *GoFunc:
+		if n > 0 && ci.sb+n <= ci.sp {
+			return "(*Go temporary)", &ls.stack[ci.sb+n-1]
+		}
+	}
+	return "", nil
+}
+
+// limit returns the first stack slot above the registers of call ci: the
+// slot of the function it is calling, if any, so that the callee and its
+// arguments are not taken for temporaries of ci.
+func (ls *thread) limit(ci *call) int {
+	for fr := ls.fr; fr.prev != nil; fr = fr.prev {
+		if fr.prev.call == ci {
+			return fr.call.slot()
+		}
+	}
+	return ci.top()
+}
+
+// upvalue returns the name and the n'th upvalue of the function;
+// Go functions have unnamed upvalues.
+//
+// Returns "" and nil if there is no such upvalue.
+func upvalue(fn Value, n int) (string, *upvar) {
+	switch fn := fn.(type) {
+	case *Func:
+		if n > 0 && n <= len(fn.up) {
+			name := fn.proto.UpVars[n-1].Name
+			if name == "" {
+				name = "(*no name)"
+			}
+			return name, fn.up[n-1]
+		}
+	case *GoFunc:
+		if n > 0 && n <= len(fn.up) {
+			return "", fn.up[n-1]
+		}
+	}
+	return "", nil
+}
+
+// UpValue returns the name and value of the n'th upvalue of function fn.
+//
+// Returns false if there is no upvalue with the given index.
+func UpValue(fn Value, n int) (string, Value, bool) {
+	if name, up := upvalue(fn, n); up != nil {
+		return name, up.get(), true
+	}
+	return "", nil, false
+}
+
+// SetUpValue assigns value to the n'th upvalue of function fn and returns
+// its name.
+//
+// Returns false if there is no upvalue with the given index.
+func SetUpValue(fn Value, n int, value Value) (string, bool) {
+	if name, up := upvalue(fn, n); up != nil {
+		up.set(value)
+		return name, true
+	}
+	return "", false
+}
+
+// UpValueID returns a unique identifier (as a light userdata) for the
+// n'th upvalue of function fn, allowing to check whether different
+// closures share upvalues.
+//
+// Returns false if there is no upvalue with the given index.
+func UpValueID(fn Value, n int) (Value, bool) {
+	if _, up := upvalue(fn, n); up != nil {
+		return Pointer{up}, true
+	}
+	return nil, false
+}
+
+// UpValueJoin makes the n1'th upvalue of Lua function f1 refer to the
+// n2'th upvalue of Lua function f2.
+func UpValueJoin(f1 Value, n1 int, f2 Value, n2 int) error {
+	fn1, ok1 := f1.(*Func)
+	fn2, ok2 := f2.(*Func)
+	switch {
+	case !ok1:
+		return ArgErr(0, fmt.Errorf("Lua function expected"))
+	case !ok2:
+		return ArgErr(2, fmt.Errorf("Lua function expected"))
+	case n1 <= 0 || n1 > len(fn1.up):
+		return ArgErr(1, fmt.Errorf("invalid upvalue index"))
+	case n2 <= 0 || n2 > len(fn2.up):
+		return ArgErr(3, fmt.Errorf("invalid upvalue index"))
+	}
+	fn1.up[n1-1] = fn2.up[n2-1]
+	return nil
+}
+
+// traceback returns a traceback of the call stack starting at level,
+// prefixed by msg if not empty.
+func (ls *thread) traceback(msg string, level int) string {
+	var (
+		b    strings.Builder
+		last = level
+	)
+	for ls.caller(last) != nil {
+		last++
+	}
+	if msg != "" {
+		fmt.Fprintf(&b, "%s\n", msg)
+	}
+	b.WriteString("stack traceback:")
+
+	n1 := -1
+	if last-level > levels1+levels2 {
+		n1 = levels1
+	}
+	for ci := ls.caller(level); ci != nil; ci = ls.caller(level) {
+		if level++; n1 == 0 {
+			b.WriteString("\n\t...")
+			level = last - levels2
+			n1--
+			continue
+		}
+		n1--
+		dbg := ci.debug("Slnt")
+		fmt.Fprintf(&b, "\n\t%s:", dbg.short)
+		if dbg.line > 0 {
+			fmt.Fprintf(&b, "%d:", dbg.line)
+		}
+		b.WriteString(" in ")
+		switch name := ls.globalFuncName(dbg.fn); {
+		case name != "":
+			fmt.Fprintf(&b, "function '%s'", name)
+		case dbg.what != "":
+			fmt.Fprintf(&b, "%s '%s'", dbg.what, dbg.name)
+		case dbg.kind == "main":
+			b.WriteString("main chunk")
+		case dbg.kind != "Go":
+			fmt.Fprintf(&b, "function <%s:%d>", dbg.short, dbg.span[0])
+		default:
+			b.WriteString("?")
+		}
+		if dbg.tailcall {
+			b.WriteString("\n\t(...tail calls...)")
+		}
+	}
+	return b.String()
+}
+
+// globalFuncName searches the loaded modules for the function value fn
+// and returns its qualified name (e.g. "string.format"); globals are
+// reported without the "_G." prefix.
+func (ls *thread) globalFuncName(fn Value) string {
+	if name, found := searchField(ls.rt.loaded, fn, 2); found {
+		return strings.TrimPrefix(name, "_G.")
+	}
+	return ""
+}
+
+func searchField(env, fn Value, level int) (name string, found bool) {
+	if tbl, ok := env.(*Table); ok && level > 0 {
+		tbl.foreach(func(k, v Value) bool {
+			if k, isstr := k.(String); isstr {
+				if found = (v == fn); found {
+					name = string(k)
+					return false
+				}
+				var s string
+				if s, found = searchField(v, fn, level-1); found {
+					name = fmt.Sprintf("%s.%s", k, s)
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return name, found
+}
+
+func funcNameFromCode(ci *call) (name, what string) {
+	if ci.flag&hooked != 0 {
+		return "?", "hook"
+	}
+	fn := ci.fn.(*Func).proto
+	if ci.pc >= len(fn.Instrs) {
+		return "", ""
+	}
+	var meta event
+	switch inst := fn.Instrs[ci.pc]; inst.Code() {
+	case code.CALL, code.TAILCALL:
+		return objectName(fn, ci.pc, inst.A())
+	case code.TFORCALL:
+		return "for iterator", "for iterator"
+	case code.SELF, code.GETTABUP, code.GETTABLE:
+		meta = _index
+	case code.SETTABUP, code.SETTABLE:
+		meta = _newindex
+	case code.IDIV,
+		code.DIV,
+		code.ADD,
+		code.SUB,
+		code.MUL,
+		code.MOD,
+		code.POW,
+		code.SHL,
+		code.SHR,
+		code.BOR,
+		code.BXOR,
+		code.BAND:
+		meta = event(inst.Code()-code.ADD) + _add
+	case code.CONCAT:
+		meta = _concat
+	case code.BNOT:
+		meta = _bnot
+	case code.UNM:
+		meta = _unm
+	case code.LEN:
+		meta = _len
+	case code.EQ:
+		meta = _eq
+	case code.LT:
+		meta = _lt
+	case code.LE:
+		meta = _le
+	default:
+		return "", ""
+	}
+	return events[meta], "metamethod"
+}
+
+func objectName(fn *code.Proto, lastpc, reg int) (name, what string) {
+	if name = localName(fn, lastpc, reg+1); name != "" {
+		return name, "local"
+	}
+	// try symbolic execution
+	if pc := findSetRegister(fn, lastpc, reg); pc != -1 { // instruction found?
+		switch inst := fn.Instrs[pc]; inst.Code() {
+		case code.GETTABUP:
+			var (
+				t = inst.B() // table index
+				k = inst.C() // key index
+			)
+			if what = "field"; t < len(fn.UpVars) && fn.UpVars[t].Name == EnvID {
+				what = "global"
+			}
+			return findNameRK(fn, pc, k), what
+		case code.GETTABLE:
+			var (
+				t = inst.B() // table index
+				k = inst.C() // key index
+			)
+			if what = "field"; localName(fn, pc, t+1) == EnvID {
+				what = "global"
+			}
+			return findNameRK(fn, pc, k), what
+		case code.GETUPVAL:
+			if name = "?"; inst.B() < len(fn.UpVars) {
+				if up := fn.UpVars[inst.B()]; up.Name != "" {
+					name = up.Name
+				}
+			}
+			return name, "upvalue"
+		case code.LOADKX:
+			if s, ok := fn.Consts[fn.Instrs[pc+1].AX()].(string); ok {
+				return s, "constant"
+			}
+		case code.LOADK:
+			if s, ok := fn.Consts[inst.BX()].(string); ok {
+				return s, "constant"
+			}
+		case code.SELF:
+			return findNameRK(fn, pc, inst.C()), "method"
+		case code.MOVE:
+			if inst.B() < inst.A() { // move from 'b' to 'a'
+				return objectName(fn, pc, inst.B()) // get name for 'b'
+			}
+		}
+	}
+	return "", ""
+}
+
+// localName looks for the n-th local variable active at instruction 'pc'
+// in function 'fn'.
+//
+// Returns the local variable name or "" if not found.
+func localName(fn *code.Proto, pc, n int) (name string) {
+	for i := 0; i < len(fn.Locals) && fn.Locals[i].Live <= int32(pc); i++ {
+		if int32(pc) < fn.Locals[i].Dead { // is variable active?
+			if n--; n == 0 {
+				return fn.Locals[i].Name
+			}
+		}
+	}
+	// not found
+	return ""
+}
+
+// findNameRK finds a name for the RK value 'rk'.
+func findNameRK(fn *code.Proto, pc, rk int) (name string) {
+	if code.IsKst(rk) { // is 'rk' a constant?
+		if s, ok := fn.Consts[code.ToKst(rk)].(string); ok { // literal constant?
+			return s // it is its own name
+		}
+		// else no reasonable name found
+		return "?"
+	}
+	// else 'rk' is a register
+	name, what := objectName(fn, pc, rk)
+	if what == "constant" {
+		return name
+	}
+	return "?"
+}
+
+// findSetRegister tries to find the last instruction before 'lastpc' that modified register 'reg'.
+func findSetRegister(fn *code.Proto, lastpc, reg int) (pc int) {
+	var (
+		set = -1 // keep last instruction that changed 'reg'
+		jmp = 0  // any code before this address is conditional
+	)
+	filterPC := func(pc, jmp int) int {
+		if pc < jmp { // is code conditional (inside a jump)?
+			return -1 // cannot know who sets that register
+		}
+		return pc // current position sets that register
+	}
+	for pc := 0; pc < lastpc; pc++ {
+		switch inst := fn.Instrs[pc]; inst.Code() {
+		case code.CALL, code.TAILCALL:
+			if reg >= inst.A() { // affect all registers above base
+				set = filterPC(pc, jmp)
+			}
+		case code.TFORCALL:
+			if reg >= inst.A()+2 { // affect all registers above its base
+				set = filterPC(pc, jmp)
+			}
+		case code.LOADNIL:
+			if a, b := inst.A(), inst.B(); reg >= a && reg <= a+b {
+				// set register from 'a' to 'a+b'
+				set = filterPC(pc, jmp)
+			}
+		case code.JMP:
+			// jump is forward and do not skip 'lastpc'?
+			if dst := pc + 1 + inst.SBX(); dst > pc && dst <= lastpc {
+				if dst > jmp {
+					jmp = dst // update jump target
+				}
+			}
+		default:
+			if inst.Code().Mask().SetA() && (reg == inst.A()) {
+				// any instruction that set A
+				set = filterPC(pc, jmp)
+			}
+		}
+	}
+	return set
+}
+
+// chunkID returns a printable version of the chunk's source name
+// suitable for error messages.
+func chunkID(source string) string {
+	switch {
+	case strings.HasPrefix(source, "="): // 'literal' source
+		if len(source) <= maxID {
+			return source[1:]
+		}
+		return source[1:maxID]
+	case strings.HasPrefix(source, "@"): // file name
+		if len(source) <= maxID {
+			return source[1:]
+		}
+		return "..." + source[len(source)-maxID+4:]
+	}
+	// string; format as [string "source"]
+	const (
+		pre = "[string \""
+		ret = "..."
+		pos = "\"]"
+	)
+	n := maxID - len(pre+ret+pos) - 1
+	nl := strings.IndexByte(source, '\n')
+	if len(source) < n && nl < 0 { // small one-line source?
+		return pre + source + pos
+	}
+	if nl >= 0 {
+		source = source[:nl] // stop at first newline
+	}
+	if len(source) > n {
+		source = source[:n]
+	}
+	return pre + source + ret + pos
+}
@@ -0,0 +1,219 @@
+package lua_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+// fields is a Lua function returning the fields of a table (except func
+// and activelines) as a sorted "key=value" list.
+const fields = `
+local function fields(t)
+	local list = {}
+	for k, v in pairs(t) do
+		if k ~= "func" and k ~= "activelines" then
+			local s, i = k .. "=" .. tostring(v), #list
+			while i > 0 and list[i] > s do list[i+1] = list[i]; i = i - 1 end
+			list[i+1] = s
+		end
+	end
+	return table.concat(list, " ")
+end
+`
+
+func TestGetInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Level", `
+			local function f(a, b, ...)
+				return debug.getinfo(1, "Slu")
+			end
+			return fields(f())`,
+			"currentline=15 isvararg=true lastlinedefined=16 linedefined=14 nparams=2 nups=1 short_src=test source==test what=Lua"},
+		{"Main", `return fields(debug.getinfo(1, "S"))`,
+			"lastlinedefined=0 linedefined=0 short_src=test source==test what=main"},
+		{"Go", `return fields(debug.getinfo(print, "Su"))`,
+			"isvararg=true lastlinedefined=-1 linedefined=-1 nparams=0 nups=0 short_src=[Go] source==[Go] what=Go"},
+		{"Names", `
+			local t = {}
+			function t.f() return debug.getinfo(1, "n") end
+			function t:m() return debug.getinfo(1, "n") end
+			function g() return debug.getinfo(1, "n") end
+			local function l() return debug.getinfo(1, "n") end
+			return fields(t.f()) .. "; " .. fields(t:m()) .. "; " .. fields(g()) .. "; " .. fields((l()))`,
+			"name=f namewhat=field; name=m namewhat=method; name=g namewhat=global; name=l namewhat=local"},
+		{"Tailcall", `
+			local function f() return debug.getinfo(1, "nt") end
+			local function g() return f() end
+			local function h() local i = f() return i end
+			return fields(g()) .. "; " .. fields(h())`,
+			"istailcall=true namewhat=; istailcall=false name=f namewhat=upvalue"},
+		{"Func", `
+			local function f() end
+			return tostring(debug.getinfo(f, "f").func == f and debug.getinfo(1, "f").func ~= f)`,
+			"true"},
+		{"ActiveLines", `
+			local function f(x)
+				local y = x
+
+				return y
+			end
+			local active, lines = debug.getinfo(f, "L").activelines, {}
+			for line = 1, 20 do
+				if active[line] then lines[#lines+1] = line end
+			end
+			return table.concat(lines, ",")`,
+			"15,17,18"},
+		{"OutOfRange", `return tostring(debug.getinfo(100))`, "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rets := check(t, fields+tt.src)
+			if got := string(rets[0].(lua.String)); got != tt.want {
+				t.Errorf("got\n\t%s\nwant\n\t%s", got, tt.want)
+			}
+		})
+	}
+	rets := check(t, `return pcall(debug.getinfo, 1, ">")`)
+	if rets[0] != lua.False || !strings.Contains(string(rets[1].(lua.String)), "invalid option") {
+		t.Errorf("getinfo with an invalid option: got %v", rets)
+	}
+}
+
+func TestGetLocal(t *testing.T) {
+	rets := check(t, `
+		local function f(a, b, ...)
+			local x = 3
+			local names = {}
+			for i = 1, 10 do
+				if i == 8 then
+					local n = 1
+					while true do
+						local name, value = debug.getlocal(1, n)
+						if not name then break end
+						names[n] = name .. "=" .. (value == names and "{}" or tostring(value))
+						n = n + 1
+					end
+					break
+				end
+			end
+			for n = 1, 3 do
+				local name, value = debug.getlocal(1, -n)
+				names[#names+1] = tostring(name) .. "=" .. tostring(value)
+			end
+			return table.concat(names, " ")
+		end
+		return f(1, 2, "v1", "v2")`)
+	const want = "a=1 b=2 x=3 names={} (for index)=8 (for limit)=10 (for step)=1 i=8 n=9 " +
+		"(*vararg)=v1 (*vararg)=v2 nil=nil"
+	if got := string(rets[0].(lua.String)); got != want {
+		t.Errorf("got\n\t%s\nwant\n\t%s", got, want)
+	}
+
+	rets = check(t, `
+		local function f(a, b) local c end
+		return debug.getlocal(f, 1), debug.getlocal(f, 2), debug.getlocal(f, 3)`)
+	if len(rets) != 3 || rets[0] != lua.String("a") || rets[1] != lua.String("b") || rets[2] != nil {
+		t.Errorf("parameters of f: got %v, want [a b <nil>]", rets)
+	}
+
+	rets = check(t, `
+		local x = 1
+		local name = debug.setlocal(1, 1, 42)
+		return name, x, debug.setlocal(1, 10, 0)`)
+	if len(rets) != 3 || rets[0] != lua.String("x") || rets[1] != lua.Int(42) || rets[2] != nil {
+		t.Errorf("setlocal: got %v, want [x 42 <nil>]", rets)
+	}
+
+	ls := newThread(t, nil)
+	if _, err := run(t, ls, `debug.getlocal(50, 1)`); err == nil || !strings.Contains(err.Error(), "level out of range") {
+		t.Errorf("getlocal at level 50: got error %v, want level out of range", err)
+	}
+}
+
+func TestUpvalues(t *testing.T) {
+	rets := check(t, `
+		local u1, u2 = 1, 2
+		local function f() return u1 + u2 end
+		local function g() return u1 end
+		local n1, v1 = debug.getupvalue(f, 1)
+		local n2, v2 = debug.getupvalue(f, 2)
+		local set = debug.setupvalue(f, 1, 10)
+		return n1, v1, n2, v2, set, u1, (debug.getupvalue(f, 3)) == nil`)
+	want := []lua.Value{lua.String("u1"), lua.Int(1), lua.String("u2"), lua.Int(2), lua.String("u1"), lua.Int(10), lua.True}
+	if len(rets) != len(want) {
+		t.Fatalf("got %v, want %v", rets, want)
+	}
+	for i := range want {
+		if rets[i] != want[i] {
+			t.Errorf("ret %d: got %v, want %v", i, rets[i], want[i])
+		}
+	}
+
+	rets = check(t, `
+		local a, b = 1, 2
+		local function f() return a end
+		local function g() return a end
+		local function h() return b end
+		local shared = debug.upvalueid(f, 1) == debug.upvalueid(g, 1)
+		local distinct = debug.upvalueid(f, 1) ~= debug.upvalueid(h, 1)
+		debug.upvaluejoin(f, 1, h, 1)
+		local joined = debug.upvalueid(f, 1) == debug.upvalueid(h, 1)
+		b = 3
+		return shared, distinct, joined, f(), g()`)
+	want = []lua.Value{lua.True, lua.True, lua.True, lua.Int(3), lua.Int(1)}
+	if len(rets) != len(want) {
+		t.Fatalf("got %v, want %v", rets, want)
+	}
+	for i := range want {
+		if rets[i] != want[i] {
+			t.Errorf("ret %d: got %v, want %v", i, rets[i], want[i])
+		}
+	}
+
+	ls := newThread(t, nil)
+	for _, tt := range []struct{ src, err string }{
+		{`debug.upvalueid(function() end, 1)`, "invalid upvalue index"},
+		{`local u; debug.upvaluejoin(function() return u end, 1, function() return u end, 2)`, "invalid upvalue index"},
+		{`local u; debug.upvaluejoin(function() return u end, 1, print, 1)`, "Lua function expected"},
+	} {
+		if _, err := run(t, ls, tt.src); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %s", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	rets := check(t, `
+		local t = {}
+		function t.f() return debug.traceback("msg") end
+		local function g() return (t.f()) end
+		local function h() return g() end
+		return (h())`)
+	const want = "msg\nstack traceback:\n" +
+		"\ttest:3: in field 'f'\n" +
+		"\ttest:4: in function <test:4>\n" +
+		"\t(...tail calls...)\n" +
+		"\ttest:6: in main chunk"
+	if got := string(rets[0].(lua.String)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	rets = check(t, `
+		local function f(n) if n == 0 then return debug.traceback(nil, 1) end return (f(n-1)) end
+		return (f(30))`)
+	got := string(rets[0].(lua.String))
+	if lines := strings.Split(got, "\n"); len(lines) != 1+10+1+11 || lines[11] != "\t..." || lines[22] != "\ttest:3: in main chunk" {
+		t.Errorf("traceback of 32 levels not elided to 10+11:\n%s", got)
+	}
+
+	rets = check(t, `return debug.traceback({})`)
+	if _, ok := rets[0].(*lua.Table); !ok {
+		t.Errorf("traceback of a table: got %v, want the table", rets[0])
+	}
+}
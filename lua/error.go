@@ -20,7 +20,45 @@ func ArgErr(arg int, err error) error {
 	return &argErr{arg, err}
 }
 
+// ValueErr returns an error raising the Lua value v (e.g. by the error
+// function); see ErrorValue.
+func ValueErr(v Value) error {
+	return &valueErr{v}
+}
+
+// ErrorValue returns the Lua value of error err: the value raised by
+// ValueErr, or the error message as a String otherwise.
+func ErrorValue(err error) Value {
+	if e, ok := err.(*valueErr); ok {
+		return e.value
+	}
+	return String(err.Error())
+}
+
+// PanicError is the error raised by a Go function that panics (unless
+// Config.NoRecover is set); it records the function's name, the value
+// passed to panic and the Go stack of the goroutine when it panicked.
+type PanicError struct {
+	Func  string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in Go function '%s': %v", e.Func, e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 type (
+	valueErr struct {
+		value Value
+	}
+
 	runtimeErr struct {
 		fr  *frame
 		err error
@@ -42,6 +80,13 @@ type (
 	}
 )
 
+func (e *valueErr) Error() string {
+	if s, ok := ToString(e.value); ok && e.value != nil {
+		return string(s)
+	}
+	return fmt.Sprintf("(error object is a %s value)", TypeName(e.value))
+}
+
 func (*runtimeErr) Error() string { return "runtime error!" }
 
 func (e *evalErr) Error() string {
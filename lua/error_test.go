@@ -0,0 +1,83 @@
+package lua_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+var errBoom = errors.New("boom")
+
+// setPanics registers the Go functions boom, which panics with errBoom,
+// and callback, which calls its argument back from Go.
+func setPanics(ls *lua.Thread) {
+	ls.SetGlobal("boom", lua.NewGoFunc("boom", func(*lua.Thread, lua.Tuple) ([]lua.Value, error) {
+		panic(errBoom)
+	}))
+	ls.SetGlobal("callback", lua.NewGoFunc("callback", func(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+		return ls.Call(args.Arg(0))
+	}))
+}
+
+func TestPanicError(t *testing.T) {
+	ls := newThread(t, nil)
+	setPanics(ls)
+
+	rets, err := run(t, ls, `return pcall(boom)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rets[0] != lua.False || rets[1] != lua.String("panic in Go function 'boom': boom") {
+		t.Errorf("pcall(boom): got %v, want [false panic in Go function 'boom': boom]", rets)
+	}
+
+	_, err = run(t, ls, `local function f() boom() end f()`)
+	var perr *lua.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got error %v, want a *PanicError", err)
+	}
+	if perr.Func != "boom" || perr.Value != errBoom || !errors.Is(err, errBoom) || len(perr.Stack) == 0 {
+		t.Errorf("got %+v, want the panic of boom with its stack", perr)
+	}
+}
+
+func TestPanicUnwind(t *testing.T) {
+	ls := newThread(t, nil)
+	setPanics(ls)
+
+	rets, err := run(t, ls, `
+		local function f(n)
+			if n == 0 then return callback(boom) end
+			local x = f(n - 1)
+			return x
+		end
+		local ok = pcall(f, 50)
+		return ok, debug.traceback()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rets[0] != lua.False || rets[1] != lua.String("stack traceback:\n\ttest:8: in main chunk") {
+		t.Errorf("got %v, want false and a traceback of the main chunk", rets)
+	}
+
+	// the thread is usable afterwards, with all its stack
+	rets, err = run(t, ls, `
+		local function f(n) if n == 0 then return 0 end return 1 + f(n - 1) end
+		return f(150), callback(function() return 1 end)`)
+	if err != nil || len(rets) != 2 || rets[0] != lua.Int(150) || rets[1] != lua.Int(1) {
+		t.Errorf("call after a panic: got %v, %v; want [150 1]", rets, err)
+	}
+}
+
+func TestNoRecover(t *testing.T) {
+	ls := newThread(t, &lua.Config{NoRecover: true})
+	setPanics(ls)
+	defer func() {
+		if r := recover(); r != errBoom {
+			t.Errorf("recovered %v, want the panic of boom", r)
+		}
+	}()
+	run(t, ls, `pcall(boom)`)
+	t.Error("pcall(boom) did not panic")
+}
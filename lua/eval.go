@@ -180,6 +180,12 @@ func binary(ls *thread, op Op, x, y Value) (Value, error) {
 	default:
 		if x, ok := x.(Int); ok {
 			if y, ok := y.(Int); ok {
+				switch {
+				case y == 0 && op == OpDivI:
+					return nil, fmt.Errorf("attempt to perform 'n//0'")
+				case y == 0 && op == OpMod:
+					return nil, fmt.Errorf("attempt to perform 'n%%0'")
+				}
 				return intop(op, x, y), nil
 			}
 		}
@@ -189,7 +195,15 @@ func binary(ls *thread, op Op, x, y Value) (Value, error) {
 			}
 		}
 	}
-	e := event(op-OpEq) + _add
+	var e event
+	switch op {
+	case OpMinus:
+		e = _unm
+	case OpBnot:
+		e = _bnot
+	default:
+		e = event(op-OpAdd) + _add
+	}
 	return e.binary(ls, x, y)
 }
 
@@ -225,7 +239,11 @@ func intop(op Op, x, y Int) Int {
 	case OpMinus:
 		return -x
 	case OpDivI:
-		return x / y
+		if q := x / y; (x%y != 0) && (x^y) < 0 { // negative non-integer quotient?
+			return q - 1 // round towards minus infinity
+		} else {
+			return q
+		}
 	case OpBand:
 		return x & y
 	case OpBnot:
@@ -106,5 +106,40 @@ func (evt event) binary(ls *thread, x, y Value) (v Value, err error) {
 	// func (evt event) call(ls *State, fn, arg1, arg2 lua.Value, hasResult bool) (lua.Value, error) {
 	// 	return nil, fmt.Errorf("%s.call(%v, %v, %v) (result = %t)\n", fn, arg1, arg2, hasResult)
 	// }
-	return nil, fmt.Errorf("binary meta-event: todo!")
+	method := ls.meta(x, evt.String()) // try 1st operand
+	if method == nil {
+		method = ls.meta(y, evt.String()) // try 2nd operand
+	}
+	if method == nil {
+		return nil, evt.operandErr(x, y)
+	}
+	rets, err := ls.call(method, []Value{x, y}, 1)
+	if err != nil {
+		return nil, err
+	}
+	return rets[0], nil
+}
+
+// operandErr returns the error for operands x and y of a binary event
+// without a metamethod.
+func (evt event) operandErr(x, y Value) error {
+	switch evt {
+	case _concat:
+		if IsString(x) {
+			x = y
+		}
+		return fmt.Errorf("attempt to concatenate a %s value", TypeName(x))
+	case _band, _bor, _bxor, _shl, _shr, _bnot:
+		if IsNumber(x) && IsNumber(y) {
+			return fmt.Errorf("number has no integer representation")
+		}
+		if IsNumber(x) {
+			x = y
+		}
+		return fmt.Errorf("attempt to perform bitwise operation on a %s value", TypeName(x))
+	}
+	if IsNumber(x) {
+		x = y
+	}
+	return fmt.Errorf("attempt to perform arithmetic on a %s value", TypeName(x))
 }
@@ -26,80 +26,189 @@ type (
 		call *call
 	}
 
+	// call holds the state of a function call. The call's function and
+	// arguments live on the thread's stack: registers (or arguments of
+	// a Go function) start at the base (sb); vararg values are stored
+	// just below the base.
 	call struct {
 		flag callstatus
 		fn   callable
-		va   []Value
+		va   int // number of vararg values
 		fr   *frame
-		sp   int
-		sb   int
+		sp   int // top of the call's stack
+		sb   int // base of the call's stack
 		pc   int
 		want int
 	}
 )
 
-func (ci *call) prepare(ls *thread, fn callable, args *[]Value, want int) *call {
-	if fn, ok := fn.(*Func); ok {
-		if ci.flag = luacall; fn.proto.Vararg {
-			ci.varargs(args, fn.proto.ParamN)
-		} else {
-			extra := fn.proto.ParamN - len(*args)
-			if extra < 0 {
-				*args = (*args)[:len(*args)+extra]
+// push pushes a new frame onto the thread's call stack and returns its
+// call; frames are recycled to make calls allocation-free.
+func (ls *thread) push() *call {
+	fr := ls.free
+	if fr != nil {
+		ls.free = fr.prev
+		*fr.call = call{}
+	} else {
+		fr = &frame{call: new(call)}
+	}
+	fr.prev, fr.open = ls.fr, nil
+	fr.call.fr, ls.fr = ls.fr, fr
+	ls.depth++
+	return fr.call
+}
+
+// pop closes the open upvalues of the current frame and pops it off the
+// thread's call stack.
+func (ls *thread) pop() {
+	fr := ls.fr
+	fr.close(0)
+	ls.fr = fr.prev
+	fr.prev, ls.free = ls.free, fr
+	ls.depth--
+}
+
+// unwind pops the frames above call ci, e.g. left behind by a panic.
+func (ls *thread) unwind(ci *call) {
+	for ls.fr.call != ci {
+		ls.pop()
+	}
+}
+
+// prepare pushes the call of function fn, stored at stack slot 'slot'
+// with the nargs arguments above it.
+func (ls *thread) prepare(fn callable, slot, nargs, want int) (*call, error) {
+	if ls.depth >= maxDepth {
+		return nil, ls.runtimeError("stack overflow")
+	}
+	ci := ls.push()
+	if err := ls.enter(ci, fn, slot, nargs); err != nil {
+		ls.pop()
+		return nil, err
+	}
+	ci.want = want
+	return ci, nil
+}
+
+// enter sets up call ci to run function fn, stored at stack slot 'slot'
+// with the nargs arguments above it. Tail calls reuse the caller's call.
+func (ls *thread) enter(ci *call, fn callable, slot, nargs int) error {
+	var (
+		base = slot + 1
+		top  = base + nargs
+		va   int
+	)
+	if cl, ok := fn.(*Func); ok {
+		fp := cl.proto
+		if nargs < fp.ParamN { // complete missing arguments
+			if err := ls.checkstack(base + fp.ParamN); err != nil {
+				return err
+			}
+			for ; nargs < fp.ParamN; nargs++ {
+				ls.stack[base+nargs] = reg{}
 			}
-			if extra > 0 {
-				*args = append(*args, make([]Value, extra)...)
+		}
+		if fp.Vararg { // move fixed parameters above the vararg values
+			va, base = nargs-fp.ParamN, base+nargs
+		}
+		if top = base + fp.StackN; top > len(ls.stack) {
+			if err := ls.checkstack(top); err != nil {
+				return err
 			}
 		}
-		fn.stack = make([]Value, fn.proto.StackN)
+		if fp.Vararg {
+			copy(ls.stack[base:base+fp.ParamN], ls.stack[slot+1:])
+		}
+		clear(ls.stack[base+fp.ParamN : top])
+		ci.flag |= luacall
 	}
-	ci.fr, ls.fr = ls.fr, &frame{prev: ls.fr, call: ci}
-	ci.want = want
-	ci.fn = fn
-	return ci
+	ci.fn, ci.pc = fn, 0
+	ci.sb, ci.sp, ci.va = base, top, va
+	return nil
 }
 
-func (ci *call) varargs(args *[]Value, fixed int) {
-	var param int
-	for param < fixed && param < len(*args) {
-		param++
-	}
-	for param < fixed {
-		param++
+// slot returns the stack index of the call's function, where its results
+// are moved on return.
+func (ci *call) slot() int {
+	if fn, ok := ci.fn.(*Func); ok && fn.proto.Vararg {
+		return ci.sb - ci.va - fn.proto.ParamN - 1
 	}
-	extra := (*args)[param:]
-	*args = (*args)[:param]
+	return ci.sb - 1
+}
 
-	ci.va = make([]Value, len(extra))
-	copy(ci.va, extra)
+// top returns the first free slot above the call's stack.
+func (ci *call) top() int {
+	if fn, ok := ci.fn.(*Func); ok {
+		return max(ci.sp, ci.sb+fn.proto.StackN)
+	}
+	return ci.sp
 }
 
 func (ci *call) debug(want string) *debug {
-	dbg := &debug{ci: ci}
-	//ci.sourceInfo(dbg)
-	// ci.funcInfo(dbg)
-	// ci.funcName(dbg)
-	return dbg
+	dbg := &debug{ci: ci, fn: ci.fn.(Value)}
+	return dbg.options(want)
 }
 
-func (ci *call) call(ls *thread, args []Value) ([]Value, error) {
-	rets, err := ci.fn.call(ls, args)
+// call runs the call and moves its results to the function's slot, adjusted
+// to the number of results wanted; it returns the number of results.
+func (ci *call) call(ls *thread) (int, error) {
+	if err := ls.callhook(ci); err != nil {
+		ls.pop() // unwind
+		return 0, err
+	}
+	first, m, err := ci.fn.call(ls, ci)
+	if err == nil {
+		err = ls.rethook(ci)
+	}
 	if err != nil {
-		return nil, err
+		ls.pop() // unwind
+		return 0, err
 	}
+	var (
+		slot = ci.slot()
+		top  = max(ci.top(), first+m)
+		n    = m
+	)
+	ls.pop()
 	if ci.want >= 0 {
-		switch extra := len(rets) - ci.want; {
-		case ci.want == 0:
-			rets = rets[:0]
-		case extra < 0:
-			nils := make([]Value, -extra)
-			rets = append(rets, nils...)
-		case extra > 0:
-			rets = rets[:len(rets)-extra]
+		n = ci.want
+	}
+	if slot+n > len(ls.stack) {
+		if err := ls.checkstack(slot + n); err != nil {
+			return 0, err
+		}
+	}
+	m = copy(ls.stack[slot:slot+n], ls.stack[first:first+m])
+	clear(ls.stack[slot+m : max(top, slot+n)]) // pad with nils
+	return n, nil
+}
+
+// close closes the frame's open upvalues referring to stack slots at or
+// above level.
+func (fr *frame) close(level int) {
+	var open *upvar
+	for up := fr.open; up != nil; {
+		next := up.next
+		if up.index >= level {
+			up.value = (*up.stack)[up.index]
+			up.open, up.stack, up.next = false, nil, nil
+		} else {
+			up.next, open = open, up
+		}
+		up = next
+	}
+	fr.open = open
+}
+
+// upvar returns the frame's open upvalue for stack slot index, creating
+// it if needed.
+func (fr *frame) upvar(ls *thread, index int) *upvar {
+	for up := fr.open; up != nil; up = up.next {
+		if up.index == index {
+			return up
 		}
 	}
-	ls.fr = ci.fr
-	// ci.fr.leave(ls)
-	// fmt.Printf("call: rets = %v (want = %d)\n", rets, ci.want)
-	return rets, nil
+	up := &upvar{index: index, open: true, stack: &ls.stack, next: fr.open}
+	fr.open = up
+	return up
 }
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"fmt"
+	godebug "runtime/debug"
 
 	"github.com/Azure/golua/lua/code"
 )
@@ -14,8 +15,11 @@ type (
 	}
 
 	// callable is implemented by all values that are callable: *GoFunc / *Func.
+	//
+	// call runs the function of call ci (see thread.prepare) and returns
+	// the stack index and the number of its results.
 	callable interface {
-		call(*thread, []Value) ([]Value, error)
+		call(ls *thread, ci *call) (int, int, error)
 	}
 
 	// closure represents a Lua/Go closure.
@@ -26,28 +30,33 @@ type (
 
 	// upvar represents a Lua upvalue.
 	upvar struct {
-		value Value
-		index int
+		value reg
+		stack *[]reg // stack of an open upvalue
+		index int    // stack slot of an open upvalue
 		open  bool
 		next  *upvar
 	}
 )
 
 // set the upvalue's inner value.
-func (up *upvar) set(v Value) {
+func (up *upvar) set(v Value) { up.setreg(toreg(v)) }
+
+// get the upvalue's inner value.
+func (up *upvar) get() Value { return up.reg().value() }
+
+// setreg sets the upvalue's inner register.
+func (up *upvar) setreg(r reg) {
 	if up.open {
-		// (*up.stack)[up.level] = v
-		// return
-		panic("upvar.set: open!")
+		(*up.stack)[up.index] = r
+		return
 	}
-	up.value = v
+	up.value = r
 }
 
-// get the upvalue's inner value.
-func (up *upvar) get() Value {
+// reg returns the upvalue's inner register.
+func (up *upvar) reg() reg {
 	if up.open {
-		// return (*up.stack)[up.level]
-		panic("upvar.get: open!")
+		return (*up.stack)[up.index]
 	}
 	return up.value
 }
@@ -76,7 +85,7 @@ func Closure(impl func(*Thread, Tuple) ([]Value, error), vars ...Value) *GoFunc
 	fn := &GoFunc{impl: impl}
 	up := make([]*upvar, len(vars))
 	for i, v := range vars {
-		up[i] = &upvar{value: v}
+		up[i] = &upvar{value: toreg(v)}
 	}
 	fn.closure = closure{fn, up}
 	return fn
@@ -91,81 +100,154 @@ func (fn *GoFunc) check(ls *thread, argv []Value) (args Tuple, err error) {
 	return args, nil
 }
 
-// call implements the callable interface for Go funcs.
-func (fn *GoFunc) call(ls *thread, argv []Value) ([]Value, error) {
+// call implements the callable interface for Go funcs: the arguments are
+// passed as values from the thread's argument buffer and the results are
+// pushed on the stack above them.
+//
+// A panic in the function is recovered as a *PanicError, unless the
+// runtime is configured with NoRecover.
+func (fn *GoFunc) call(ls *thread, ci *call) (top, n int, err error) {
+	argv, argn := ls.args(ci.sb, ci.sp)
+	if !ls.rt.config.NoRecover {
+		var (
+			calls  = ls.calls
+			inhook = ls.hook.inhook
+		)
+		defer func() {
+			if r := recover(); r != nil {
+				ls.unwind(ci)
+				ls.calls, ls.hook.inhook, ls.argn = calls, inhook, argn
+				top, n, err = 0, 0, fn.panicErr(r)
+			}
+		}()
+	}
 	args, err := fn.check(ls, argv)
 	if err != nil {
-		return nil, err
+		ls.release(argv, argn)
+		return 0, 0, err
+	}
+	rets, err := fn.impl(ls.tt, args)
+	if err == nil {
+		err = ls.checkstack(ci.sp + len(rets))
+	}
+	if err != nil {
+		ls.release(argv, argn)
+		return 0, 0, err
 	}
-	return fn.impl(ls.tt, args)
+	// (results may alias the arguments)
+	for i, v := range rets {
+		ls.stack[ci.sp+i] = toreg(v)
+	}
+	ls.release(argv, argn)
+	top, ci.sp = ci.sp, ci.sp+len(rets)
+	return top, len(rets), nil
+}
+
+// panicErr returns the error for the recovered panic value r.
+func (fn *GoFunc) panicErr(r interface{}) error {
+	name := fn.name
+	if name == "" {
+		name = "?"
+	}
+	return &PanicError{Func: name, Value: r, Stack: godebug.Stack()}
 }
 
 // A Func represents a Lua function value.
 type Func struct {
 	closure
-	stack []Value
 	proto *code.Proto
+	kst   []reg // constants, converted on first use
+	ic    *icache
+}
+
+// icache holds the inline caches of a function prototype's instructions.
+// It is shared by the closures of the prototype created by the same
+// enclosing closure (e.g. in a loop).
+type icache struct {
+	slots  []islot   // by pc, allocated on first use
+	protos []*icache // caches of the nested prototypes
+}
+
+// islot is the inline cache of an instruction reading a field with a
+// constant string key (GETTABUP, GETTABLE and SELF): it holds the value
+// last read from table t, which is valid as long as the version of the
+// table's hash part remains ver; any assignment to the hash part or
+// change of metatable invalidates it.
+type islot struct {
+	t   *Table
+	ver uint64
+	v   reg
+}
+
+// slot returns the inline cache of the instruction at pc of fp. Caches
+// in use are tracked by the runtime to be flushed on collections.
+func (ic *icache) slot(rt *runtime, fp *code.Proto, pc int) *islot {
+	if ic.slots == nil {
+		ic.slots = make([]islot, len(fp.Instrs))
+		rt.track(ic)
+	}
+	return &ic.slots[pc]
+}
+
+// proto returns the inline caches of fp's i'th nested prototype.
+func (ic *icache) proto(fp *code.Proto, i int) *icache {
+	if ic.protos == nil {
+		ic.protos = make([]*icache, len(fp.Protos))
+	}
+	if ic.protos[i] == nil {
+		ic.protos[i] = new(icache)
+	}
+	return ic.protos[i]
 }
 
 // call implements the callable interface for Lua funcs.
-func (fn *Func) call(ls *thread, args []Value) ([]Value, error) {
-	// fmt.Printf("call: args=%v, varg=%v\n", args, ls.fr.call.va)
-	// fn.stack = append(fn.stack, args)
-	for i, arg := range args {
-		fn.stack[i] = arg
-	}
-	return ls.exec(fn)
-}
-
-// kst returns the function i'th constant.
-func (fn *Func) kst(i int) (c Constant) {
-	switch kst := fn.proto.Consts[i].(type) {
-	case float64:
-		return Float(kst)
-	case string:
-		return String(kst)
-	case int64:
-		return Int(kst)
-	case bool:
-		if kst {
-			return True
+func (fn *Func) call(ls *thread, ci *call) (int, int, error) {
+	// (arguments are already on the stack; see thread.prepare)
+	return ls.exec(ci)
+}
+
+// k returns the function i'th constant.
+func (fn *Func) k(i int) reg {
+	if fn.kst == nil {
+		fn.kst = make([]reg, len(fn.proto.Consts))
+		for i, kst := range fn.proto.Consts {
+			switch kst := kst.(type) {
+			case float64:
+				fn.kst[i] = floatreg(Float(kst))
+			case string:
+				fn.kst[i] = reg{v: String(kst)}
+			case int64:
+				fn.kst[i] = intreg(Int(kst))
+			case bool:
+				fn.kst[i] = reg{v: Bool(kst)}
+			}
 		}
-		return False
 	}
-	return c
+	return fn.kst[i]
 }
 
-// rk returns the i'th stack value or the i'th constant if
-// 'i' is a constant index.
-func (fn *Func) rk(i int) Value {
+// rk returns the i'th register of the given stack frame or the i'th
+// constant if 'i' is a constant index.
+func (fn *Func) rk(regs []reg, i int) reg {
 	if code.IsKst(i) {
-		return fn.kst(code.ToKst(i))
+		return fn.k(code.ToKst(i))
 	}
-	return fn.stack[i]
-}
-
-func (fn *Func) close(level int) {
-	fmt.Println("close!")
+	return regs[i]
 }
 
-func (fn *Func) open(stack []Value, encup ...*upvar) {
+// open initializes the closure's upvalues: upvalues referring to local
+// variables of the enclosing function (whose registers start at stack
+// slot base) are open upvalues of the current frame.
+func (fn *Func) open(ls *thread, base int, encup ...*upvar) {
 	cls := closure{fn: fn, up: make([]*upvar, len(fn.proto.UpVars))}
 	fn.closure = cls
 	for i, up := range fn.proto.UpVars {
 		if up.Stack {
 			// upvalue refers to local variable
-			// cls.up[i] = stack[up.Index]
-			panic("open: up in stack!")
+			cls.up[i] = ls.fr.upvar(ls, base+up.Index)
 		} else {
 			// upvalue is in enclosing function
 			cls.up[i] = encup[up.Index]
 		}
 	}
 }
-
-func (fn *Func) checkstack(top, n int) {
-	if room := len(fn.stack) - top; room < n {
-		space := make([]Value, n-room)
-		fn.stack = append(fn.stack, space...)
-	}
-}
@@ -0,0 +1,104 @@
+package lua
+
+import (
+	"fmt"
+)
+
+// finalizers holds the state of a runtime's finalizers (__gc metamethods).
+//
+// Tables and userdata whose metatable has a '__gc' field when it is set
+// through Type.SetMeta are marked for finalization: the runtime keeps them
+// until it measures its memory in use (see runtime.collect) and finds them
+// unreachable from its roots. They are then queued, and kept reachable
+// until their '__gc' metamethods are called on the runtime's goroutine,
+// before the next instruction it executes or by Thread.RunFinalizers.
+//
+// Unlike Go finalizers, this finalizes objects which are part of a cycle
+// of references. Runtimes with marked objects measure their memory in use
+// automatically as allocations grow (see autoMin).
+type finalizers struct {
+	objects []Value        // objects marked for finalization
+	marked  map[Value]bool // the set of objects
+	queue   []Value        // unreachable objects to finalize
+	running bool           // are finalizers running?
+}
+
+// mark marks object v (a table or userdata) for finalization.
+func (rt *runtime) mark(v Value) {
+	switch v.(type) {
+	case *Table, *GoValue:
+	default:
+		return
+	}
+	if rt.fin.marked[v] {
+		return
+	}
+	if rt.fin.marked == nil {
+		rt.fin.marked = make(map[Value]bool)
+	}
+	rt.fin.objects = append(rt.fin.objects, v)
+	rt.fin.marked[v] = true
+	rt.mem.auto = true
+}
+
+// separate queues the objects marked for finalization which meter m did
+// not reach, in the reverse order of their marking.
+func (rt *runtime) separate(m *meter) {
+	objects := rt.fin.objects
+	for i := len(objects) - 1; i >= 0; i-- {
+		if v := objects[i]; !m.seen[v] {
+			rt.fin.queue = append(rt.fin.queue, v)
+			delete(rt.fin.marked, v)
+		}
+	}
+	live := objects[:0]
+	for _, v := range objects {
+		if rt.fin.marked[v] {
+			live = append(live, v)
+		}
+	}
+	clear(objects[len(live):])
+	rt.fin.objects = live
+}
+
+// dequeue returns the next object to finalize, or nil.
+func (rt *runtime) dequeue() (v Value) {
+	if len(rt.fin.queue) > 0 {
+		v = rt.fin.queue[0]
+		rt.fin.queue[0] = nil
+		rt.fin.queue = rt.fin.queue[1:]
+	}
+	return v
+}
+
+// finalize calls the '__gc' metamethods of the queued objects; finalizers
+// do not run recursively (e.g. from a '__gc' metamethod).
+func (ls *thread) finalize() error {
+	if ls.rt.fin.running {
+		return nil
+	}
+	ls.rt.fin.running = true
+	defer func() { ls.rt.fin.running = false }()
+
+	for v := ls.rt.dequeue(); v != nil; v = ls.rt.dequeue() {
+		gc, ok := ls.meta(v, _gc.String()).(callable)
+		if !ok {
+			continue
+		}
+		if _, err := ls.docall(gc.(Value), []Value{v}, 0, finalizer); err != nil {
+			if !Catchable(err) {
+				return err
+			}
+			return fmt.Errorf("error in __gc metamethod (%v)", err)
+		}
+	}
+	return nil
+}
+
+// RunFinalizers calls, on the calling goroutine, the '__gc' metamethods
+// of the objects found unreachable so far (e.g. by Collect), and returns
+// the first error raised by a metamethod. Pending finalizers otherwise
+// run before the next instruction executed by the runtime.
+func (t *Thread) RunFinalizers() error {
+	return t.ls.finalize()
+}
@@ -0,0 +1,92 @@
+package lua_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestFinalizers(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want lua.Value
+	}{
+		{"Garbage", `
+			local s = ""
+			setmetatable({}, {__gc = function() s = s .. "gc" end})
+			collectgarbage()
+			return s`, lua.String("gc")},
+		{"Reachable", `
+			local s = ""
+			local keep = setmetatable({}, {__gc = function() s = s .. "gc" end})
+			collectgarbage()
+			return s`, lua.String("")},
+		{"Cycle", `
+			local s = ""
+			do
+				local b = setmetatable({}, {__gc = function(o) s = s .. "b" end})
+				b.self = b
+				local c = setmetatable({b = b}, {__gc = function(o) s = s .. "c" end})
+				b.c = c
+			end
+			collectgarbage()
+			return s`, lua.String("cb")},
+		{"Order", `
+			local s = ""
+			local function gc(o) s = s .. o[1] end
+			for i = 1, 3 do setmetatable({i}, {__gc = gc}) end
+			collectgarbage()
+			return s`, lua.String("321")},
+		{"Resurrect", `
+			local saved
+			do
+				setmetatable({"x"}, {__gc = function(o) saved = o end})
+			end
+			collectgarbage()
+			collectgarbage()
+			return saved[1]`, lua.String("x")},
+		{"WeakValues", `
+			local values = setmetatable({}, {__mode = "v"})
+			local keys = setmetatable({}, {__mode = "k"})
+			local found
+			do
+				local o = setmetatable({}, {__gc = function(o)
+					found = (values[1] == nil) and (keys[o] == 1)
+				end})
+				values[1], keys[o] = o, 1
+			end
+			collectgarbage()
+			return found`, lua.True},
+		{"Auto", `
+			local n = 0
+			for i = 1, 20000 do
+				local b = setmetatable({string.rep("x", 100)}, {__gc = function() n = n + 1 end})
+				b.self = b
+			end
+			return n > 0`, lua.True},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rets := check(t, tt.src); rets[0] != tt.want {
+				t.Errorf("got %v, want %v", rets[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestFinalizerError(t *testing.T) {
+	ls := newThread(t, nil)
+	_, err := run(t, ls, `
+		local b = setmetatable({}, {__gc = function() error("boom") end})
+		b.self = b
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.Collect()
+	if err := ls.RunFinalizers(); err == nil || !strings.Contains(err.Error(), "error in __gc metamethod") {
+		t.Errorf("RunFinalizers returned %v, want a __gc error", err)
+	}
+}
@@ -0,0 +1,166 @@
+package lua
+
+import (
+	"github.com/Azure/golua/lua/code"
+)
+
+// HookMask is a bit mask specifying on which events a debug hook is called.
+type HookMask int
+
+const (
+	// MaskCall calls the hook when the interpreter calls a function,
+	// just after it enters the new function.
+	MaskCall HookMask = 1 << iota
+
+	// MaskReturn calls the hook when the interpreter returns from a
+	// function, just before it leaves the function.
+	MaskReturn
+
+	// MaskLine calls the hook when the interpreter is about to start
+	// the execution of a new line of code, or when it jumps back in
+	// the code (even to the same line).
+	MaskLine
+
+	// MaskCount calls the hook after the interpreter executes every
+	// count instructions.
+	MaskCount
+)
+
+// HookEvent identifies the event that triggered a debug hook.
+type HookEvent int
+
+const (
+	HookCall HookEvent = iota
+	HookReturn
+	HookLine
+	HookCount
+	HookTailCall
+)
+
+var hookevents = [...]string{
+	HookCall:     "call",
+	HookReturn:   "return",
+	HookLine:     "line",
+	HookCount:    "count",
+	HookTailCall: "tail call",
+}
+
+func (evt HookEvent) String() string { return hookevents[evt] }
+
+// Hook is the type of debugging hook functions.
+//
+// While the interpreter is running a hook, it disables other calls
+// to hooks. The function running when the hook was triggered is at
+// level 0 of the call stack (see Thread.Info).
+//
+// An error returned by a hook is raised in the hooked function, at the
+// point where the hook was called.
+type Hook func(*Thread, HookEvent) error
+
+type hookstate struct {
+	fn     Hook
+	mask   HookMask
+	count  int  // instructions between count events
+	left   int  // instructions left before the next count event
+	oldpc  int  // last pc traced
+	inhook bool // running a hook?
+}
+
+// SetHook sets the debugging hook function.
+//
+// Argument mask specifies on which events the hook will be called. Argument
+// count is only meaningful when the mask includes MaskCount.
+//
+// A hook is disabled by setting mask to zero or fn to nil.
+func (t *Thread) SetHook(mask HookMask, count int, fn Hook) {
+	if fn == nil || mask == 0 { // turn off hooks?
+		mask, fn = 0, nil
+	}
+	t.ls.hook = hookstate{
+		fn:    fn,
+		mask:  mask,
+		count: count,
+		left:  count,
+	}
+}
+
+// Hook returns the current hook function, hook mask and hook count.
+func (t *Thread) Hook() (Hook, HookMask, int) {
+	return t.ls.hook.fn, t.ls.hook.mask, t.ls.hook.count
+}
+
+// runhook calls the hook function for event evt triggered by call ci,
+// and returns its error.
+func (ls *thread) runhook(ci *call, evt HookEvent) error {
+	if ls.hook.fn == nil || ls.hook.inhook { // no hook or running one?
+		return nil
+	}
+	ls.hook.inhook = true // cannot call hooks inside a hook
+	ci.flag |= hooked
+	err := ls.hook.fn(ls.tt, evt)
+	ci.flag &^= hooked
+	ls.hook.inhook = false
+	return err
+}
+
+// callhook runs the call hook (if enabled) for a call entering its function.
+func (ls *thread) callhook(ci *call) (err error) {
+	if ls.hook.mask&MaskCall != 0 {
+		evt := HookCall
+		if ci.flag&tailcall != 0 {
+			evt = HookTailCall
+		}
+		err = ls.runhook(ci, evt)
+	}
+	ls.hook.oldpc = 0
+	return err
+}
+
+// rethook runs the return hook (if enabled) for a call leaving its function
+// and resets the traced pc to the caller's current instruction.
+func (ls *thread) rethook(ci *call) error {
+	if ls.hook.mask&MaskReturn != 0 {
+		if err := ls.runhook(ci, HookReturn); err != nil {
+			return err
+		}
+	}
+	if ci.fr != nil && ci.fr.call != nil {
+		if _, ok := ci.fr.call.fn.(*Func); ok {
+			ls.hook.oldpc = ci.fr.call.pc
+		}
+	}
+	return nil
+}
+
+// traceexec runs the count and line hooks (if enabled) before executing
+// the instruction at the current pc of call ci.
+func (ls *thread) traceexec(ci *call, fp *code.Proto) error {
+	var (
+		mask  = ls.hook.mask
+		count bool
+	)
+	if mask&MaskCount != 0 {
+		if ls.hook.left--; ls.hook.left <= 0 {
+			ls.hook.left = ls.hook.count // reset count
+			count = true
+		}
+	}
+	if count {
+		if err := ls.runhook(ci, HookCount); err != nil {
+			return err
+		}
+	}
+	if mask&MaskLine != 0 && len(fp.PcLine) > ci.pc {
+		npc := ci.pc
+		// call line hook when entering a new function, when jumping
+		// back (loop), or when entering a new line.
+		if npc == 0 || npc <= ls.hook.oldpc || ls.hook.oldpc >= len(fp.PcLine) ||
+			fp.PcLine[npc] != fp.PcLine[ls.hook.oldpc] {
+			if err := ls.runhook(ci, HookLine); err != nil {
+				return err
+			}
+		}
+	}
+	ls.hook.oldpc = ci.pc
+	return nil
+}
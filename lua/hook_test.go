@@ -0,0 +1,36 @@
+package lua_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestHookError(t *testing.T) {
+	for _, mask := range []lua.HookMask{lua.MaskCall, lua.MaskReturn, lua.MaskLine, lua.MaskCount} {
+		ls := newThread(t, nil)
+		ls.SetHook(mask, 1, func(ls *lua.Thread, evt lua.HookEvent) error {
+			ls.SetHook(0, 0, nil)
+			return errors.New("hook failed on " + evt.String())
+		})
+		if _, err := run(t, ls, "local x = 1\nreturn x"); err == nil || !strings.Contains(err.Error(), "hook failed") {
+			t.Errorf("mask %d: got error %v, want the hook's error", mask, err)
+		}
+	}
+}
+
+func TestLuaHookError(t *testing.T) {
+	rets := check(t, `
+		local ok, err = pcall(function()
+			debug.sethook(function() debug.sethook(); error("boom") end, "l")
+			local x = 1
+			return x
+		end)
+		return ok, err
+	`)
+	if rets[0] != lua.False || !strings.HasSuffix(string(rets[1].(lua.String)), "boom") {
+		t.Errorf("pcall returned %v, want false and the hook's error", rets)
+	}
+}
@@ -2,7 +2,9 @@ package lua
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"strings"
 
 	"github.com/Azure/golua/lua/code"
 )
@@ -24,7 +26,11 @@ func gettable(ls *thread, t, k Value) (Value, error) {
 		}
 		switch m := ls.meta(t, "__index").(type) {
 		case callable:
-			panic("gettable: callable")
+			rets, err := ls.call(m.(Value), []Value{t, k}, 1)
+			if err != nil {
+				return nil, err
+			}
+			return rets[0], nil
 		case *Table:
 			t = m
 		default:
@@ -42,7 +48,7 @@ func settable(ls *thread, t, k, v Value) error {
 	// - If metamethod exists and function, call 't.__index(t, k)'.
 	for loop := 0; loop < maxMetaLoop; loop++ {
 		if t, ok := t.(*Table); ok {
-			if v := t.Get(k); v != nil {
+			if t.Get(k) != nil {
 				t.Set(k, v)
 				return nil
 			}
@@ -50,16 +56,25 @@ func settable(ls *thread, t, k, v Value) error {
 		if m := ls.meta(t, "__newindex"); m != nil {
 			switch m := m.(type) {
 			case callable:
-				panic("set: callable!")
-				// lua۰push(t, m, tbl, key, value)
-				// lua۰call(t, 3, 0)
-				// 	panic(runtimeErr("settable: call __newindex: todo"))
+				_, err := ls.call(m.(Value), []Value{t, k, v}, 0)
+				return err
 			case *Table:
 				t = m
 				continue
 			}
 		}
-		if t, ok := t.(*Table); ok {
+		if t, ok := t.(*Table); ok && v != nil {
+			switch k := k.(type) {
+			case nil:
+				return fmt.Errorf("table index is nil")
+			case Float:
+				if math.IsNaN(float64(k)) {
+					return fmt.Errorf("table index is NaN")
+				}
+			}
+			if err := ls.rt.alloc(sizeEntry); err != nil {
+				return err
+			}
 			t.Set(k, v)
 		}
 		return nil
@@ -99,7 +114,43 @@ func compare(ls *thread, op Op, x, y Value) (bool, error) {
 }
 
 func equals(ls *thread, x, y Value) (bool, error) {
-	return false, fmt.Errorf("equals: todo!")
+	switch x := x.(type) {
+	case Int:
+		if y, ok := y.(Float); ok {
+			return eqIntFloat(x, y), nil
+		}
+	case Float:
+		if y, ok := y.(Int); ok {
+			return eqIntFloat(y, x), nil
+		}
+	case *Table:
+		if y, ok := y.(*Table); ok && x != y {
+			return eqmeta(ls, x, y)
+		}
+	case *GoValue:
+		if y, ok := y.(*GoValue); ok && x != y {
+			return eqmeta(ls, x, y)
+		}
+	}
+	return x == y, nil
+}
+
+// eqmeta compares two distinct tables (or userdata) using their '__eq'
+// metamethod; they are different if neither has one.
+func eqmeta(ls *thread, x, y Value) (bool, error) {
+	if ls.meta(x, "__eq") == nil && ls.meta(y, "__eq") == nil {
+		return false, nil
+	}
+	v, err := _eq.compare(ls, x, y)
+	return Truth(v), err
+}
+
+// compareErr returns the error for an order comparison of x and y.
+func compareErr(x, y Value) error {
+	if t1, t2 := TypeName(x), TypeName(y); t1 != t2 {
+		return fmt.Errorf("attempt to compare %s with %s", t1, t2)
+	}
+	return fmt.Errorf("attempt to compare two %s values", TypeName(x))
 }
 
 func lesseq(ls *thread, x, y Value) (bool, error) {
@@ -113,18 +164,27 @@ func lesseq(ls *thread, x, y Value) (bool, error) {
 		case Float:
 			return x <= y, nil
 		case Int:
-			return x <= Float(y), nil
+			return leFloatInt(x, y), nil
 		}
 	case Int:
 		switch y := y.(type) {
 		case Float:
-			return Float(x) <= y, nil
+			return leIntFloat(x, y), nil
 		case Int:
 			return x <= y, nil
 		}
 	}
 
-	return false, fmt.Errorf("lesseq: meta: todo!")
+	if ls.meta(x, "__le") != nil || ls.meta(y, "__le") != nil {
+		v, err := _le.compare(ls, x, y)
+		return Truth(v), err
+	}
+	// try 'not (y < x)'
+	if ls.meta(x, "__lt") != nil || ls.meta(y, "__lt") != nil {
+		v, err := _lt.compare(ls, y, x)
+		return !Truth(v), err
+	}
+	return false, compareErr(x, y)
 }
 
 func less(ls *thread, x, y Value) (bool, error) {
@@ -138,29 +198,42 @@ func less(ls *thread, x, y Value) (bool, error) {
 		case Float:
 			return x < y, nil
 		case Int:
-			return x < Float(y), nil
+			return ltFloatInt(x, y), nil
 		}
 	case Int:
 		switch y := y.(type) {
 		case Float:
-			return Float(x) < y, nil
+			return ltIntFloat(x, y), nil
 		case Int:
 			return x < y, nil
 		}
 	}
-	return false, fmt.Errorf("less: meta: todo!")
+	if ls.meta(x, "__lt") != nil || ls.meta(y, "__lt") != nil {
+		v, err := _lt.compare(ls, x, y)
+		return Truth(v), err
+	}
+	return false, compareErr(x, y)
 }
 
+// length returns #x: the length of a string, the result of the '__len'
+// metamethod of x, or the border of a table (see Table.Length).
 func length(ls *thread, x Value) (Value, error) {
-	switch x := x.(type) {
-	case String:
-		return Int(len(x)), nil
-	case *Table:
-		if ls == nil || x.meta == nil {
-			return x.Length(), nil
+	if s, ok := x.(String); ok {
+		return Int(len(s)), nil
+	}
+	if ls != nil {
+		if method := ls.meta(x, _len.String()); method != nil {
+			rets, err := ls.call(method, []Value{x, x}, 1)
+			if err != nil {
+				return nil, err
+			}
+			return rets[0], nil
 		}
 	}
-	return nil, fmt.Errorf("length: meta: todo!")
+	if t, ok := x.(*Table); ok {
+		return t.Length(), nil
+	}
+	return nil, fmt.Errorf("attempt to get length of a %s value", TypeName(x))
 }
 
 // UNM, BNOT, NOT, LEN
@@ -178,17 +251,194 @@ func unary(ls *thread, op Op, x Value) (Value, error) {
 	panic(fmt.Errorf("unexpected unary operator '%v'", op))
 }
 
-func (ls *thread) exec(fn *Func) (rets []Value, err error) {
+// concat concatenates the values xs (right associative); runs of strings
+// and numbers are joined at once, other operands are concatenated by
+// their '__concat' metamethod.
+func (ls *thread) concat(xs []reg) (Value, error) {
+	v := xs[len(xs)-1].value()
+	for n := len(xs) - 1; n > 0; {
+		if IsString(v) && IsString(xs[n-1].value()) {
+			i := n - 1
+			for i > 0 && IsString(xs[i-1].value()) {
+				i--
+			}
+			s, _ := ToString(v)
+			size := len(s)
+			for _, x := range xs[i:n] {
+				s, _ := ToString(x.value())
+				size += len(s)
+			}
+			if err := ls.rt.alloc(sizeString + size); err != nil {
+				return nil, err
+			}
+			var b strings.Builder
+			b.Grow(size)
+			for _, x := range xs[i:n] {
+				s, _ := ToString(x.value())
+				b.WriteString(string(s))
+			}
+			b.WriteString(string(s))
+			v, n = String(b.String()), i
+			continue
+		}
+		var err error
+		if v, err = _concat.binary(ls, xs[n-1].value(), v); err != nil {
+			return nil, err
+		}
+		n--
+	}
+	return v, nil
+}
+
+// index returns t[k] for registers t and k: table keys are looked up
+// without boxing, other cases (e.g. metamethods) are left to gettable.
+func (ls *thread) index(t, k reg) (reg, error) {
+	if t, ok := t.v.(*Table); ok {
+		if v := t.getreg(k); v != nil {
+			return toreg(v), nil
+		}
+	}
+	v, err := gettable(ls, t.value(), k.value())
+	return toreg(v), err
+}
+
+// field returns t[k] for the constant string key k of the instruction
+// whose inline cache is ic (caches do not record the key, so variable keys
+// must not use them): a key found in table t is cached until the table is
+// modified; other cases (including weak tables, whose values
+// must not be retained by caches) are left to index.
+func (ls *thread) field(ic *islot, t, k reg) (reg, error) {
+	if tbl, ok := t.v.(*Table); ok && tbl.mode == 0 {
+		if ic.t == tbl && ic.ver == tbl.ver {
+			return ic.v, nil
+		}
+		if v := tbl.kvs[k.v].value; v != nil {
+			*ic = islot{t: tbl, ver: tbl.ver, v: toreg(v)}
+			return ic.v, nil
+		}
+	}
+	return ls.index(t, k)
+}
+
+// setindex assigns t[k] = v for registers t, k and v: existing keys of
+// tables are assigned directly, other cases are left to settable.
+func (ls *thread) setindex(t, k, v reg) error {
+	if t, ok := t.v.(*Table); ok && t.setreg(k, v) {
+		return nil
+	}
+	return settable(ls, t.value(), k.value(), v.value())
+}
+
+// forprep prepares the numeric for loop whose control values (initial
+// value, limit and step) are in regs[0:3]: the loop runs on integers if
+// both the initial value and the step are integers, on floats otherwise.
+func forprep(regs []reg) error {
+	var (
+		init, limit, step = regs[0], regs[1], regs[2]
+	)
+	if init.t == regInt && step.t == regInt {
+		i, s := Int(init.n), Int(step.n)
+		if n, skip, ok := forlimit(limit, s); ok {
+			if skip {
+				i = 0
+			}
+			regs[0], regs[1] = intreg(i-s), intreg(n)
+			return nil
+		}
+	}
+	fi, ok := ToFloat(init.value())
+	if !ok {
+		return fmt.Errorf("'for' initial value must be a number")
+	}
+	fl, ok := ToFloat(limit.value())
+	if !ok {
+		return fmt.Errorf("'for' limit must be a number")
+	}
+	fs, ok := ToFloat(step.value())
+	if !ok {
+		return fmt.Errorf("'for' step must be a number")
+	}
+	regs[0], regs[1], regs[2] = floatreg(fi-fs), floatreg(fl), floatreg(fs)
+	return nil
+}
+
+// forlimit converts the limit of an integer for loop to an integer,
+// clipping it to the integer range; skip reports whether the loop must
+// not run at all.
+func forlimit(limit reg, step Int) (n Int, skip, ok bool) {
+	switch v := limit.value().(type) {
+	case Int:
+		return v, false, true
+	case Float:
+		f := math.Floor(float64(v))
+		if step < 0 {
+			f = math.Ceil(float64(v))
+		}
+		switch {
+		case math.IsNaN(f):
+			return 0, true, true
+		case f >= -math.MinInt64:
+			return math.MaxInt64, step < 0, true
+		case f < math.MinInt64:
+			return math.MinInt64, step > 0, true
+		}
+		return Int(f), false, true
+	}
+	return 0, false, false
+}
+
+// forloop steps the numeric for loop whose control values are in regs[0:3],
+// reporting whether to continue the loop; the loop variable is regs[3].
+func forloop(regs []reg) bool {
+	if regs[0].t == regInt {
+		var (
+			step  = Int(regs[2].n)
+			limit = Int(regs[1].n)
+			i     = Int(regs[0].n) + step
+		)
+		if (step > 0 && i <= limit) || (step <= 0 && limit <= i) {
+			regs[0] = intreg(i)
+			regs[3] = regs[0]
+			return true
+		}
+		return false
+	}
+	var (
+		step, _  = regs[2].float()
+		limit, _ = regs[1].float()
+		i, _     = regs[0].float()
+	)
+	if i += step; (step > 0 && i <= limit) || (step <= 0 && limit <= i) {
+		regs[0] = floatreg(i)
+		regs[3] = regs[0]
+		return true
+	}
+	return false
+}
+
+// exec executes the Lua function of call ci; the function's registers
+// are the thread's stack slots starting at the call's base. It returns
+// the stack index and the number of the function's results.
+func (ls *thread) exec(ci *call) (first, n int, err error) {
 	const trace = false
 	var (
-		fp = fn.proto
-		fr = ls.fr
+		fn   = ci.fn.(*Func)
+		fp   = fn.proto
+		base = ci.sb
 	)
 frame:
-	for ci := fr.call; ci.pc < len(fp.Instrs); ci.pc++ {
+	for ; ci.pc < len(fp.Instrs); ci.pc++ {
 		if trace {
 			fmt.Printf("[%d] %v\n", ci.pc, fp.Instrs[ci.pc])
 		}
+		if err = ls.checkpoint(); err != nil {
+			break frame
+		}
+		if ls.hook.mask&(MaskLine|MaskCount) != 0 {
+			if err = ls.traceexec(ci, fp); err != nil {
+				break frame
+			}
+		}
 		switch inst := fp.Instrs[ci.pc]; inst.Code() {
 		// Unary operators.
 		//
@@ -198,13 +448,25 @@ frame:
 		case code.BNOT, code.UNM, code.NOT, code.LEN:
 			var (
 				op = Op(inst.Code()-code.UNM) + OpMinus
-				x  = fn.rk(inst.B())
+				x  = fn.rk(ls.stack[base:], inst.B())
 				v  Value
 			)
-			if v, err = unary(ls, op, x); err != nil {
+			switch {
+			case op == OpMinus && x.t == regInt:
+				ls.stack[base+inst.A()] = intreg(-Int(x.n))
+				continue
+			case op == OpMinus && x.t == regFloat:
+				f, _ := x.float()
+				ls.stack[base+inst.A()] = floatreg(-f)
+				continue
+			case op == OpNot:
+				ls.stack[base+inst.A()] = reg{v: Bool(!x.truth())}
+				continue
+			}
+			if v, err = unary(ls, op, x.value()); err != nil {
 				break frame
 			}
-			fn.stack[inst.A()] = v
+			ls.stack[base+inst.A()] = toreg(v)
 
 		// Comparison operators with conditional jump.
 		//
@@ -214,11 +476,17 @@ frame:
 		case code.EQ, code.LT, code.LE:
 			var (
 				op = Op(inst.Code()-code.EQ) + OpEq
-				x  = fn.rk(inst.B())
-				y  = fn.rk(inst.C())
+				x  = fn.rk(ls.stack[base:], inst.B())
+				y  = fn.rk(ls.stack[base:], inst.C())
 				v  bool
 			)
-			if v, err = compare(ls, op, x, y); err != nil {
+			if v, ok := order(op, x, y); ok {
+				if v != (inst.A() == 1) {
+					ci.pc++
+				}
+				continue
+			}
+			if v, err = compare(ls, op, x.value(), y.value()); err != nil {
 				break frame
 			}
 			if v != (inst.A() == 1) {
@@ -244,14 +512,18 @@ frame:
 			code.SHR:
 			var (
 				op = Op(inst.Code()-code.ADD) + OpAdd
-				x  = fn.rk(inst.B())
-				y  = fn.rk(inst.C())
+				x  = fn.rk(ls.stack[base:], inst.B())
+				y  = fn.rk(ls.stack[base:], inst.C())
 				v  Value
 			)
-			if v, err = binary(ls, op, x, y); err != nil {
+			if r, ok := arith(op, x, y); ok {
+				ls.stack[base+inst.A()] = r
+				continue
+			}
+			if v, err = binary(ls, op, x.value(), y.value()); err != nil {
 				break frame
 			}
-			fn.stack[inst.A()] = v
+			ls.stack[base+inst.A()] = toreg(v)
 
 		// CONCAT: Concatenate a range of registers.
 		//
@@ -259,11 +531,11 @@ frame:
 		//
 		// R(A) := R(B).. ... ..R(C)
 		case code.CONCAT:
-			// if xs := fn.stack[inst.B():inst.C()+1]; len(xs) > 1 {
-			//     fn.stack[inst.A()] = ls.concat(xs)
-			//     fn.sp = inst.A()
-			// }
-			panic("code.CONCAT")
+			var v Value
+			if v, err = ls.concat(ls.stack[base+inst.B() : base+inst.C()+1]); err != nil {
+				break frame
+			}
+			ls.stack[base+inst.A()] = reg{v: v}
 
 		// FORPREP: Initialization for a numeric for loop.
 		//
@@ -271,7 +543,10 @@ frame:
 		//
 		// R(A) -= R(A+2); pc+=sBx
 		case code.FORPREP:
-			panic("code.FORPREP")
+			if err = forprep(ls.stack[base+inst.A():]); err != nil {
+				break frame
+			}
+			ci.pc += inst.SBX()
 
 		// FORLOOP: Iterate a numeric for loop.
 		//
@@ -279,7 +554,9 @@ frame:
 		//
 		// R(A) += R(A+2); if R(A) <?= R(A+1) then { pc+=sBx; R(A+3)=R(A) }
 		case code.FORLOOP:
-			panic("code.FORLOOP")
+			if forloop(ls.stack[base+inst.A():]) {
+				ci.pc += inst.SBX()
+			}
 
 		// TFORCALL: Iterate a generic for loop.
 		//
@@ -300,21 +577,14 @@ frame:
 		case code.TFORCALL:
 			// tforcall expects the for variables below to be at a fixed
 			// position in the stack for every iteration, so we need to
-			// adjust the stack to ensure this to avoid side effects.
-			var (
-				ctrl = fn.stack[inst.A()+2]
-				data = fn.stack[inst.A()+1]
-				iter = fn.stack[inst.A()]
-				base = inst.A() + 3
-				rvs  []Value
-			)
-			rvs, err = ls.call(iter, []Value{data, ctrl}, inst.C())
-			if err != nil {
+			// adjust the stack to ensure this to avoid side effects: the
+			// iterator is called on a copy of R(A), R(A+1) and R(A+2) at
+			// R(A+3), so that its results land in the loop variables.
+			a := base + inst.A()
+			copy(ls.stack[a+3:a+6], ls.stack[a:a+3])
+			if _, err = ls.invoke(a+3, 2, inst.C(), 0); err != nil {
 				break frame
 			}
-			for i, ret := range rvs {
-				fn.stack[base+i] = ret
-			}
 
 		// TFORLOOP: Initialization for a generic for loop.
 		//
@@ -322,9 +592,9 @@ frame:
 		//
 		// if R(A+1) ~= nil then { R(A)=R(A+1); pc += sBx }
 		case code.TFORLOOP:
-			if ctrl := fn.stack[inst.A()+1]; ctrl != nil { // continue loop?
-				fn.stack[inst.A()] = ctrl // save control variable
-				ci.pc += inst.SBX()       // jump back
+			if ctrl := ls.stack[base+inst.A()+1]; !ctrl.isnil() { // continue loop?
+				ls.stack[base+inst.A()] = ctrl // save control variable
+				ci.pc += inst.SBX()            // jump back
 			}
 
 		// NEWTABLE: Create a new table.
@@ -351,10 +621,13 @@ frame:
 		// R(A) := {} (size = B,C)
 		case code.NEWTABLE:
 			var (
-				arrN = fb2int(inst.B())
-				kvsN = fb2int(inst.C())
+				arrN = min(fb2int(inst.B()), maxSizeHint)
+				kvsN = min(fb2int(inst.C()), maxSizeHint)
 			)
-			fn.stack[inst.A()] = NewTableSize(arrN, kvsN)
+			if err = ls.rt.alloc(sizeTable + (arrN+kvsN)*sizeEntry); err != nil {
+				break frame
+			}
+			ls.stack[base+inst.A()] = reg{v: NewTableSize(arrN, kvsN)}
 
 		// GETTABLE: Read a table element into a register (locals).
 		//
@@ -363,14 +636,19 @@ frame:
 		// R(A) := R(B)[RK(C)]
 		case code.GETTABLE:
 			var (
-				t = fn.stack[inst.B()]
-				k = fn.rk(inst.C())
-				v Value
+				t = ls.stack[base+inst.B()]
+				k = fn.rk(ls.stack[base:], inst.C())
+				v reg
 			)
-			if v, err = gettable(ls, t, k); err != nil {
+			if code.IsKst(inst.C()) && k.isstring() {
+				v, err = ls.field(fn.ic.slot(ls.rt, fp, ci.pc), t, k)
+			} else {
+				v, err = ls.index(t, k)
+			}
+			if err != nil {
 				break frame
 			}
-			fn.stack[inst.A()] = v
+			ls.stack[base+inst.A()] = v
 
 		// SETTABLE: Write a register value into a table element (locals).
 		//
@@ -379,11 +657,11 @@ frame:
 		// R(A)[RK(B)] := RK(C)
 		case code.SETTABLE:
 			var (
-				t = fn.stack[inst.A()]
-				k = fn.rk(inst.B())
-				v = fn.rk(inst.C())
+				t = ls.stack[base+inst.A()]
+				k = fn.rk(ls.stack[base:], inst.B())
+				v = fn.rk(ls.stack[base:], inst.C())
 			)
-			if err := settable(ls, t, k, v); err != nil {
+			if err = ls.setindex(t, k, v); err != nil {
 				break frame
 			}
 
@@ -416,7 +694,10 @@ frame:
 				c = inst.C()
 			)
 			if b == 0 {
-				b = (ci.sp - a) - 1
+				b = (ci.sp - (base + a)) - 1
+			}
+			if err = ls.rt.alloc(b * sizeEntry); err != nil {
+				break frame
 			}
 			if c == 0 {
 				// ASSERT: fn.Instrs[fn.pc+1] == EXTRAARG)
@@ -424,9 +705,13 @@ frame:
 				ci.pc++
 			}
 			o := (c-1)*fieldsPerFlush + b
-			t := fn.stack[a].(*Table)
+			t, ok := ls.stack[base+a].v.(*Table)
+			if !ok { // (only in forged binary chunks)
+				err = fmt.Errorf("attempt to set list items of a %s value", TypeName(ls.stack[base+a].value()))
+				break frame
+			}
 			for b > 0 {
-				t.Set(Int(o), fn.stack[a+b])
+				t.Set(Int(o), ls.stack[base+a+b].value())
 				o--
 				b--
 			}
@@ -438,16 +723,20 @@ frame:
 		// R(A+1) := R(B); R(A) := R(B)[RK(C)]
 		case code.SELF:
 			var (
-				self = fn.stack[inst.B()]
-				k    = fn.rk(inst.C())
-				v    Value
+				self = ls.stack[base+inst.B()]
+				k    = fn.rk(ls.stack[base:], inst.C())
+				v    reg
 			)
-			v, err = gettable(ls, self, k)
+			if code.IsKst(inst.C()) && k.isstring() {
+				v, err = ls.field(fn.ic.slot(ls.rt, fp, ci.pc), self, k)
+			} else {
+				v, err = ls.index(self, k)
+			}
 			if err != nil {
 				break frame
 			}
-			fn.stack[inst.A()+1] = self
-			fn.stack[inst.A()] = v
+			ls.stack[base+inst.A()+1] = self
+			ls.stack[base+inst.A()] = v
 
 			// GETTABUP: Read a value from table in
 			// up-value into a register (globals).
@@ -457,14 +746,19 @@ frame:
 			// R(A) := UpValue[B][RK(C)]
 		case code.GETTABUP:
 			var (
-				t = fn.up[inst.B()].get()
-				k = fn.rk(inst.C())
-				v Value
+				t = fn.up[inst.B()].reg()
+				k = fn.rk(ls.stack[base:], inst.C())
+				v reg
 			)
-			if v, err = gettable(ls, t, k); err != nil {
+			if code.IsKst(inst.C()) && k.isstring() {
+				v, err = ls.field(fn.ic.slot(ls.rt, fp, ci.pc), t, k)
+			} else {
+				v, err = ls.index(t, k)
+			}
+			if err != nil {
 				break frame
 			}
-			fn.stack[inst.A()] = v
+			ls.stack[base+inst.A()] = v
 
 		// SETTABUP: Write a register value into table in up-value (globals).
 		//
@@ -473,11 +767,11 @@ frame:
 		// UpValue[A][RK(B)] := RK(C)
 		case code.SETTABUP:
 			var (
-				t = fn.up[inst.A()].get()
-				k = fn.rk(inst.B())
-				v = fn.rk(inst.C())
+				t = fn.up[inst.A()].reg()
+				k = fn.rk(ls.stack[base:], inst.B())
+				v = fn.rk(ls.stack[base:], inst.C())
 			)
-			if err := settable(ls, t, k, v); err != nil {
+			if err = ls.setindex(t, k, v); err != nil {
 				break frame
 			}
 
@@ -487,8 +781,7 @@ frame:
 		//
 		// R(A) := UpValue[B]
 		case code.GETUPVAL:
-			// fn.stack[inst.A()] = fn.up[inst.B()].get()
-			panic("code.GETUPVAL")
+			ls.stack[base+inst.A()] = fn.up[inst.B()].reg()
 
 		// SETUPVAL: Write a register value into an upvalue.
 		//
@@ -496,8 +789,7 @@ frame:
 		//
 		// UpValue[B] := R(A)
 		case code.SETUPVAL:
-			// fn.up[inst.B()].set(fn.stack[inst.A()])
-			panic("code.SETUPVAL")
+			fn.up[inst.B()].setreg(ls.stack[base+inst.A()])
 
 		// TESTSET: Boolean test, with conditional jump and assignment.
 		//
@@ -505,10 +797,10 @@ frame:
 		//
 		// if (R(B) <=> C) then R(A) := R(B) else pc++
 		case code.TESTSET:
-			if Truth(fn.stack[inst.B()]) != (inst.C() == 1) {
+			if ls.stack[base+inst.B()].truth() != (inst.C() == 1) {
 				ci.pc++
 			}
-			fn.stack[inst.A()] = fn.stack[inst.B()]
+			ls.stack[base+inst.A()] = ls.stack[base+inst.B()]
 
 		// TEST: Boolean test, with conditional jump.
 		//
@@ -516,7 +808,7 @@ frame:
 		//
 		// if not (R(A) <=> C) then pc++
 		case code.TEST:
-			if Truth(fn.stack[inst.A()]) != (inst.C() == 1) {
+			if ls.stack[base+inst.A()].truth() != (inst.C() == 1) {
 				ci.pc++
 			}
 
@@ -526,9 +818,7 @@ frame:
 		//
 		// R(A), R(A+1), ..., R(A+B) := nil
 		case code.LOADNIL:
-			for i := inst.A(); i <= inst.A()+inst.B(); i++ {
-				fn.stack[i] = nil
-			}
+			clear(ls.stack[base+inst.A() : base+inst.A()+inst.B()+1])
 
 		// LOADBOOL: Load a boolean into a register.
 		//
@@ -536,8 +826,7 @@ frame:
 		//
 		// R(A) := (Bool)B; if (C) pc++
 		case code.LOADBOOL:
-			truth := (Bool(inst.B() == 1))
-			fn.stack[inst.A()] = truth
+			ls.stack[base+inst.A()] = reg{v: Bool(inst.B() == 1)}
 			if inst.C() != 0 {
 				ci.pc++
 			}
@@ -549,7 +838,7 @@ frame:
 		//
 		// R(A) := Kst(extra arg)
 		case code.LOADKX:
-			fn.stack[inst.A()] = fn.kst(fp.Instrs[ci.pc+1].AX())
+			ls.stack[base+inst.A()] = fn.k(fp.Instrs[ci.pc+1].AX())
 			ci.pc++
 
 		// LOADK: Load a constant into a register.
@@ -558,7 +847,7 @@ frame:
 		//
 		// R(A) := Kst(Bx)
 		case code.LOADK:
-			fn.stack[inst.A()] = fn.kst(inst.BX())
+			ls.stack[base+inst.A()] = fn.k(inst.BX())
 
 		// MOVE: Copy a value between registers.
 		//
@@ -566,7 +855,7 @@ frame:
 		//
 		// R(A) := R(B)
 		case code.MOVE:
-			fn.stack[inst.A()] = fn.stack[inst.B()]
+			ls.stack[base+inst.A()] = ls.stack[base+inst.B()]
 
 		// JMP: Unconditional jump.
 		//
@@ -576,7 +865,7 @@ frame:
 		case code.JMP:
 			// if (A) close all upvalues >= R(A-1)
 			if inst.A() != 0 {
-				fn.close(inst.A() - 1)
+				ls.fr.close(base + inst.A() - 1)
 			}
 			ci.pc += inst.SBX()
 
@@ -586,9 +875,13 @@ frame:
 		//
 		// R(A) := closure(KPROTO[Bx])
 		case code.CLOSURE:
-			cls := &Func{proto: fp.Protos[inst.BX()]}
-			fn.stack[inst.A()] = cls
-			cls.open(fn.stack, fn.up...)
+			ups := len(fp.Protos[inst.BX()].UpVars)
+			if err = ls.rt.alloc(sizeFunc + ups*sizeUpVar); err != nil {
+				break frame
+			}
+			cls := &Func{proto: fp.Protos[inst.BX()], ic: fn.ic.proto(fp, inst.BX())}
+			ls.stack[base+inst.A()] = reg{v: cls}
+			cls.open(ls, base, fn.up...)
 
 		// VARARG: Assign vararg function arguments to registers.
 		//
@@ -608,23 +901,18 @@ frame:
 		case code.VARARG:
 			var (
 				b = inst.B() - 1
-				a = inst.A()
-				n = len(ci.va)
-				i int
+				a = base + inst.A()
+				n = ci.va
 			)
 			if b < 0 {
-				// fn.check(inst.A() + n)
-				ci.sp = inst.A() + n
+				if err = ls.checkstack(a + n); err != nil {
+					break frame
+				}
+				ci.sp = a + n
 				b = n
 			}
-			for i < b && i < n {
-				fn.stack[a+i] = ci.va[i]
-				i++
-			}
-			for i < b {
-				fn.stack[a+i] = nil
-				i++
-			}
+			n = copy(ls.stack[a:a+min(b, n)], ls.stack[base-n:base])
+			clear(ls.stack[a+n : a+b]) // pad with nils
 
 		// TAILCALL: Perform a tail call.
 		//
@@ -645,7 +933,33 @@ frame:
 		//
 		// return R(A)(R(A+1), ... ,R(A+B-1))
 		case code.TAILCALL:
-			panic("tailcall!")
+			var (
+				a    = base + inst.A()
+				argc = inst.B() - 1
+			)
+			if argc < 0 {
+				argc = ci.sp - a - 1
+			}
+			cls, ok := ls.stack[a].v.(*Func)
+			if !ok { // not a Lua function: call it and return its results
+				first = a
+				n, err = ls.invoke(a, argc, -1, 0)
+				break frame
+			}
+			// Lua function: replace the running function in the same
+			// call frame, moving the callee and its arguments down.
+			ls.fr.close(base)
+			slot := ci.slot()
+			copy(ls.stack[slot:], ls.stack[a:a+argc+1])
+			if err = ls.enter(ci, cls, slot, argc); err != nil {
+				break frame
+			}
+			ci.flag |= tailcall
+			fn, fp, base = cls, cls.proto, ci.sb
+			if err = ls.callhook(ci); err != nil {
+				break frame
+			}
+			ci.pc = -1 // restart at the entry point
 
 		// CALL: Calls a function.
 		//
@@ -676,31 +990,19 @@ frame:
 		// R(A), ... ,R(A+C-2) := R(A)(R(A+1), ... ,R(A+B-1))
 		case code.CALL:
 			var (
-				base = inst.A() + 1
+				a    = base + inst.A()
 				argc = inst.B() - 1
 				want = inst.C() - 1
-				args []Value
-				rvs  []Value
+				n    int
 			)
 			if argc < 0 {
-				args = fn.stack[base : (ci.sp-inst.A())+base]
-				// panic("call: argc < 0")
-			} else {
-				args = fn.stack[base : base+argc]
+				argc = ci.sp - a - 1
 			}
-			rvs, err = ls.call(fn.stack[base-1], args, want)
-			if err != nil {
+			if n, err = ls.invoke(a, argc, want, 0); err != nil {
 				break frame
 			}
-			fn.checkstack(base, len(rvs))
-			for i, ret := range rvs {
-				fn.stack[base+i-1] = ret
-			}
 			if want < 0 {
-				ci.sp = inst.A() + len(rvs) - 1
-				// panic("call: want < 0")
-			} else {
-				ci.sp = base + want
+				ci.sp = a + n
 			}
 
 			// RETURN: Returns from function call.
@@ -727,12 +1029,12 @@ frame:
 		case code.RETURN:
 			var (
 				b = inst.B() - 1
-				a = inst.A()
+				a = base + inst.A()
 			)
 			if b < 0 {
 				b = ci.sp - a
 			}
-			rets = fn.stack[a : a+b]
+			first, n = a, b
 			break frame
 
 		// EXTRAARG: Extra (larger) argument for previous opcode.
@@ -747,5 +1049,5 @@ frame:
 		}
 	}
 
-	return rets, ls.error(err)
+	return first, n, ls.error(err)
 }
@@ -0,0 +1,53 @@
+package lua_test
+
+import (
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestFieldCache(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want lua.Value
+	}{
+		{"VariableKey", `
+			local t, s = {a = 1, b = 2, c = 3}, ""
+			for _, k in ipairs{"a", "b", "c"} do s = s .. t[k] end
+			return s`, lua.String("123")},
+		{"VariableMethod", `
+			local obj = {}
+			function obj:f() return "f" end
+			function obj:g() return "g" end
+			local s = ""
+			for _, name in ipairs{"f", "g", "f"} do s = s .. obj[name](obj) end
+			return s`, lua.String("fgf")},
+		{"Assignment", `
+			local t, s = {x = 1}, ""
+			for i = 1, 3 do s = s .. t.x; t.x = t.x + 1 end
+			return s`, lua.String("123")},
+		{"Tables", `
+			local s = ""
+			for _, t in ipairs{{x = 1}, {x = 2}, {x = 3}} do s = s .. t.x end
+			return s`, lua.String("123")},
+		{"Metatable", `
+			local t, s = {}, ""
+			for i = 1, 3 do
+				setmetatable(t, {__index = {x = i}})
+				s = s .. t.x
+			end
+			return s`, lua.String("123")},
+		{"Global", `
+			local s = ""
+			for i = 1, 3 do g = i; s = s .. g end
+			return s`, lua.String("123")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rets := check(t, tt.src); rets[0] != tt.want {
+				t.Errorf("got %v, want %v", rets[0], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrBudget is the error returned when a thread runs out of its
+	// instruction budget (see Thread.SetBudget).
+	ErrBudget = errors.New("instruction budget exhausted")
+
+	// ErrInterrupt is the error returned when a running thread is
+	// stopped by Thread.Interrupt.
+	ErrInterrupt = errors.New("interrupted")
+)
+
+// Catchable reports whether err can be caught by Lua code (e.g. by pcall);
+// errors stopping the thread, such as an exhausted budget, an interrupt or
+// a done context, are not.
+func Catchable(err error) bool {
+	switch {
+	case errors.Is(err, ErrBudget),
+		errors.Is(err, ErrInterrupt),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+type limits struct {
+	quota   int64 // budget of each call from Go
+	budget  int64 // instructions left before exhausting the budget
+	limited bool  // is the budget enforced?
+	halt    int32 // set (atomically) by Interrupt
+}
+
+// SetBudget sets the number of VM instructions each call from Go into
+// the thread may execute before failing with ErrBudget; n == 0 removes
+// the limit.
+//
+// The budget is refilled at the start of every call from Go, so a call
+// exhausting it does not prevent later ones. Calls made by Go functions
+// while Lua code is running share the budget of the outermost call.
+func (t *Thread) SetBudget(n int64) {
+	t.ls.limits.quota = n
+	t.ls.limits.budget = n
+	t.ls.limits.limited = n != 0
+}
+
+// Budget returns the number of VM instructions left in the budget of the
+// running (or last) call and whether a budget is enforced.
+func (t *Thread) Budget() (int64, bool) {
+	return t.ls.limits.budget, t.ls.limits.limited
+}
+
+// Interrupt stops the Lua code running on the thread at the next
+// instruction, failing the running call with ErrInterrupt. If the
+// thread is not running Lua code, the next instruction it executes
+// is interrupted.
+//
+// Interrupt is safe to call from any goroutine.
+func (t *Thread) Interrupt() {
+	atomic.StoreInt32(&t.ls.limits.halt, 1)
+}
+
+// checkpoint reports the error stopping execution before the next
+// instruction, if any. Pending finalizers run at checkpoints.
+func (ls *thread) checkpoint() error {
+	if atomic.LoadInt32(&ls.limits.halt) != 0 {
+		atomic.StoreInt32(&ls.limits.halt, 0)
+		return ErrInterrupt
+	}
+	if ls.done != nil {
+		if err := ls.cancelled(); err != nil {
+			return err
+		}
+	}
+	if ls.limits.limited {
+		if ls.limits.budget <= 0 {
+			return ErrBudget
+		}
+		ls.limits.budget--
+	}
+	if len(ls.rt.fin.queue) != 0 {
+		return ls.finalize()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package lua_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestInterrupt(t *testing.T) {
+	ls := newThread(t, nil)
+	time.AfterFunc(10*time.Millisecond, ls.Interrupt)
+	if _, err := run(t, ls, "while true do end"); !errors.Is(err, lua.ErrInterrupt) {
+		t.Fatalf("got error %v, want ErrInterrupt", err)
+	}
+	if _, err := run(t, ls, "return 1"); err != nil {
+		t.Errorf("call after an interrupt: %v", err)
+	}
+}
+
+func TestInterruptPending(t *testing.T) {
+	ls := newThread(t, nil)
+	ls.Interrupt() // not running: interrupts the next instruction
+	if _, err := run(t, ls, "pcall(function() while true do end end)"); !errors.Is(err, lua.ErrInterrupt) {
+		t.Fatalf("got error %v, want ErrInterrupt", err)
+	}
+	if _, err := run(t, ls, "return 1"); err != nil {
+		t.Errorf("interrupt still pending after stopping a call: %v", err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	ls := newThread(t, &lua.Config{Budget: 1000})
+	if _, err := run(t, ls, "pcall(function() while true do end end)"); !errors.Is(err, lua.ErrBudget) {
+		t.Fatalf("got error %v, want ErrBudget (not caught by pcall)", err)
+	}
+	// each call from Go gets the whole budget
+	for i := 0; i < 3; i++ {
+		if _, err := run(t, ls, "local n = 0 for i = 1, 100 do n = n + i end return n"); err != nil {
+			t.Fatalf("call %d after exhausting the budget: %v", i, err)
+		}
+	}
+	if n, limited := ls.Budget(); !limited || n <= 0 || n >= 1000 {
+		t.Errorf("Budget() = %d, %t; want the instructions left of 1000", n, limited)
+	}
+
+	ls.SetBudget(0)
+	if _, err := run(t, ls, "for i = 1, 1e5 do end"); err != nil {
+		t.Errorf("call without a budget: %v", err)
+	}
+	if _, limited := ls.Budget(); limited {
+		t.Errorf("budget still enforced after SetBudget(0)")
+	}
+}
@@ -0,0 +1,74 @@
+package lua_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+)
+
+func TestLoadBinary(t *testing.T) {
+	chunk, err := luac.Compile(luac.Defaults, "=test", `local s = "" return s .. 40 + 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := newThread(t, nil)
+	for _, strip := range []bool{false, true} {
+		var bin bytes.Buffer
+		if _, err := chunk.Dump(&bin, strip); err != nil {
+			t.Fatal(err)
+		}
+		fn, err := lua.Load(ls, "=bin", bin.Bytes(), "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rets, err := ls.Call(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rets[0] != lua.String("42") {
+			t.Errorf("strip %t: got %v, want 42", strip, rets[0])
+		}
+		if _, err := lua.Load(ls, "=bin", bin.Bytes(), "t"); err == nil || !strings.Contains(err.Error(), "attempt to load a binary chunk") {
+			t.Errorf("loading a binary chunk in mode t: got %v", err)
+		}
+	}
+	if _, err := lua.Load(ls, "=text", []byte("return 1"), "b"); err == nil || !strings.Contains(err.Error(), "attempt to load a text chunk") {
+		t.Errorf("loading a text chunk in mode b: got %v", err)
+	}
+}
+
+func TestLoadSizeHints(t *testing.T) {
+	chunk, err := luac.Compile(luac.Defaults, "=test", `local t = {} t[1], t.x = 1, 2 return #t + t.x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, inst := range chunk.Main.Instrs {
+		if inst.Code() == code.NEWTABLE { // forge the largest size hints
+			chunk.Main.Instrs[i] = code.MakeABC(code.NEWTABLE, inst.A(), 0x1FF, 0x1FF)
+		}
+	}
+	var bin bytes.Buffer
+	if _, err := chunk.Dump(&bin, true); err != nil {
+		t.Fatal(err)
+	}
+	ls := newThread(t, nil)
+	fn, err := lua.Load(ls, "=bin", bin.Bytes(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := ls.Memory()
+	rets, err := ls.Call(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rets[0] != lua.Int(3) {
+		t.Errorf("got %v, want 3", rets[0])
+	}
+	if n := ls.Memory() - before; n > 16<<20 {
+		t.Errorf("table with forged size hints uses %d bytes", n)
+	}
+}
@@ -1,6 +1,12 @@
 package lua
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Azure/golua/lua/code"
 	"github.com/Azure/golua/lua/luac"
 )
 
@@ -86,14 +92,58 @@ var opnames = [...]string{
 
 func (op Op) String() string { return opnames[op] }
 
+// LoadFile loads the Lua file as a function, like Load; a first line
+// starting with '#' (e.g. "#!/usr/bin/env glua") is skipped.
 func LoadFile(t *Thread, file string) (*Func, error) {
-	chunk, err := luac.Compile(luac.Defaults, file, nil)
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", file, err)
+	}
+	if len(src) > 0 && src[0] == '#' {
+		// (the scanner skips the comment of text chunks itself, keeping
+		// their line numbers)
+		if i := bytes.IndexByte(src, '\n'); i >= 0 && IsBinary(src[i+1:]) {
+			src = src[i+1:]
+		}
+	}
+	return Load(t, "@"+file, src, "bt")
+}
+
+// Load loads the chunk src named name as a function: binary chunks, as
+// produced by Chunk.Dump or luac, are detected by their signature and
+// undumped; text chunks are compiled.
+//
+// The string mode controls whether the chunk can be text or binary (that
+// is, a precompiled chunk): it may be "b" (only binary chunks), "t" (only
+// text chunks) or "bt" (both).
+func Load(t *Thread, name string, src []byte, mode string) (*Func, error) {
+	var (
+		chunk *code.Chunk
+		err   error
+	)
+	if IsBinary(src) {
+		if !strings.Contains(mode, "b") {
+			return nil, fmt.Errorf("attempt to load a binary chunk (mode is '%s')", mode)
+		}
+		chunk, err = code.Undump(name, src)
+	} else {
+		if !strings.Contains(mode, "t") {
+			return nil, fmt.Errorf("attempt to load a text chunk (mode is '%s')", mode)
+		}
+		chunk, err = luac.Compile(luac.Defaults, name, src)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return t.Load(chunk), nil
 }
 
+// IsBinary reports whether src starts with the signature of precompiled
+// chunks ("\x1bLua").
+func IsBinary(src []byte) bool {
+	return bytes.HasPrefix(src, []byte(code.LUA_SIGNATURE))
+}
+
 func Must(ls *Thread, err error) *Thread {
 	if err != nil {
 		panic(err)
@@ -104,5 +154,10 @@ func Must(ls *Thread, err error) *Thread {
 func Init(config *Config) (*Thread, error) {
 	ls := new(runtime).init(config)
 	ls.tt = &Thread{ls}
-	return ls.tt, config.Stdlib(ls.tt)
+	ls.rt.values.Set(mainthread, ls.tt)
+	if err := config.Stdlib(ls.tt); err != nil {
+		return ls.tt, err
+	}
+	ls.rt.collect() // account for the standard libraries
+	return ls.tt, nil
 }
@@ -22,10 +22,21 @@ var _ = os.Exit
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-setmetatable
 func base۰setmetatable(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
-	// args.Arg(0).Type(ls).SetMeta(args.Table(1))
-	// return return args[:1], nil
-	fmt.Printf("setmetatable(args=%v)\n", args)
-	return nil, nil
+	tbl, err := args.Table(0)
+	if err != nil {
+		return nil, lua.TypeErr(0, lua.TypeName(args.Arg(0)), "table")
+	}
+	var meta *lua.Table
+	if !lua.IsNil(args.Arg(1)) {
+		if meta, err = args.Table(1); err != nil {
+			return nil, lua.TypeErr(1, lua.TypeName(args.Arg(1)), "nil or table")
+		}
+	}
+	if prev := tbl.Meta(); prev != nil && prev.Get(lua.String("__metatable")) != nil {
+		return nil, fmt.Errorf("cannot change a protected metatable")
+	}
+	ls.TypeOf(tbl).SetMeta(meta)
+	return []lua.Value{tbl}, nil
 }
 
 // getmetatable(object)
@@ -36,10 +47,17 @@ func base۰setmetatable(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-getmetatable
 func base۰getmetatable(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
-	// fmt.Println(ls.TypeOf(args[0]))
-	fmt.Printf("getmetatable(args=%v)\n", args)
-	fmt.Println(ls.Caller(1).Where())
-	return nil, nil
+	if len(args) < 1 {
+		return nil, lua.ArgErr(0, fmt.Errorf("value expected"))
+	}
+	meta := ls.TypeOf(args[0]).Meta()
+	if meta == nil {
+		return []lua.Value{nil}, nil
+	}
+	if protected := meta.Get(lua.String("__metatable")); protected != nil {
+		return []lua.Value{protected}, nil
+	}
+	return []lua.Value{meta}, nil
 }
 
 // tostring(v)
@@ -118,15 +136,147 @@ func base۰require(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-error
 func base۰error(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
-	// if level := lua.OptInt(args, 2, 1); lua.IsString(args[0])
-	// if level := args.OptInt(1, lua.Int(1)); args.IsString(0) && level > 0 {
-	// 	ls.Error("%s%s", ls.Where(int(level)), args.String(0))
-	// 	return nil
-	// }
-	// panic("error!")
-	// return nil
-	fmt.Printf("error(%v)\n", args)
-	return nil, nil
+	msg := args.Arg(0)
+	if level := args.IntOpt(1, 1); lua.IsString(msg) && level > 0 {
+		if dbg := ls.Info(int(level), "Sl"); dbg != nil {
+			s, _ := lua.ToString(msg)
+			msg = lua.String(dbg.Where() + string(s))
+		}
+	}
+	return nil, lua.ValueErr(msg)
+}
+
+// pcall(f [, arg1, ···])
+//
+// Calls function f with the given arguments in protected mode. This means that
+// any error inside f is not propagated; instead, pcall catches the error and
+// returns a status code. Its first result is the status code (a boolean), which
+// is true if the call succeeds without errors. In such case, pcall also returns
+// all results from the call, after this first result. In case of any error, pcall
+// returns false plus the error message.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-pcall
+func base۰pcall(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	if len(args) < 1 {
+		return nil, lua.ArgErr(0, fmt.Errorf("value expected"))
+	}
+	rets, err := ls.Call(args[0], args[1:]...)
+	if err != nil {
+		if !lua.Catchable(err) {
+			return nil, err
+		}
+		return []lua.Value{lua.False, lua.ErrorValue(err)}, nil
+	}
+	return append([]lua.Value{lua.True}, rets...), nil
+}
+
+// load(chunk [, chunkname [, mode [, env]]])
+//
+// Loads a chunk.
+//
+// If chunk is a string, the chunk is this string. If chunk is a function, load
+// calls it repeatedly to get the chunk pieces. Each call to chunk must return a
+// string that concatenates with previous results. A return of an empty string,
+// nil, or no value signals the end of the chunk.
+//
+// If there are no syntactic errors, returns the compiled chunk as a function;
+// otherwise, returns nil plus the error message.
+//
+// If the resulting function has upvalues, the first upvalue is set to the value
+// of env, if that parameter is given, or to the value of the global environment.
+//
+// chunkname is used as the name of the chunk for error messages and debug
+// information. When absent, it defaults to chunk, if chunk is a string, or to
+// "=(load)" otherwise.
+//
+// The string mode controls whether the chunk can be text or binary (that is, a
+// precompiled chunk). It may be the string "b" (only binary chunks), "t" (only
+// text chunks), or "bt" (both binary and text). The default is "bt".
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-load
+func base۰load(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	var (
+		name = "=(load)"
+		src  []byte
+	)
+	switch chunk := args.Arg(0).(type) {
+	case lua.String:
+		name, src = string(chunk), []byte(chunk)
+	case lua.Callable:
+		for {
+			rets, err := ls.CallN(args[0], nil, 1)
+			if err != nil {
+				return []lua.Value{nil, lua.ErrorValue(err)}, nil
+			}
+			if lua.IsNil(rets[0]) {
+				break
+			}
+			piece, ok := rets[0].(lua.String)
+			if !ok {
+				return []lua.Value{nil, lua.String("reader function must return a string")}, nil
+			}
+			if piece == "" {
+				break
+			}
+			src = append(src, piece...)
+		}
+	default:
+		return nil, lua.TypeErr(0, lua.TypeName(chunk), "string")
+	}
+	var (
+		chunkname = args.StringOpt(1, lua.String(name))
+		mode      = args.StringOpt(2, "bt")
+	)
+	fn, err := lua.Load(ls, string(chunkname), src, string(mode))
+	if err != nil {
+		return []lua.Value{nil, lua.String(err.Error())}, nil
+	}
+	if len(args) > 3 {
+		lua.SetUpValue(fn, 1, args[3])
+	}
+	return []lua.Value{fn}, nil
+}
+
+// collectgarbage([opt [, arg]])
+//
+// This function is a generic interface to the garbage collector.
+// It performs different functions according to its first argument, opt:
+//
+//	"collect": performs a full garbage-collection cycle. This is the default option.
+//	"stop": stops automatic execution of the garbage collector.
+//	"restart": restarts automatic execution of the garbage collector.
+//	"count": returns the total memory in use by Lua in Kbytes.
+//	"step": performs a garbage-collection step.
+//	"setpause": sets arg as the new value for the pause of the collector.
+//	"setstepmul": sets arg as the new value for the step multiplier of the collector.
+//	"isrunning": returns a boolean that tells whether the collector is running.
+//
+// The memory in use is an estimate maintained by the runtime (see lua.Config.MemoryLimit).
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-collectgarbage
+func base۰collectgarbage(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	switch opt := args.StringOpt(0, "collect"); opt {
+	case "collect":
+		ls.Collect()
+		if err := ls.RunFinalizers(); err != nil {
+			return nil, err
+		}
+		return []lua.Value{lua.Int(0)}, nil
+	case "step":
+		ls.Collect()
+		if err := ls.RunFinalizers(); err != nil {
+			return nil, err
+		}
+		return []lua.Value{lua.True}, nil
+	case "count":
+		return []lua.Value{lua.Float(ls.Memory()) / 1024}, nil
+	case "isrunning":
+		return []lua.Value{lua.True}, nil
+	case "stop", "restart", "setpause", "setstepmul":
+		return []lua.Value{lua.Int(0)}, nil
+	default:
+		return nil, lua.ArgErr(0, fmt.Errorf("invalid option '%s'", opt))
+	}
 }
 
 // print(...)
@@ -277,7 +427,10 @@ func stdlib۰base(ls *lua.Thread) (lua.Value, error) {
 	ls.SetGlobal("setmetatable", lua.NewGoFunc("setmetatable", base۰setmetatable))
 	ls.SetGlobal("getmetatable", lua.NewGoFunc("getmetatable", base۰getmetatable))
 	ls.SetGlobal("tostring", lua.NewGoFunc("tostring", base۰tostring))
+	ls.SetGlobal("collectgarbage", lua.NewGoFunc("collectgarbage", base۰collectgarbage))
 	ls.SetGlobal("error", lua.NewGoFunc("error", base۰error))
+	ls.SetGlobal("pcall", lua.NewGoFunc("pcall", base۰pcall))
+	ls.SetGlobal("load", lua.NewGoFunc("load", base۰load))
 	ls.SetGlobal("print", lua.NewGoFunc("print", base۰print))
 	ls.SetGlobal("ipairs", lua.NewGoFunc("ipairs", base۰ipairs))
 	ls.SetGlobal("pairs", lua.NewGoFunc("pairs", base۰pairs))
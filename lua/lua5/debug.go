@@ -1,51 +1,568 @@
 package lua5
 
-// import (
-//	"github.com/Azure/golua/lua"
-// )
-
-// func Debug(ls *lua.Thread) {
-// 	stdlib := lua.Library{
-// 		Open:  lua.Loader(stdlib۰debug),
-// 		Name:  "debug",
-// 		Funcs: []*lua.GoFunc{
-// 			lua.NewGoFunc("debug", debug.debug),
-// 			lua.NewGoFunc("gethook", debug.gethook),
-// 			lua.NewGoFunc("getinfo", debug.getinfo),
-// 			lua.NewGoFunc("getlocal", debug.getlocal),
-// 			lua.NewGoFunc("getmetatable", debug.getmetatable),
-// 			lua.NewGoFunc("getregistry", debug.getregistry),
-// 			lua.NewGoFunc("getupvalue", debug.getupvalue),
-// 			lua.NewGoFunc("getuservalue", debug.getuservalue),
-// 			lua.NewGoFunc("sethook", debug.sethook),
-// 			lua.NewGoFunc("setlocal", debug.setlocal),
-// 			lua.NewGoFunc("setmetatable", debug.setmetatable),
-// 			lua.NewGoFunc("setupvalue", debug.setupvalue),
-// 			lua.NewGoFunc("setuservalue", debug.setuservalue),
-// 			lua.NewGoFunc("traceback", debug.traceback),
-// 			lua.NewGoFunc("upvalueid", debug.upvalueid),
-// 			lua.NewGoFunc("upvaluejoin", debug.upvaluejoin),
-// 		},
-// 	}
-// 	ls.Require(stdlib, true)
-// }
-
-// func stdlib۰debug(ls *lua.Thread) (*lua.Table, error) {
-// 	// debug.debug
-// 	// debug.gethook
-// 	// debug.getinfo
-// 	// debug.getlocal
-// 	// debug.getmetatable
-// 	// debug.getregistry
-// 	// debug.getupvalue
-// 	// debug.getuservalue
-// 	// debug.sethook
-// 	// debug.setlocal
-// 	// debug.setmetatable
-// 	// debug.setupvalue
-// 	// debug.setuservalue
-// 	// debug.traceback
-// 	// debug.upvalueid
-// 	// debug.upvaluejoin
-// 	return lua.NewTable(), nil
-// }
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// debug.debug()
+//
+// Enters an interactive mode with the user, running each string that the
+// user enters. Using simple commands and other debug facilities, the user
+// can inspect global and local variables, change their values, evaluate
+// expressions, and so on. A line containing only the word cont finishes
+// this function, so that the caller continues its execution.
+//
+// Note that commands for debug.debug are not lexically nested within any
+// function and so have no direct access to local variables.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.debug
+func debug۰debug(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	input := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "lua_debug> ")
+		if !input.Scan() || input.Text() == "cont" {
+			return nil, nil
+		}
+		chunk, err := luac.Compile(luac.Defaults, "=(debug command)", input.Text())
+		if err == nil {
+			_, err = ls.Exec(chunk)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// debug.gethook([thread])
+//
+// Returns the current hook settings of the thread, as three values: the
+// current hook function, the current hook mask, and the current hook count
+// (as set by the debug.sethook function).
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+func debug۰gethook(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	ls, _ = threadArg(ls, args)
+	fn, mask, count := ls.Hook()
+	if fn == nil { // no hook?
+		return []lua.Value{nil}, nil
+	}
+	var hook lua.Value = lua.String("external hook")
+	if hooks, ok := ls.Context().Values().Get(lua.String(hookKey)).(*lua.Table); ok {
+		if fn := hooks.Get(ls); fn != nil {
+			hook = fn
+		}
+	}
+	return []lua.Value{hook, lua.String(unmakemask(mask)), lua.Int(count)}, nil
+}
+
+// debug.sethook([thread,] hook, mask [, count])
+//
+// Sets the given function as a hook. The string mask and the number count
+// describe when the hook will be called. The string mask may have any
+// combination of the following characters, with the given meaning:
+//
+//	'c': the hook is called every time Lua calls a function;
+//	'r': the hook is called every time Lua returns from a function;
+//	'l': the hook is called every time Lua enters a new line of code.
+//
+// Moreover, with a count different from zero, the hook is called also after
+// every count instructions.
+//
+// When called without arguments, debug.sethook turns off the hook.
+//
+// When the hook is called, its first argument is a string describing the event
+// that has triggered its call: "call" (or "tail call"), "return", "line", and
+// "count". For line events, the hook also gets the new line number as its second
+// parameter. Inside a hook, you can call getinfo with level 2 to get more information
+// about the running function (level 0 is the getinfo function, and level 1 is the
+// hook function).
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+func debug۰sethook(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	ls, arg := threadArg(ls, args)
+	var (
+		fn    = args.Arg(arg)
+		mask  lua.HookMask
+		count int
+	)
+	if fn != nil { // not turning off hooks?
+		if !lua.IsFunction(fn) {
+			return nil, lua.TypeErr(arg, lua.TypeName(fn), "function")
+		}
+		smask, err := args.String(arg + 1)
+		if err != nil {
+			return nil, err
+		}
+		mask = makemask(string(smask))
+		if count = int(args.IntOpt(arg+2, 0)); count > 0 {
+			mask |= lua.MaskCount
+		}
+	}
+	registry := ls.Context().Values()
+	hooks, ok := registry.Get(lua.String(hookKey)).(*lua.Table)
+	if !ok {
+		// create the hook table on first use
+		hooks = lua.NewTable()
+		registry.Set(lua.String(hookKey), hooks)
+	}
+	if hooks.Set(ls, fn); mask == 0 {
+		ls.SetHook(0, 0, nil)
+		return nil, nil
+	}
+	ls.SetHook(mask, count, hookf)
+	return nil, nil
+}
+
+// hookKey is the key, in the registry, for the table of Lua hook functions
+// (indexed by thread).
+const hookKey = "_HOOKKEY"
+
+// hookf is the Go hook that calls the thread's Lua hook function, passing
+// the event name and the new line number (for line events); errors in the
+// Lua hook are raised in the hooked function.
+func hookf(ls *lua.Thread, evt lua.HookEvent) error {
+	if hooks, ok := ls.Context().Values().Get(lua.String(hookKey)).(*lua.Table); ok {
+		if fn := hooks.Get(ls); fn != nil {
+			var line lua.Value
+			if evt == lua.HookLine {
+				line = lua.Int(ls.Info(0, "l").CurrentLine())
+			}
+			_, err := ls.Call(fn, lua.String(evt.String()), line)
+			return err
+		}
+	}
+	return nil
+}
+
+// makemask converts a string mask (for sethook) into a bit mask.
+func makemask(smask string) (mask lua.HookMask) {
+	if strings.ContainsRune(smask, 'c') {
+		mask |= lua.MaskCall
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= lua.MaskReturn
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= lua.MaskLine
+	}
+	return mask
+}
+
+// unmakemask converts a bit mask (for gethook) into a string mask.
+func unmakemask(mask lua.HookMask) (smask string) {
+	if mask&lua.MaskCall != 0 {
+		smask += "c"
+	}
+	if mask&lua.MaskReturn != 0 {
+		smask += "r"
+	}
+	if mask&lua.MaskLine != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// debug.getinfo([thread,] f [, what])
+//
+// Returns a table with information about a function. You can give the
+// function directly or you can give a number as the value of f, which
+// means the function running at level f of the call stack of the given
+// thread: level 0 is the current function (getinfo itself); level 1 is
+// the function that called getinfo (except for tail calls, which do not
+// count on the stack); and so on. If f is a number larger than the number
+// of active functions, then getinfo returns nil.
+//
+// The returned table can contain all the fields returned by lua_getinfo,
+// with the string what describing which fields to fill in. The default for
+// what is to get all information available, except the table of valid lines.
+// If present, the option 'f' adds a field named func with the function itself.
+// If present, the option 'L' adds a field named activelines with the table of
+// valid lines.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+func debug۰getinfo(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	ls, arg := threadArg(ls, args)
+	what := string(args.StringOpt(arg+1, "flnStu"))
+	if strings.Trim(what, "nSltufL") != "" {
+		return nil, lua.ArgErr(arg+1, fmt.Errorf("invalid option"))
+	}
+	var dbg lua.Debug
+	if fn := args.Arg(arg); lua.IsFunction(fn) {
+		dbg = ls.FuncInfo(fn, what)
+	} else {
+		level, err := args.Int(arg)
+		if err != nil {
+			return nil, err
+		}
+		if dbg = ls.Info(int(level), what); dbg == nil {
+			return []lua.Value{nil}, nil // level out of range
+		}
+	}
+	info := lua.NewTable()
+	if strings.ContainsRune(what, 'S') {
+		span0, span1 := dbg.Span()
+		info.Set(lua.String("source"), lua.String(dbg.SourceID()))
+		info.Set(lua.String("short_src"), lua.String(dbg.ShortSrc()))
+		info.Set(lua.String("linedefined"), lua.Int(span0))
+		info.Set(lua.String("lastlinedefined"), lua.Int(span1))
+		info.Set(lua.String("what"), lua.String(dbg.Kind()))
+	}
+	if strings.ContainsRune(what, 'l') {
+		info.Set(lua.String("currentline"), lua.Int(dbg.CurrentLine()))
+	}
+	if strings.ContainsRune(what, 'u') {
+		info.Set(lua.String("nups"), lua.Int(dbg.UpVarN()))
+		info.Set(lua.String("nparams"), lua.Int(dbg.ParamN()))
+		info.Set(lua.String("isvararg"), lua.Bool(dbg.Vararg()))
+	}
+	if strings.ContainsRune(what, 'n') {
+		if name := dbg.Name(); name != "" {
+			info.Set(lua.String("name"), lua.String(name))
+		}
+		info.Set(lua.String("namewhat"), lua.String(dbg.NameWhat()))
+	}
+	if strings.ContainsRune(what, 't') {
+		info.Set(lua.String("istailcall"), lua.Bool(dbg.Tailcall()))
+	}
+	if strings.ContainsRune(what, 'L') {
+		if lines := dbg.ActiveLines(); lines != nil {
+			active := lua.NewTableSize(0, len(lines))
+			for _, line := range lines {
+				active.Set(lua.Int(line), lua.True)
+			}
+			info.Set(lua.String("activelines"), active)
+		}
+	}
+	if strings.ContainsRune(what, 'f') {
+		info.Set(lua.String("func"), dbg.Func())
+	}
+	return []lua.Value{info}, nil
+}
+
+// debug.getlocal([thread,] f, local)
+//
+// This function returns the name and the value of the local variable with
+// index local of the function at level f of the stack. This function accesses
+// not only explicit local variables, but also parameters, temporaries, etc.
+//
+// The first parameter or local variable has index 1, and so on, following the
+// order that they are declared in the code, counting only the variables that are
+// active in the current scope of the function. Negative indices refer to vararg
+// arguments; -1 is the first vararg argument. The function returns nil if there
+// is no variable with the given index, and raises an error when called with a
+// level out of range. (You can call debug.getinfo to check whether the level is
+// valid.)
+//
+// Variable names starting with '(' (open parenthesis) represent variables with no
+// known names (internal variables such as loop control variables, and variables
+// from chunks saved without debug information).
+//
+// The parameter f may also be a function. In that case, getlocal returns only the
+// name of function parameters.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+func debug۰getlocal(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	ls, arg := threadArg(ls, args)
+	n, err := args.Int(arg + 1)
+	if err != nil {
+		return nil, err
+	}
+	if fn := args.Arg(arg); lua.IsFunction(fn) { // information about non-active function?
+		if name := ls.LocalName(fn, int(n)); name != "" {
+			return []lua.Value{lua.String(name)}, nil
+		}
+		return []lua.Value{nil}, nil
+	}
+	level, err := args.Int(arg)
+	if err != nil {
+		return nil, err
+	}
+	if ls.Info(int(level), "") == nil {
+		return nil, lua.ArgErr(arg, fmt.Errorf("level out of range"))
+	}
+	if name, value := ls.Local(int(level), int(n)); name != "" {
+		return []lua.Value{lua.String(name), value}, nil
+	}
+	return []lua.Value{nil}, nil
+}
+
+// debug.setlocal([thread,] level, local, value)
+//
+// This function assigns the value value to the local variable with index
+// local of the function at level level of the stack. The function returns
+// nil if there is no local variable with the given index, and raises an
+// error when called with a level out of range. (You can call getinfo to
+// check whether the level is valid.) Otherwise, it returns the name of the
+// local variable.
+//
+// See debug.getlocal for more information about variable indices and names.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+func debug۰setlocal(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	ls, arg := threadArg(ls, args)
+	level, err := args.Int(arg)
+	if err != nil {
+		return nil, err
+	}
+	n, err := args.Int(arg + 1)
+	if err != nil {
+		return nil, err
+	}
+	if ls.Info(int(level), "") == nil {
+		return nil, lua.ArgErr(arg, fmt.Errorf("level out of range"))
+	}
+	if name := ls.SetLocal(int(level), int(n), args.Arg(arg+2)); name != "" {
+		return []lua.Value{lua.String(name)}, nil
+	}
+	return []lua.Value{nil}, nil
+}
+
+// debug.getupvalue(f, up)
+//
+// This function returns the name and the value of the upvalue with index up
+// of the function f. The function returns nil if there is no upvalue with the
+// given index.
+//
+// Variable names starting with '(' (open parenthesis) represent variables with
+// no known names (variables from chunks saved without debug information).
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+func debug۰getupvalue(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	fn, n, err := funcArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if name, value, ok := lua.UpValue(fn, n); ok {
+		return []lua.Value{lua.String(name), value}, nil
+	}
+	return nil, nil
+}
+
+// debug.setupvalue(f, up, value)
+//
+// This function assigns the value value to the upvalue with index up of
+// the function f. The function returns nil if there is no upvalue with
+// the given index. Otherwise, it returns the name of the upvalue.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+func debug۰setupvalue(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	fn, n, err := funcArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		return nil, lua.ArgErr(2, fmt.Errorf("value expected"))
+	}
+	if name, ok := lua.SetUpValue(fn, n, args[2]); ok {
+		return []lua.Value{lua.String(name)}, nil
+	}
+	return nil, nil
+}
+
+// debug.upvalueid(f, n)
+//
+// Returns a unique identifier (as a light userdata) for the upvalue numbered
+// n from the given function.
+//
+// These unique identifiers allow a program to check whether different closures
+// share upvalues. Lua closures that share an upvalue (that is, that access a
+// same external local variable) will return identical ids for those upvalue
+// indices.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+func debug۰upvalueid(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	fn, n, err := funcArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := lua.UpValueID(fn, n)
+	if !ok {
+		return nil, lua.ArgErr(1, fmt.Errorf("invalid upvalue index"))
+	}
+	return []lua.Value{id}, nil
+}
+
+// debug.upvaluejoin(f1, n1, f2, n2)
+//
+// Make the n1-th upvalue of the Lua closure f1 refer to the n2-th upvalue
+// of the Lua closure f2.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+func debug۰upvaluejoin(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	f1, n1, err := funcArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	f2, n2, err := funcArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return nil, lua.UpValueJoin(f1, n1, f2, n2)
+}
+
+// debug.getmetatable(value)
+//
+// Returns the metatable of the given value or nil if it does not have a metatable.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+func debug۰getmetatable(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	if len(args) < 1 {
+		return nil, lua.ArgErr(0, fmt.Errorf("value expected"))
+	}
+	if meta := ls.TypeOf(args[0]).Meta(); meta != nil {
+		return []lua.Value{meta}, nil
+	}
+	return []lua.Value{nil}, nil
+}
+
+// debug.setmetatable(value, table)
+//
+// Sets the metatable for the given value to the given table (which can be nil).
+// Returns value.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+func debug۰setmetatable(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	var meta *lua.Table
+	if !lua.IsNil(args.Arg(1)) {
+		tbl, err := args.Table(1)
+		if err != nil {
+			return nil, lua.TypeErr(1, lua.TypeName(args.Arg(1)), "nil or table")
+		}
+		meta = tbl
+	}
+	ls.TypeOf(args.Arg(0)).SetMeta(meta)
+	return []lua.Value{args.Arg(0)}, nil
+}
+
+// debug.getregistry()
+//
+// Returns the registry table.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+func debug۰getregistry(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	return []lua.Value{ls.Context().Values()}, nil
+}
+
+// debug.getuservalue(u)
+//
+// Returns the Lua value associated to u. If u is not a full userdata, returns nil.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+func debug۰getuservalue(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	if u := lua.ToGoValue(args.Arg(0)); u != nil {
+		return []lua.Value{u.UserValue()}, nil
+	}
+	return []lua.Value{nil}, nil
+}
+
+// debug.setuservalue(udata, value)
+//
+// Sets the given value as the Lua value associated to the given udata.
+// udata must be a full userdata.
+//
+// Returns udata.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+func debug۰setuservalue(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	u := lua.ToGoValue(args.Arg(0))
+	if u == nil {
+		return nil, lua.TypeErr(0, lua.TypeName(args.Arg(0)), "userdata")
+	}
+	if len(args) < 2 {
+		return nil, lua.ArgErr(1, fmt.Errorf("value expected"))
+	}
+	u.SetUserValue(args[1])
+	return []lua.Value{u}, nil
+}
+
+// debug.traceback([thread,] [message [, level]])
+//
+// If message is present but is neither a string nor nil, this function
+// returns message without further processing. Otherwise, it returns a
+// string with a traceback of the call stack. The optional message string
+// is appended at the beginning of the traceback. An optional level number
+// tells at which level to start the traceback (default is 1, the function
+// calling traceback).
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+func debug۰traceback(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	tt, arg := threadArg(ls, args)
+	msg := args.Arg(arg)
+	if msg != nil && !lua.IsString(msg) && !lua.IsNumber(msg) {
+		return []lua.Value{msg}, nil // return it untouched
+	}
+	var level lua.Int = 1
+	if tt != ls {
+		level = 0
+	}
+	level = args.IntOpt(arg+1, level)
+	var str lua.String
+	if msg != nil {
+		str, _ = lua.ToString(msg)
+	}
+	return []lua.Value{lua.String(tt.Traceback(string(str), int(level)))}, nil
+}
+
+// threadArg returns the thread the debug function operates over and the
+// index of its first argument after the optional thread.
+func threadArg(ls *lua.Thread, args lua.Tuple) (*lua.Thread, int) {
+	if tt := lua.ToThread(args.Arg(0)); tt != nil {
+		return tt, 1
+	}
+	return ls, 0
+}
+
+// funcArgs checks for a function and an upvalue index at argument arg.
+func funcArgs(args lua.Tuple, arg int) (lua.Value, int, error) {
+	if !lua.IsFunction(args.Arg(arg)) {
+		return nil, 0, lua.TypeErr(arg, lua.TypeName(args.Arg(arg)), "function")
+	}
+	n, err := args.Int(arg + 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return args.Arg(arg), int(n), nil
+}
+
+func stdlib۰debug(ls *lua.Thread) (lua.Value, error) {
+	// debug.debug
+	// debug.gethook
+	// debug.getinfo
+	// debug.getlocal
+	// debug.getmetatable
+	// debug.getregistry
+	// debug.getupvalue
+	// debug.getuservalue
+	// debug.sethook
+	// debug.setlocal
+	// debug.setmetatable
+	// debug.setupvalue
+	// debug.setuservalue
+	// debug.traceback
+	// debug.upvalueid
+	// debug.upvaluejoin
+	return lua.NewTableFromMap(map[string]lua.Value{
+		"debug":        lua.NewGoFunc("debug", debug۰debug),
+		"gethook":      lua.NewGoFunc("gethook", debug۰gethook),
+		"getinfo":      lua.NewGoFunc("getinfo", debug۰getinfo),
+		"getlocal":     lua.NewGoFunc("getlocal", debug۰getlocal),
+		"getmetatable": lua.NewGoFunc("getmetatable", debug۰getmetatable),
+		"getregistry":  lua.NewGoFunc("getregistry", debug۰getregistry),
+		"getupvalue":   lua.NewGoFunc("getupvalue", debug۰getupvalue),
+		"getuservalue": lua.NewGoFunc("getuservalue", debug۰getuservalue),
+		"sethook":      lua.NewGoFunc("sethook", debug۰sethook),
+		"setlocal":     lua.NewGoFunc("setlocal", debug۰setlocal),
+		"setmetatable": lua.NewGoFunc("setmetatable", debug۰setmetatable),
+		"setupvalue":   lua.NewGoFunc("setupvalue", debug۰setupvalue),
+		"setuservalue": lua.NewGoFunc("setuservalue", debug۰setuservalue),
+		"traceback":    lua.NewGoFunc("traceback", debug۰traceback),
+		"upvalueid":    lua.NewGoFunc("upvalueid", debug۰upvalueid),
+		"upvaluejoin":  lua.NewGoFunc("upvaluejoin", debug۰upvaluejoin),
+	}), nil
+}
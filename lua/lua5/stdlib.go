@@ -95,12 +95,27 @@ func BaseLib(ls *lua.Thread) error {
 	return err
 }
 
-// func TableLib(ls *lua.Thread) {}
+func DebugLib(ls *lua.Thread) error {
+	lib := lua.Library{Name: "debug", Open: stdlib۰debug}
+	_, err := ls.Require(lib, true)
+	return err
+}
+
+func TableLib(ls *lua.Thread) error {
+	lib := lua.Library{Name: "table", Open: stdlib۰table}
+	_, err := ls.Require(lib, true)
+	return err
+}
+
+func StringLib(ls *lua.Thread) error {
+	lib := lua.Library{Name: "string", Open: stdlib۰string}
+	_, err := ls.Require(lib, true)
+	return err
+}
+
 // func IOLib(ls *lua.Thread) {}
 // func OSLib(ls *lua.Thread) {}
-// func StringLib(ls *lua.Thread) {}
 // func UTF8Lib(ls *lua.Thread) {}
-// func DebugLib(ls *lua.Thread) {}
 
 // Stdlib requires in the Lua standard libraries.
 func Stdlib(ls *lua.Thread) error {
@@ -130,12 +145,18 @@ func Stdlib(ls *lua.Thread) error {
 	if err := CoroutineLib(ls); err != nil {
 		return err
 	}
-	// table
-	// io
-	// os
-	// string
+	if err := TableLib(ls); err != nil {
+		return err
+	}
+	// TODO: io, os and utf8
+	if err := StringLib(ls); err != nil {
+		return err
+	}
 	if err := MathLib(ls); err != nil {
 		return err
 	}
+	if err := DebugLib(ls); err != nil {
+		return err
+	}
 	return nil
 }
@@ -1,26 +1,81 @@
 package lua5
 
-// import (
-//	"github.com/Azure/golua/lua"
-// )
+import (
+	"fmt"
+	"math"
+	"strings"
 
-// func stdlib۰string(ls *lua.Thread) (*lua.Table, error) {
-// 	// string.byte
-// 	// string.char
-// 	// string.dump
-// 	// string.find
-// 	// string.format
-// 	// string.gmatch
-// 	// string.gsub
-// 	// string.len
-// 	// string.lower
-// 	// string.match
-// 	// string.pack
-// 	// string.packsize
-// 	// string.rep
-// 	// string.reverse
-// 	// string.sub
-// 	// string.unpack
-// 	// string.upper
-// 	return lua.NewTable(), nil
-// }
+	"github.com/Azure/golua/lua"
+)
+
+// string.rep(s, n [, sep])
+//
+// Returns a string that is the concatenation of n copies of the string s
+// separated by the string sep. The default value for sep is the empty
+// string (that is, no separator). Returns the empty string if n is not
+// positive.
+//
+// (Note that it is very easy to exhaust the memory of your machine with
+// a single call to this function.)
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-string.rep
+func string۰rep(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	s, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := args.Int(1)
+	if err != nil {
+		return nil, err
+	}
+	sep := args.StringOpt(2, "")
+	if n <= 0 {
+		return []lua.Value{lua.String("")}, nil
+	}
+	if size := lua.Int(len(s) + len(sep)); size > 0 && n > math.MaxInt32/size {
+		return nil, fmt.Errorf("resulting string too large")
+	}
+	size := int(n)*len(s) + int(n-1)*len(sep)
+	if err := ls.Alloc(size); err != nil {
+		return nil, err
+	}
+	if sep == "" {
+		return []lua.Value{lua.String(strings.Repeat(string(s), int(n)))}, nil
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for i := lua.Int(0); i < n; i++ {
+		if i > 0 {
+			b.WriteString(string(sep))
+		}
+		b.WriteString(string(s))
+	}
+	return []lua.Value{lua.String(b.String())}, nil
+}
+
+func stdlib۰string(ls *lua.Thread) (lua.Value, error) {
+	// string.byte
+	// string.char
+	// string.dump
+	// string.find
+	// string.format
+	// string.gmatch
+	// string.gsub
+	// string.len
+	// string.lower
+	// string.match
+	// string.pack
+	// string.packsize
+	// string.rep
+	// string.reverse
+	// string.sub
+	// string.unpack
+	// string.upper
+	lib := lua.NewTableFromMap(map[string]lua.Value{
+		"rep": lua.NewGoFunc("rep", string۰rep),
+	})
+	// set the string metatable so that strings have the library's methods
+	meta := lua.NewTableFromMap(map[string]lua.Value{"__index": lib})
+	ls.TypeOf(lua.String("")).SetMeta(meta)
+	return lib, nil
+}
@@ -1,16 +1,62 @@
 package lua5
 
-// import (
-//	"github.com/Azure/golua/lua"
-// )
+import (
+	"fmt"
+	"strings"
 
-// func stdlib۰table(ls *lua.Thread) (*lua.Table, error) {
-// 	// table.concat
-// 	// table.insert
-// 	// table.move
-// 	// table.pack
-// 	// table.remove
-// 	// table.sort
-// 	// table.unpack
-// 	return lua.NewTable(), nil
-// }
+	"github.com/Azure/golua/lua"
+)
+
+// table.concat(list [, sep [, i [, j]]])
+//
+// Given a list where all elements are strings or numbers, returns the string
+// list[i]..sep..list[i+1] ··· sep..list[j]. The default value for sep is the
+// empty string, the default for i is 1, and the default for j is #list. If i
+// is greater than j, returns the empty string.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-table.concat
+func table۰concat(ls *lua.Thread, args lua.Tuple) ([]lua.Value, error) {
+	list, err := args.Table(0)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		sep  = args.StringOpt(1, "")
+		i    = args.IntOpt(2, 1)
+		j    = args.IntOpt(3, list.Length())
+		strs []string
+		size int
+	)
+	for k := i; k <= j; k++ {
+		v, err := ls.Index(list, k)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := lua.ToString(v)
+		if !ok || v == nil {
+			return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		if k > i {
+			size += len(sep)
+		}
+		size += len(s)
+		strs = append(strs, string(s))
+	}
+	if err := ls.Alloc(size); err != nil {
+		return nil, err
+	}
+	return []lua.Value{lua.String(strings.Join(strs, string(sep)))}, nil
+}
+
+func stdlib۰table(ls *lua.Thread) (lua.Value, error) {
+	// table.concat
+	// table.insert
+	// table.move
+	// table.pack
+	// table.remove
+	// table.sort
+	// table.unpack
+	return lua.NewTableFromMap(map[string]lua.Value{
+		"concat": lua.NewGoFunc("concat", table۰concat),
+	}), nil
+}
@@ -0,0 +1,45 @@
+package lua_test
+
+import (
+	"testing"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/lua/lua5"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// newThread returns the main thread of a new runtime with the standard
+// libraries, configured by config (if not nil).
+func newThread(t testing.TB, config *lua.Config) *lua.Thread {
+	t.Helper()
+	if config == nil {
+		config = new(lua.Config)
+	}
+	config.Stdlib, config.NoEnv = lua5.Stdlib, true
+	ls, err := lua.Init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls
+}
+
+// run compiles and executes the chunk src on thread ls.
+func run(t testing.TB, ls *lua.Thread, src string) ([]lua.Value, error) {
+	t.Helper()
+	chunk, err := luac.Compile(luac.Defaults, "=test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls.Exec(chunk)
+}
+
+// check runs the chunk src on a new thread, and fails if it raises an
+// error.
+func check(t testing.TB, src string) []lua.Value {
+	t.Helper()
+	rets, err := run(t, newThread(t, nil), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rets
+}
@@ -0,0 +1,530 @@
+// Package ast declares the types used to represent the syntax trees of Lua
+// 5.3 chunks, as returned by luac.ParseFile and luac.ParseString.
+//
+// Unlike the compiler, which generates code as it parses, syntax trees keep
+// the structure of the source: the position of every node and the comments
+// of the chunk. They are meant for tools such as formatters and linters.
+package ast
+
+import (
+	"fmt"
+
+	"github.com/Azure/golua/lua/code"
+)
+
+// Pos is a position in the source: its line and column, both from 1; the
+// column counts bytes. The zero Pos is not a valid position.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position is valid.
+func (pos Pos) IsValid() bool { return pos.Line > 0 }
+
+// Before reports whether pos is before other.
+func (pos Pos) Before(other Pos) bool {
+	return pos.Line < other.Line || (pos.Line == other.Line && pos.Column < other.Column)
+}
+
+// Add returns the position n bytes after pos, on the same line.
+func (pos Pos) Add(n int) Pos { return Pos{pos.Line, pos.Column + n} }
+
+func (pos Pos) String() string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// Node is a node of a syntax tree.
+type Node interface {
+	Pos() Pos // position of the first character of the node
+	End() Pos // position just after the last character of the node
+}
+
+// Expr is an expression node.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is a statement node.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+//
+// Comments
+//
+
+// Comment is a comment: "--" to the end of the line, or a long comment
+// "--[[ ... ]]" (with any level of '=').
+type Comment struct {
+	Start  Pos
+	Text   string // text of the comment, including "--"
+	EndPos Pos
+}
+
+func (c *Comment) Pos() Pos { return c.Start }
+func (c *Comment) End() Pos { return c.EndPos }
+
+// CommentGroup is a sequence of comments with no other tokens and no empty
+// lines between them.
+type CommentGroup struct {
+	List []*Comment
+}
+
+func (g *CommentGroup) Pos() Pos { return g.List[0].Pos() }
+func (g *CommentGroup) End() Pos { return g.List[len(g.List)-1].End() }
+
+//
+// Chunks and blocks
+//
+
+// Chunk is a parsed chunk.
+type Chunk struct {
+	Name     string          // chunk name (e.g. "@file.lua")
+	Block    *Block          // statements of the chunk
+	Comments []*CommentGroup // all the comments of the chunk, in order
+	EOF      Pos             // position of the end of the chunk
+}
+
+func (c *Chunk) Pos() Pos { return Pos{1, 1} }
+func (c *Chunk) End() Pos { return c.EOF }
+
+// Block is a sequence of statements; a "return" statement can only be
+// the last one. A block spans from the token which opens it (e.g. "do")
+// to the token which closes it (e.g. "end"), so that it contains the
+// comments after its last statement.
+type Block struct {
+	Start  Pos // position just after the opening token (1:1 for a chunk)
+	Stmts  []Stmt
+	EndPos Pos // position of the closing token (the end of a chunk)
+}
+
+func (b *Block) Pos() Pos { return b.Start }
+func (b *Block) End() Pos { return b.EndPos }
+
+//
+// Expressions
+//
+
+type (
+	// Ident is a name: a variable, field or label name.
+	Ident struct {
+		NamePos Pos
+		Name    string
+	}
+
+	// NilLit is "nil".
+	NilLit struct {
+		Nil Pos
+	}
+
+	// BoolLit is "true" or "false".
+	BoolLit struct {
+		ValuePos Pos
+		Value    bool
+	}
+
+	// NumberLit is a numeric constant.
+	NumberLit struct {
+		ValuePos Pos
+		Raw      string      // source text (e.g. "0x10")
+		Value    interface{} // int64 or float64
+	}
+
+	// StringLit is a string constant, quoted or long.
+	StringLit struct {
+		ValuePos Pos
+		Raw      string // source text, with its quotes or brackets
+		Value    string
+		EndPos   Pos
+	}
+
+	// VarargLit is "...".
+	VarargLit struct {
+		Ellipsis Pos
+	}
+
+	// FuncLit is a function: "function (params) body end". Functions
+	// defined by statements have no function literal of their own.
+	FuncLit struct {
+		Function Pos      // position of "function"
+		Params   []*Ident // named parameters (without the "self" of methods)
+		Ellipsis Pos      // position of "...", if the function is vararg
+		Body     *Block
+		EndPos   Pos // position of "end"
+	}
+
+	// TableLit is a table constructor: "{ fields }".
+	TableLit struct {
+		Lbrace Pos
+		Fields []*Field
+		Rbrace Pos
+	}
+
+	// Field is a field of a table constructor: "[key] = value",
+	// "name = value" or "value".
+	Field struct {
+		Lbrack Pos  // position of "[", if the key is an expression
+		Key    Expr // key expression, *Ident for "name = value" or nil
+		Value  Expr
+	}
+
+	// ParenExpr is a parenthesized expression, which is adjusted to a
+	// single value.
+	ParenExpr struct {
+		Lparen Pos
+		X      Expr
+		Rparen Pos
+	}
+
+	// UnaryExpr is an unary operation.
+	UnaryExpr struct {
+		OpPos Pos
+		Op    code.Op // OpMinus, OpBnot, OpNot or OpLen
+		X     Expr
+	}
+
+	// BinaryExpr is a binary operation.
+	BinaryExpr struct {
+		X     Expr
+		OpPos Pos
+		Op    code.Op
+		Y     Expr
+	}
+
+	// FieldExpr is a field selection: "x.name".
+	FieldExpr struct {
+		X    Expr
+		Name *Ident
+	}
+
+	// IndexExpr is an index expression: "x[index]".
+	IndexExpr struct {
+		X      Expr
+		Lbrack Pos
+		Index  Expr
+		Rbrack Pos
+	}
+
+	// CallExpr is a function call "fn(args)", or a method call
+	// "fn:method(args)". The arguments of calls "f{...}" and f"..." have
+	// no parentheses.
+	CallExpr struct {
+		Fn     Expr
+		Method *Ident // method name, or nil
+		Lparen Pos    // position of "(", if any
+		Args   []Expr
+		Rparen Pos // position of ")", if any
+	}
+)
+
+func (x *Ident) Pos() Pos      { return x.NamePos }
+func (x *NilLit) Pos() Pos     { return x.Nil }
+func (x *BoolLit) Pos() Pos    { return x.ValuePos }
+func (x *NumberLit) Pos() Pos  { return x.ValuePos }
+func (x *StringLit) Pos() Pos  { return x.ValuePos }
+func (x *VarargLit) Pos() Pos  { return x.Ellipsis }
+func (x *FuncLit) Pos() Pos    { return x.Function }
+func (x *TableLit) Pos() Pos   { return x.Lbrace }
+func (x *ParenExpr) Pos() Pos  { return x.Lparen }
+func (x *UnaryExpr) Pos() Pos  { return x.OpPos }
+func (x *BinaryExpr) Pos() Pos { return x.X.Pos() }
+func (x *FieldExpr) Pos() Pos  { return x.X.Pos() }
+func (x *IndexExpr) Pos() Pos  { return x.X.Pos() }
+func (x *CallExpr) Pos() Pos   { return x.Fn.Pos() }
+
+func (x *Ident) End() Pos      { return x.NamePos.Add(len(x.Name)) }
+func (x *NilLit) End() Pos     { return x.Nil.Add(3) }
+func (x *NumberLit) End() Pos  { return x.ValuePos.Add(len(x.Raw)) }
+func (x *StringLit) End() Pos  { return x.EndPos }
+func (x *VarargLit) End() Pos  { return x.Ellipsis.Add(3) }
+func (x *FuncLit) End() Pos    { return x.EndPos.Add(3) }
+func (x *TableLit) End() Pos   { return x.Rbrace.Add(1) }
+func (x *ParenExpr) End() Pos  { return x.Rparen.Add(1) }
+func (x *UnaryExpr) End() Pos  { return x.X.End() }
+func (x *BinaryExpr) End() Pos { return x.Y.End() }
+func (x *FieldExpr) End() Pos  { return x.Name.End() }
+func (x *IndexExpr) End() Pos  { return x.Rbrack.Add(1) }
+
+func (x *BoolLit) End() Pos {
+	if x.Value {
+		return x.ValuePos.Add(4)
+	}
+	return x.ValuePos.Add(5)
+}
+
+func (x *CallExpr) End() Pos {
+	if x.Rparen.IsValid() {
+		return x.Rparen.Add(1)
+	}
+	return x.Args[len(x.Args)-1].End()
+}
+
+func (f *Field) Pos() Pos {
+	switch {
+	case f.Lbrack.IsValid():
+		return f.Lbrack
+	case f.Key != nil:
+		return f.Key.Pos()
+	}
+	return f.Value.Pos()
+}
+
+func (f *Field) End() Pos { return f.Value.End() }
+
+func (*Ident) exprNode()      {}
+func (*NilLit) exprNode()     {}
+func (*BoolLit) exprNode()    {}
+func (*NumberLit) exprNode()  {}
+func (*StringLit) exprNode()  {}
+func (*VarargLit) exprNode()  {}
+func (*FuncLit) exprNode()    {}
+func (*TableLit) exprNode()   {}
+func (*ParenExpr) exprNode()  {}
+func (*UnaryExpr) exprNode()  {}
+func (*BinaryExpr) exprNode() {}
+func (*FieldExpr) exprNode()  {}
+func (*IndexExpr) exprNode()  {}
+func (*CallExpr) exprNode()   {}
+
+//
+// Statements
+//
+
+type (
+	// BadStmt is a placeholder for a statement with syntax errors, which
+	// the parser skipped.
+	BadStmt struct {
+		From, To Pos // position of the statement, and of the next one
+	}
+
+	// EmptyStmt is ";".
+	EmptyStmt struct {
+		Semi Pos
+	}
+
+	// LocalStmt declares local variables: "local names [= values]".
+	LocalStmt struct {
+		Local  Pos
+		Names  []*Ident
+		Values []Expr
+	}
+
+	// AssignStmt is an assignment: "targets = values".
+	AssignStmt struct {
+		Targets []Expr // *Ident, *FieldExpr or *IndexExpr
+		Values  []Expr
+	}
+
+	// CallStmt is a function call used as a statement.
+	CallStmt struct {
+		Call *CallExpr
+	}
+
+	// LabelStmt is a label: "::name::".
+	LabelStmt struct {
+		Start  Pos
+		Name   *Ident
+		EndPos Pos // position of the closing "::"
+	}
+
+	// BreakStmt is "break".
+	BreakStmt struct {
+		Break Pos
+	}
+
+	// GotoStmt is "goto label".
+	GotoStmt struct {
+		Goto  Pos
+		Label *Ident
+	}
+
+	// DoStmt is "do body end".
+	DoStmt struct {
+		Do     Pos
+		Body   *Block
+		EndPos Pos // position of "end"
+	}
+
+	// WhileStmt is "while cond do body end".
+	WhileStmt struct {
+		While  Pos
+		Cond   Expr
+		Body   *Block
+		EndPos Pos // position of "end"
+	}
+
+	// RepeatStmt is "repeat body until cond".
+	RepeatStmt struct {
+		Repeat Pos
+		Body   *Block
+		Until  Pos
+		Cond   Expr
+	}
+
+	// IfStmt is "if cond then body {elseif cond then body} [else body]
+	// end".
+	IfStmt struct {
+		Clauses []*IfClause
+		EndPos  Pos // position of "end"
+	}
+
+	// IfClause is an "if", "elseif" or "else" clause of an IfStmt.
+	IfClause struct {
+		Keyword Pos  // position of "if", "elseif" or "else"
+		Cond    Expr // nil for "else"
+		Body    *Block
+	}
+
+	// NumericForStmt is "for name = start, limit [, step] do body end".
+	NumericForStmt struct {
+		For    Pos
+		Var    *Ident
+		Start  Expr
+		Limit  Expr
+		Step   Expr // or nil
+		Body   *Block
+		EndPos Pos // position of "end"
+	}
+
+	// GenericForStmt is "for names in exprs do body end".
+	GenericForStmt struct {
+		For    Pos
+		Names  []*Ident
+		Exprs  []Expr
+		Body   *Block
+		EndPos Pos // position of "end"
+	}
+
+	// FuncStmt defines a function: "function name body", where the name
+	// is a variable with optional fields ("a.b.c"), and an optional method
+	// name ("a.b:m").
+	FuncStmt struct {
+		Name   Expr   // *Ident or *FieldExpr
+		Method *Ident // or nil
+		Func   *FuncLit
+	}
+
+	// LocalFuncStmt defines a local function: "local function name body".
+	LocalFuncStmt struct {
+		Local Pos
+		Name  *Ident
+		Func  *FuncLit
+	}
+
+	// ReturnStmt is "return [values]".
+	ReturnStmt struct {
+		Return Pos
+		Values []Expr
+	}
+)
+
+func (s *BadStmt) Pos() Pos        { return s.From }
+func (s *EmptyStmt) Pos() Pos      { return s.Semi }
+func (s *LocalStmt) Pos() Pos      { return s.Local }
+func (s *AssignStmt) Pos() Pos     { return s.Targets[0].Pos() }
+func (s *CallStmt) Pos() Pos       { return s.Call.Pos() }
+func (s *LabelStmt) Pos() Pos      { return s.Start }
+func (s *BreakStmt) Pos() Pos      { return s.Break }
+func (s *GotoStmt) Pos() Pos       { return s.Goto }
+func (s *DoStmt) Pos() Pos         { return s.Do }
+func (s *WhileStmt) Pos() Pos      { return s.While }
+func (s *RepeatStmt) Pos() Pos     { return s.Repeat }
+func (s *IfStmt) Pos() Pos         { return s.Clauses[0].Pos() }
+func (c *IfClause) Pos() Pos       { return c.Keyword }
+func (s *NumericForStmt) Pos() Pos { return s.For }
+func (s *GenericForStmt) Pos() Pos { return s.For }
+func (s *FuncStmt) Pos() Pos       { return s.Func.Pos() }
+func (s *LocalFuncStmt) Pos() Pos  { return s.Local }
+func (s *ReturnStmt) Pos() Pos     { return s.Return }
+
+func (s *BadStmt) End() Pos        { return s.To }
+func (s *EmptyStmt) End() Pos      { return s.Semi.Add(1) }
+func (s *AssignStmt) End() Pos     { return s.Values[len(s.Values)-1].End() }
+func (s *CallStmt) End() Pos       { return s.Call.End() }
+func (s *LabelStmt) End() Pos      { return s.EndPos.Add(2) }
+func (s *BreakStmt) End() Pos      { return s.Break.Add(5) }
+func (s *GotoStmt) End() Pos       { return s.Label.End() }
+func (s *DoStmt) End() Pos         { return s.EndPos.Add(3) }
+func (s *WhileStmt) End() Pos      { return s.EndPos.Add(3) }
+func (s *RepeatStmt) End() Pos     { return s.Cond.End() }
+func (s *IfStmt) End() Pos         { return s.EndPos.Add(3) }
+func (c *IfClause) End() Pos       { return c.Body.End() }
+func (s *NumericForStmt) End() Pos { return s.EndPos.Add(3) }
+func (s *GenericForStmt) End() Pos { return s.EndPos.Add(3) }
+func (s *FuncStmt) End() Pos       { return s.Func.End() }
+func (s *LocalFuncStmt) End() Pos  { return s.Func.End() }
+
+func (s *LocalStmt) End() Pos {
+	if n := len(s.Values); n > 0 {
+		return s.Values[n-1].End()
+	}
+	return s.Names[len(s.Names)-1].End()
+}
+
+func (s *ReturnStmt) End() Pos {
+	if n := len(s.Values); n > 0 {
+		return s.Values[n-1].End()
+	}
+	return s.Return.Add(6)
+}
+
+func (*BadStmt) stmtNode()        {}
+func (*EmptyStmt) stmtNode()      {}
+func (*LocalStmt) stmtNode()      {}
+func (*AssignStmt) stmtNode()     {}
+func (*CallStmt) stmtNode()       {}
+func (*LabelStmt) stmtNode()      {}
+func (*BreakStmt) stmtNode()      {}
+func (*GotoStmt) stmtNode()       {}
+func (*DoStmt) stmtNode()         {}
+func (*WhileStmt) stmtNode()      {}
+func (*RepeatStmt) stmtNode()     {}
+func (*IfStmt) stmtNode()         {}
+func (*NumericForStmt) stmtNode() {}
+func (*GenericForStmt) stmtNode() {}
+func (*FuncStmt) stmtNode()       {}
+func (*LocalFuncStmt) stmtNode()  {}
+func (*ReturnStmt) stmtNode()     {}
+
+// OpString returns the source text of the unary or binary operator op,
+// e.g. "+" for code.OpAdd.
+func OpString(op code.Op) string {
+	if s, ok := opStrings[op]; ok {
+		return s
+	}
+	return op.String()
+}
+
+var opStrings = map[code.Op]string{
+	code.OpAdd:    "+",
+	code.OpSub:    "-",
+	code.OpMul:    "*",
+	code.OpMod:    "%",
+	code.OpPow:    "^",
+	code.OpDivF:   "/",
+	code.OpDivI:   "//",
+	code.OpBand:   "&",
+	code.OpBor:    "|",
+	code.OpBxor:   "~",
+	code.OpShl:    "<<",
+	code.OpShr:    ">>",
+	code.OpConcat: "..",
+	code.OpEq:     "==",
+	code.OpLt:     "<",
+	code.OpLe:     "<=",
+	code.OpNe:     "~=",
+	code.OpGt:     ">",
+	code.OpGe:     ">=",
+	code.OpAnd:    "and",
+	code.OpOr:     "or",
+	code.OpMinus:  "-",
+	code.OpBnot:   "~",
+	code.OpNot:    "not",
+	code.OpLen:    "#",
+}
@@ -0,0 +1,136 @@
+package ast_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+func parse(t *testing.T, src string) *ast.Chunk {
+	t.Helper()
+	chunk, err := luac.ParseString("=test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunk
+}
+
+// visitor records the nodes visited: their types, with their children
+// in parentheses.
+type visitor struct {
+	out *strings.Builder
+}
+
+func (v visitor) Visit(n ast.Node) ast.Visitor {
+	if n == nil {
+		v.out.WriteString(")")
+		return nil
+	}
+	fmt.Fprintf(v.out, " %s(", strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	var out strings.Builder
+	ast.Walk(visitor{&out}, parse(t, "local x = f(1) if x then return end"))
+	want := " Chunk( Block( LocalStmt( Ident() CallExpr( Ident() NumberLit()))" +
+		" IfStmt( IfClause( Ident() Block( ReturnStmt())))))"
+	if got := out.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	chunk := parse(t, `
+local function f(a, b) return a.x + b[1] end
+t = {f = f, g = function(...) return ... end}
+`)
+	var (
+		idents []string
+		funcs  int
+	)
+	ast.Inspect(chunk, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			idents = append(idents, n.Name)
+		case *ast.FuncLit:
+			funcs++
+			return false // (skip the bodies)
+		}
+		return true
+	})
+	if got, want := fmt.Sprint(idents), "[f t f f g]"; got != want {
+		t.Errorf("got identifiers %s, want %s", got, want)
+	}
+	if funcs != 2 {
+		t.Errorf("got %d functions, want 2", funcs)
+	}
+}
+
+func TestCommentText(t *testing.T) {
+	for _, test := range []struct {
+		src, want string
+	}{
+		{"-- a\n-- b\nx = 1", "a\nb\n"},
+		{"--a  \nx = 1", "a\n"},
+		{"--[[\n  long\n]]\nx = 1", "  long\n"},
+		{"--[==[ x ]] ]==]\nx = 1", " x ]]\n"},
+		{"--\n-- a\n--\nx = 1", "a\n"},
+	} {
+		chunk := parse(t, test.src)
+		if len(chunk.Comments) != 1 {
+			t.Errorf("%q: got %d comment groups", test.src, len(chunk.Comments))
+			continue
+		}
+		if got := chunk.Comments[0].Text(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	if (*ast.CommentGroup)(nil).Text() != "" {
+		t.Errorf("nil group: got text")
+	}
+}
+
+func TestCommentMap(t *testing.T) {
+	chunk := parse(t, `-- doc of f
+local function f()
+  -- doc of y
+  local y = 1 -- after y
+
+  -- end of f
+end
+t = {
+  a = 1, -- after a
+  -- doc of b
+  b = 2,
+}
+
+-- end of chunk
+`)
+	cmap := ast.NewCommentMap(chunk)
+	owners := make(map[string]string)
+	for node, groups := range cmap {
+		for _, g := range groups {
+			owners[strings.TrimSpace(g.Text())] = fmt.Sprintf("%T@%s", node, node.Pos())
+		}
+	}
+	for text, want := range map[string]string{
+		"doc of f":     "*ast.LocalFuncStmt@2:1",
+		"doc of y":     "*ast.LocalStmt@4:3",
+		"after y":      "*ast.LocalStmt@4:3",
+		"end of f":     "*ast.Block@2:19",
+		"after a":      "*ast.Field@9:3",
+		"doc of b":     "*ast.Field@11:3",
+		"end of chunk": "*ast.Block@1:1",
+	} {
+		if owners[text] != want {
+			t.Errorf("%q: got %s, want %s", text, owners[text], want)
+		}
+	}
+	if got := cmap.Comments(); len(got) != len(chunk.Comments) || got[0] != chunk.Comments[0] {
+		t.Errorf("Comments: got %d groups, want %d in order", len(got), len(chunk.Comments))
+	}
+}
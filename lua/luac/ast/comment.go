@@ -0,0 +1,138 @@
+package ast
+
+import "sort"
+
+// Text returns the text of the comments of the group, without their
+// comment markers ("--", "--[[" and "]]"), the first space of line comments
+// and leading and trailing empty lines, as for documentation.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range g.List {
+		text := c.Text[2:]
+		if level, ok := longBracket(text); ok {
+			text = text[level+2 : len(text)-level-2]
+		} else if len(text) > 0 && text[0] == ' ' {
+			text = text[1:]
+		}
+		for _, line := range splitLines(text) {
+			lines = append(lines, trimRight(line))
+		}
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var text string
+	for _, line := range lines {
+		text += line + "\n"
+	}
+	return text
+}
+
+// longBracket reports whether s is a long bracket "[==[ ... ]==]", and
+// its level (the number of '=').
+func longBracket(s string) (level int, ok bool) {
+	if len(s) < 2 || s[0] != '[' {
+		return 0, false
+	}
+	for level = 1; level < len(s) && s[level] == '='; level++ {
+	}
+	if level >= len(s) || s[level] != '[' {
+		return 0, false
+	}
+	level--
+	return level, len(s) >= 2*(level+2)
+}
+
+func splitLines(s string) (lines []string) {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			lines = append(lines, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(lines, s[start:])
+}
+
+func trimRight(s string) string {
+	i := len(s)
+	for i > 0 && (s[i-1] == ' ' || s[i-1] == '\t' || s[i-1] == '\r') {
+		i--
+	}
+	return s[:i]
+}
+
+// A CommentMap maps the statements and table fields of a syntax tree to
+// the comment groups associated with them: the groups on the lines just
+// before the node (its documentation) and the group at the end of the
+// node's last line.
+type CommentMap map[Node][]*CommentGroup
+
+// NewCommentMap associates the comments of chunk with its statements and
+// table fields. A comment group is associated with the innermost node
+// which either ends on the line where the group starts, or follows the
+// group, with no other node between them; groups which are associated
+// with no node (e.g. at the end of a block) are associated with the
+// innermost block which contains them, or else with the chunk.
+func NewCommentMap(chunk *Chunk) CommentMap {
+	var nodes []Node // statements, fields and blocks, in source order
+	Inspect(chunk, func(n Node) bool {
+		switch n.(type) {
+		case Stmt, *Field, *Block:
+			nodes = append(nodes, n)
+		}
+		return true
+	})
+
+	cmap := make(CommentMap)
+	for _, g := range chunk.Comments {
+		var (
+			owner Node = chunk
+			prev  Node // last node before g
+			next  Node // first node after g, in the same block as prev
+			outer Node // innermost block containing g
+		)
+		for _, n := range nodes {
+			switch {
+			case !g.Pos().Before(n.Pos()):
+				if _, ok := n.(*Block); ok && g.End().Before(n.End()) {
+					outer = n
+				} else if !n.End().Before(g.Pos()) {
+					continue // (n contains g)
+				} else {
+					prev = n
+				}
+			case next == nil:
+				if _, ok := n.(*Block); !ok {
+					next = n
+				}
+			}
+		}
+		switch {
+		case prev != nil && prev.End().Line == g.Pos().Line:
+			owner = prev
+		case next != nil && (outer == nil || next.End().Before(outer.End()) || next.End() == outer.End()):
+			owner = next
+		case outer != nil:
+			owner = outer
+		}
+		cmap[owner] = append(cmap[owner], g)
+	}
+	return cmap
+}
+
+// Comments returns the comment groups of the map, in source order.
+func (cmap CommentMap) Comments() []*CommentGroup {
+	var list []*CommentGroup
+	for _, groups := range cmap {
+		list = append(list, groups...)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pos().Before(list[j].Pos()) })
+	return list
+}
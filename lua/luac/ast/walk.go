@@ -0,0 +1,159 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of
+// node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a syntax tree in depth-first order: it starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w for
+// each of the non-nil children of node, in source order, followed by a
+// call of w.Visit(nil).
+//
+// Comments are not visited (see Chunk.Comments and CommentMap).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *Chunk:
+		Walk(v, n.Block)
+	case *Block:
+		walkStmts(v, n.Stmts)
+
+	// expressions
+	case *Ident, *NilLit, *BoolLit, *NumberLit, *StringLit, *VarargLit:
+		// nothing to do
+	case *FuncLit:
+		walkIdents(v, n.Params)
+		Walk(v, n.Body)
+	case *TableLit:
+		for _, f := range n.Fields {
+			Walk(v, f)
+		}
+	case *Field:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		Walk(v, n.Value)
+	case *ParenExpr:
+		Walk(v, n.X)
+	case *UnaryExpr:
+		Walk(v, n.X)
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+	case *FieldExpr:
+		Walk(v, n.X)
+		Walk(v, n.Name)
+	case *IndexExpr:
+		Walk(v, n.X)
+		Walk(v, n.Index)
+	case *CallExpr:
+		Walk(v, n.Fn)
+		if n.Method != nil {
+			Walk(v, n.Method)
+		}
+		walkExprs(v, n.Args)
+
+	// statements
+	case *BadStmt, *EmptyStmt, *BreakStmt:
+		// nothing to do
+	case *LocalStmt:
+		walkIdents(v, n.Names)
+		walkExprs(v, n.Values)
+	case *AssignStmt:
+		walkExprs(v, n.Targets)
+		walkExprs(v, n.Values)
+	case *CallStmt:
+		Walk(v, n.Call)
+	case *LabelStmt:
+		Walk(v, n.Name)
+	case *GotoStmt:
+		Walk(v, n.Label)
+	case *DoStmt:
+		Walk(v, n.Body)
+	case *WhileStmt:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+	case *RepeatStmt:
+		Walk(v, n.Body)
+		Walk(v, n.Cond)
+	case *IfStmt:
+		for _, c := range n.Clauses {
+			Walk(v, c)
+		}
+	case *IfClause:
+		if n.Cond != nil {
+			Walk(v, n.Cond)
+		}
+		Walk(v, n.Body)
+	case *NumericForStmt:
+		Walk(v, n.Var)
+		Walk(v, n.Start)
+		Walk(v, n.Limit)
+		if n.Step != nil {
+			Walk(v, n.Step)
+		}
+		Walk(v, n.Body)
+	case *GenericForStmt:
+		walkIdents(v, n.Names)
+		walkExprs(v, n.Exprs)
+		Walk(v, n.Body)
+	case *FuncStmt:
+		Walk(v, n.Name)
+		if n.Method != nil {
+			Walk(v, n.Method)
+		}
+		Walk(v, n.Func)
+	case *LocalFuncStmt:
+		Walk(v, n.Name)
+		Walk(v, n.Func)
+	case *ReturnStmt:
+		walkExprs(v, n.Values)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+	v.Visit(nil)
+}
+
+func walkIdents(v Visitor, list []*Ident) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+func walkExprs(v Visitor, list []Expr) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+func walkStmts(v Visitor, list []Stmt) {
+	for _, s := range list {
+		Walk(v, s)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a syntax tree in depth-first order: it starts by
+// calling f(node); node must not be nil. If f returns true, Inspect invokes
+// f recursively for each of the non-nil children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,114 @@
+package luac_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+)
+
+// compileSources are compiled by TestCompileUnchanged along with the
+// corpus and testdata/compile/*.lua: line breaks and errors of the lexer.
+var compileSources = []struct{ name, src string }{
+	{"crlf", "local a\r\nlocal b = 1\n\rlocal c = 2\r\rreturn a, b, c\n"},
+	{"shebang", "#!/usr/bin/lua\nreturn 1\n"},
+	{"comment-eof", "return 1 -- no final newline"},
+	{"error-syntax", "x = = 1"},
+	{"error-crlf", "x = 1\r\n\r\nx = = 1"},
+	{"error-lfcr", "x = 1\n\r\n\rx = = 1"},
+	{"error-shebang", "#!/usr/bin/lua\nx = = 1"},
+	{"error-comment", "--[[ a\ncomment ]] x = = 1"},
+	{"error-string", "x = 'unfinished"},
+	{"error-string-newline", "x = \"a\nb\""},
+	{"error-long-string", "x = [[unfinished"},
+	{"error-long-comment", "--[==[ unfinished ]]"},
+	{"error-escape", `x = "bad \q escape"`},
+	{"error-decimal-escape", `x = "\300"`},
+	{"error-utf8-escape", `x = "\u{80000000}"`},
+	{"error-number", "x = 3..4"},
+	{"error-char", "x = 1 @"},
+	{"error-eof", "return 1 +"},
+	{"error-goto", "goto nowhere"},
+	{"error-break", "break"},
+	{"error-label", "::a:: ::a::"},
+}
+
+// TestCompileUnchanged checks that the compiler's output is the one
+// recorded in testdata/compile/golden before the lexer was extended for
+// ParseString and Tokenize (positions, comments): the SHA-256 digest of
+// every function prototype, including its debug information so that the
+// line numbers are checked too, or the compilation error.
+func TestCompileUnchanged(t *testing.T) {
+	golden, err := os.ReadFile("testdata/compile/golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = make(map[string]string)
+	for sc := bufio.NewScanner(bytes.NewReader(golden)); sc.Scan(); {
+		if name, result, ok := bytes.Cut(sc.Bytes(), []byte("\t")); ok {
+			want[string(name)] = string(result)
+		}
+	}
+	files, err := filepath.Glob("testdata/compile/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := compileSources
+	for _, file := range append(corpus(t), files...) {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, struct{ name, src string }{filepath.Base(file), string(src)})
+	}
+	for _, source := range sources {
+		got := compileResult(source.name, source.src)
+		if w, ok := want[source.name]; !ok {
+			t.Errorf("%s: not in testdata/compile/golden", source.name)
+		} else if got != w {
+			t.Errorf("%s: got\n\t%s\nwant\n\t%s", source.name, got, w)
+		}
+	}
+}
+
+// compileResult returns the digest of the chunk compiled from src, or its
+// compilation error.
+func compileResult(name, src string) string {
+	chunk, err := luac.Compile(luac.Defaults, "@"+name, src)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	h := sha256.New()
+	writeProto(h, chunk.Main)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// writeProto writes every field of the prototype fn and of its nested
+// prototypes to w (independently of the binary chunk format).
+func writeProto(w io.Writer, fn *code.Proto) {
+	fmt.Fprintf(w, "function %q %d-%d params %d vararg %t stack %d\n", fn.Source, fn.SrcPos, fn.EndPos, fn.ParamN, fn.Vararg, fn.StackN)
+	for _, inst := range fn.Instrs {
+		fmt.Fprintf(w, "%08x\n", uint32(inst))
+	}
+	fmt.Fprintln(w, "lines", fn.PcLine)
+	for _, kst := range fn.Consts {
+		fmt.Fprintf(w, "constant %T %#v\n", kst, kst)
+	}
+	for _, up := range fn.UpVars {
+		fmt.Fprintf(w, "upvalue %q %t %d\n", up.Name, up.Stack, up.Index)
+	}
+	for _, local := range fn.Locals {
+		fmt.Fprintf(w, "local %q %d %d\n", local.Name, local.Live, local.Dead)
+	}
+	for _, p := range fn.Protos {
+		writeProto(w, p)
+	}
+	fmt.Fprintln(w, "end")
+}
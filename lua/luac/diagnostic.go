@@ -0,0 +1,145 @@
+package luac
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	SeverityError   Severity = iota // the chunk is invalid
+	SeverityWarning                 // the chunk is valid, but suspicious
+)
+
+func (sev Severity) String() string {
+	switch sev {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(sev))
+}
+
+// Diagnostic is a problem found in a chunk, e.g. a syntax error.
+type Diagnostic struct {
+	File     string  // name of the chunk, as in messages (e.g. "x.lua" for "@x.lua")
+	Line     int     // line of the problem, as in messages
+	Pos      ast.Pos // position of the problem: its line and column
+	End      ast.Pos // end of its span (e.g. of the offending token)
+	Severity Severity
+	Message  string // e.g. "unexpected symbol near '='"
+	AtEOF    bool   // found at the end of the input: the chunk may be incomplete
+}
+
+// Error returns the diagnostic as Lua reports it, e.g.
+//
+//	x.lua:3: unexpected symbol near '='
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// Render writes the diagnostic, with its line and column, followed by the
+// line of src where it is, with a caret under the span of the problem:
+//
+//	x.lua:3:5: error: unexpected symbol near '='
+//		x = = 1
+//		    ^
+//
+// The source line is omitted if src is nil.
+func (d *Diagnostic) Render(w io.Writer, src []byte) error {
+	if _, err := fmt.Fprintf(w, "%s:%s: %s: %s\n", d.File, d.Pos, d.Severity, d.Message); err != nil {
+		return err
+	}
+	if src == nil || !d.Pos.IsValid() {
+		return nil
+	}
+	line := string(sourceLine(src, d.Pos.Line))
+	col := d.Pos.Column - 1
+	if col > len(line) {
+		col = len(line)
+	}
+	var caret strings.Builder
+	for _, c := range []byte(line[:col]) {
+		if c == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+	if d.End.Line == d.Pos.Line {
+		for n := d.Pos.Column + 1; n < d.End.Column && n <= len(line); n++ {
+			caret.WriteByte('~')
+		}
+	}
+	_, err := fmt.Fprintf(w, "\t%s\n\t%s\n", line, caret.String())
+	return err
+}
+
+// sourceLine returns the line of src, without its end of line.
+func sourceLine(src []byte, line int) []byte {
+	for ; line > 1; line-- {
+		i := bytes.IndexAny(src, "\r\n")
+		if i < 0 {
+			return nil
+		}
+		if bytes.HasPrefix(src[i:], []byte("\r\n")) || bytes.HasPrefix(src[i:], []byte("\n\r")) {
+			i++
+		}
+		src = src[i+1:]
+	}
+	if i := bytes.IndexAny(src, "\r\n"); i >= 0 {
+		src = src[:i]
+	}
+	return src
+}
+
+// Diagnostics is a list of diagnostics, in the order of the source. The
+// errors found in the source by Compile, ParseFile, ParseString and
+// Tokenize are returned as Diagnostics.
+type Diagnostics []*Diagnostic
+
+// Error returns the first diagnostic as Lua reports it (see
+// Diagnostic.Error).
+func (list Diagnostics) Error() string {
+	if len(list) == 0 {
+		return "no errors"
+	}
+	return list[0].Error()
+}
+
+// Render renders the diagnostics (see Diagnostic.Render).
+func (list Diagnostics) Render(w io.Writer, src []byte) error {
+	for _, d := range list {
+		if err := d.Render(w, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// err returns the list as an error, or nil if it is empty.
+func (list Diagnostics) err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// catch recovers the *Diagnostic of a panicking lexical, if any, storing
+// it in *d; other panics are propagated.
+func catch(d **Diagnostic) {
+	if r := recover(); r != nil {
+		var ok bool
+		if *d, ok = r.(*Diagnostic); !ok {
+			panic(r)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package luac_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// diagnose returns the diagnostics of the compilation of src.
+func diagnose(t *testing.T, src string) luac.Diagnostics {
+	t.Helper()
+	_, err := luac.Compile(luac.Defaults, "@x.lua", src)
+	var list luac.Diagnostics
+	if !errors.As(err, &list) || len(list) == 0 {
+		t.Fatalf("%q: got error %v, want diagnostics", src, err)
+	}
+	return list
+}
+
+func TestDiagnostics(t *testing.T) {
+	for _, test := range []struct {
+		src      string
+		msg      string
+		pos, end ast.Pos
+		eof      bool
+	}{
+		{"for i x", "x.lua:1: '=' or 'in' expected near <name>", ast.Pos{Line: 1, Column: 7}, ast.Pos{Line: 1, Column: 8}, false},
+		{"local a x", "x.lua:1: syntax error near <eof>", ast.Pos{Line: 1, Column: 10}, ast.Pos{Line: 1, Column: 10}, true},
+		{"\n  x = = 1", "x.lua:2: unexpected symbol near '='", ast.Pos{Line: 2, Column: 7}, ast.Pos{Line: 2, Column: 8}, false},
+		{"for i = 1, 2 do", "x.lua:1: 'end' expected near <eof>", ast.Pos{Line: 1, Column: 16}, ast.Pos{Line: 1, Column: 16}, true},
+		{"x = 'abc\n", "x.lua:1: unfinished string near <string>", ast.Pos{Line: 1, Column: 5}, ast.Pos{Line: 1, Column: 9}, false},
+		{"x = 'abc", "x.lua:1: unfinished string near <eof>", ast.Pos{Line: 1, Column: 5}, ast.Pos{Line: 1, Column: 9}, true},
+		{"x = [[a\nb", "x.lua:2: unfinished long string near <eof>", ast.Pos{Line: 1, Column: 5}, ast.Pos{Line: 2, Column: 2}, true},
+		{"goto l", "x.lua:1: no visible label 'l' for <goto> at line 1", ast.Pos{Line: 1, Column: 7}, ast.Pos{Line: 1, Column: 7}, false},
+		{"break", "x.lua:1: <break> at line 1 not inside a loop", ast.Pos{Line: 1, Column: 6}, ast.Pos{Line: 1, Column: 6}, false},
+	} {
+		d := diagnose(t, test.src)[0]
+		if d.Error() != test.msg || d.File != "x.lua" || d.Severity != luac.SeverityError {
+			t.Errorf("%q: got %s %q (%s), want %q", test.src, d.Severity, d.Error(), d.File, test.msg)
+		}
+		if d.Pos != test.pos || d.End != test.end {
+			t.Errorf("%q: got span %s-%s, want %s-%s", test.src, d.Pos, d.End, test.pos, test.end)
+		}
+		if d.AtEOF != test.eof {
+			t.Errorf("%q: got AtEOF %t, want %t", test.src, d.AtEOF, test.eof)
+		}
+	}
+}
+
+func TestDiagnosticsList(t *testing.T) {
+	list := diagnose(t, "x = = 1\nlocal 2\ny = 3\nz = )\n")
+	var lines []int
+	for _, d := range list {
+		lines = append(lines, d.Line)
+	}
+	if len(lines) != 3 || lines[0] != 1 || lines[1] != 2 || lines[2] != 4 {
+		t.Errorf("got errors on lines %v, want [1 2 4]", lines)
+	}
+	if list.Error() != list[0].Error() {
+		t.Errorf("got %q, want the first error %q", list.Error(), list[0].Error())
+	}
+	// a semantic error is followed by the syntax errors after it only
+	list = diagnose(t, "x = = 1\ndo goto l end\ny = = 2\n")
+	if len(list) != 2 || list[1].Line != 3 {
+		t.Errorf("got %v, want errors on lines 1 and 3", list)
+	}
+	list = diagnose(t, "local function f() goto l end\nx = = 1\n")
+	if len(list) != 2 || !strings.Contains(list[0].Message, "no visible label") || list[1].Line != 2 {
+		t.Errorf("got %v, want the goto error and the error on line 2", list)
+	}
+	if got := (luac.Diagnostics{}).Error(); got != "no errors" {
+		t.Errorf("empty list: got %q", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, test := range []struct {
+		src, want string
+	}{
+		{"for i x", "x.lua:1:7: error: '=' or 'in' expected near <name>\n\tfor i x\n\t      ^\n"},
+		{"x = 1\n\tf(a b)", "x.lua:2:6: error: ')' expected near <name>\n\t\tf(a b)\n\t\t    ^\n"},
+		{"x = 1\r\ny = ..\r\n", "x.lua:2:5: error: unexpected symbol near '..'\n\ty = ..\n\t    ^~\n"},
+		{"x = 'abc\ny = 1", "x.lua:1:5: error: unfinished string near <string>\n\tx = 'abc\n\t    ^~~~\n"},
+		{"x = [[a\nb", "x.lua:1:5: error: unfinished long string near <eof>\n\tx = [[a\n\t    ^\n"},
+	} {
+		list := diagnose(t, test.src)
+		var b strings.Builder
+		if err := list[:1].Render(&b, []byte(test.src)); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.src, b.String(), test.want)
+		}
+	}
+	var b strings.Builder
+	diagnose(t, "for i x")[0].Render(&b, nil)
+	if want := "x.lua:1:7: error: '=' or 'in' expected near <name>\n"; b.String() != want {
+		t.Errorf("no source: got %q, want %q", b.String(), want)
+	}
+}
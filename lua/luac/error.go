@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac/ast"
 )
 
 // undefGotoErr generates an error for an undefined 'goto'; choose appropriate
@@ -22,8 +22,9 @@ func (ls *lexical) semanticErr(msg string) {
 	ls.syntaxErr(msg)
 }
 
+// syntaxErr reports an error near the current token.
 func (ls *lexical) syntaxErr(msg string) {
-	ls.scanErr(msg, ls.token.char)
+	ls.errorAt(ls.token.pos, ls.token.end, msg, ls.token.char)
 }
 
 func (ls *lexical) expectErr(tok rune) {
@@ -46,11 +47,26 @@ func (ls *lexical) numberErr() {
 	ls.scanErr("malformed number", tFloat)
 }
 
+// scanErr reports an error in the token being scanned.
 func (ls *lexical) scanErr(msg string, tok rune) {
-	if msg = addErrInfo(msg, ls.name, ls.line); tok != 0 {
+	ls.errorAt(ls.start, ls.here(), msg, tok)
+}
+
+// errorAt reports an error in the span from pos to end, by panicking with
+// a *Diagnostic.
+func (ls *lexical) errorAt(pos, end ast.Pos, msg string, tok rune) {
+	if tok != 0 {
 		msg = fmt.Sprintf("%s near %s", msg, ls.tok2str(tok))
 	}
-	panic(code.Error(msg))
+	panic(&Diagnostic{
+		File:     chunkID(ls.name),
+		Line:     ls.line,
+		Pos:      pos,
+		End:      end,
+		Severity: SeverityError,
+		Message:  msg,
+		AtEOF:    tok == tEOS,
+	})
 }
 
 func (ls *lexical) checkEsc(cond bool, msg string) {
@@ -63,10 +79,13 @@ func (ls *lexical) checkEsc(cond bool, msg string) {
 }
 
 func (ls *lexical) limitErr(fs *function, limit int, what string) {
-	var (
-		where = "main function"
-		line0 = fs.fn.SrcPos
-	)
+	ls.limitErrAt(fs.fn.SrcPos, limit, what)
+}
+
+// limitErrAt reports a limit error in the function defined at line0 (0 for
+// the main function).
+func (ls *lexical) limitErrAt(line0, limit int, what string) {
+	where := "main function"
 	if line0 != 0 {
 		where = fmt.Sprintf("function at line %d", line0)
 	}
@@ -76,6 +95,11 @@ func (ls *lexical) limitErr(fs *function, limit int, what string) {
 
 // add src:line information to message
 func addErrInfo(msg, src string, line int) string {
+	return fmt.Sprintf("%s:%d: %s", chunkID(src), line, msg)
+}
+
+// chunkID returns the name of the chunk src for messages.
+func chunkID(src string) string {
 	chunkID := "?"
 	if len(src) > 0 {
 		switch src[0] {
@@ -101,7 +125,7 @@ func addErrInfo(msg, src string, line int) string {
 			}
 		}
 	}
-	return fmt.Sprintf("%s:%d: %s", chunkID, line, msg)
+	return chunkID
 }
 
 func checkLimit(fs *function, value, limit int, what string) {
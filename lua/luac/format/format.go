@@ -0,0 +1,111 @@
+// Package format implements the canonical formatting of Lua source, as
+// done by "glua fmt".
+//
+// The canonical format indents blocks with tabs, puts each statement on its
+// own line, spaces binary operators and list separators, and quotes strings
+// with double quotes when this needs no new escapes. The layout choices of
+// the source are otherwise kept: comments, single blank lines between
+// statements, statements written on a single line, tables written on
+// several lines, and line breaks within expressions.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// Source formats the source of the chunk named name (e.g. "@file.lua", see
+// luac.ParseString) and returns the formatted source; a first line starting
+// with "#" is kept as is. Syntax errors are returned as luac.Diagnostics.
+//
+// Formatting never changes the meaning of the chunk: the formatted source
+// is compiled and its code, without debug information, is compared with the
+// code of the source; an error is returned if they differ.
+func Source(name string, src []byte) ([]byte, error) {
+	chunk, err := luac.ParseString(name, string(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if bytes.HasPrefix(src, []byte("#")) {
+		line := src
+		if n := bytes.IndexByte(src, '\n'); n >= 0 {
+			line = src[:n]
+		}
+		buf.Write(bytes.TrimRight(line, " \t\r"))
+		buf.WriteByte('\n')
+	}
+	if err := Fprint(&buf, chunk); err != nil {
+		return nil, err
+	}
+	if err := verify(name, src, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fprint prints the syntax tree of the chunk to w in the canonical format,
+// with the comments of the chunk. The tree must have no ast.BadStmt, and
+// its number and string literals are printed from their source text (Raw).
+func Fprint(w io.Writer, chunk *ast.Chunk) error {
+	bad := false
+	ast.Inspect(chunk, func(n ast.Node) bool {
+		if _, ok := n.(*ast.BadStmt); ok {
+			bad = true
+		}
+		return !bad
+	})
+	if bad {
+		return errors.New("format: syntax tree with errors")
+	}
+	p := newPrinter(chunk)
+	p.chunk(chunk)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// verify checks that the source src of the chunk named name and its
+// formatted source out compile to the same code.
+func verify(name string, src, out []byte) error {
+	want, err := dump(name, src)
+	if err != nil {
+		return err
+	}
+	got, err := dump(name, out)
+	if err != nil || !bytes.Equal(got, want) {
+		if len(name) > 0 && (name[0] == '@' || name[0] == '=') {
+			name = name[1:]
+		}
+		return fmt.Errorf("%s: formatting changes the code of the chunk", name)
+	}
+	return nil
+}
+
+// dump compiles the source of the chunk named name, and returns its dump
+// without debug information, including the lines where functions are
+// defined, which a stripped dump keeps.
+func dump(name string, src []byte) ([]byte, error) {
+	chunk, err := luac.Compile(luac.Defaults, name, src)
+	if err != nil {
+		return nil, err
+	}
+	unline(chunk.Main)
+	var buf bytes.Buffer
+	if _, err := chunk.Dump(&buf, true); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unline(fn *code.Proto) {
+	fn.SrcPos, fn.EndPos = 0, 0
+	for _, p := range fn.Protos {
+		unline(p)
+	}
+}
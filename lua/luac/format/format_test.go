@@ -0,0 +1,150 @@
+package format_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/ast"
+	"github.com/Azure/golua/lua/luac/format"
+)
+
+// strip returns the code of the chunk src, without debug information nor
+// the lines where functions are defined.
+func strip(t *testing.T, name string, src []byte) []byte {
+	t.Helper()
+	chunk, err := luac.Compile(luac.Defaults, name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unline func(*code.Proto)
+	unline = func(fn *code.Proto) {
+		fn.SrcPos, fn.EndPos = 0, 0
+		for _, p := range fn.Protos {
+			unline(p)
+		}
+	}
+	unline(chunk.Main)
+	var b bytes.Buffer
+	if _, err := chunk.Dump(&b, true); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// comments returns the text of the comments of src.
+func comments(t *testing.T, src []byte) (list []string) {
+	t.Helper()
+	toks, err := luac.Tokenize("=test", src, luac.Comments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range toks {
+		if tok.Kind == luac.Comment {
+			list = append(list, strings.TrimRight(tok.Text, " \t\r\n"))
+		}
+	}
+	return list
+}
+
+func TestCorpus(t *testing.T) {
+	files, err := filepath.Glob("../../test/lua/*.lua")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no tests in lua/test/lua: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			name := "@" + filepath.Base(file)
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := format.Source(name, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := format.Source(name, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, out) {
+				t.Errorf("formatting is not idempotent")
+			}
+			if !bytes.Equal(strip(t, name, out), strip(t, name, src)) {
+				t.Errorf("formatted chunk compiles to different code")
+			}
+			if got, want := comments(t, out), comments(t, src); !reflect.DeepEqual(got, want) {
+				t.Errorf("formatted chunk has %d comments, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestSource(t *testing.T) {
+	for _, test := range []struct {
+		name, src, want string
+	}{
+		{"Spaces", "local   x=1+2*3", "local x = 1 + 2 * 3\n"},
+		{"Operators", "x = not a and #b or -c .. d ^ -e", "x = not a and #b or -c .. d ^ -e\n"},
+		{"Statements", "while x do break end repeat x=x-1 until x==0", "while x do break end\nrepeat x = x - 1 until x == 0\n"},
+		{"SingleLine", "if x then y=1 elseif z then y=2 else y=3 end", "if x then y = 1 elseif z then y = 2 else y = 3 end\n"},
+		{"Blocks", "function f(a)\nif a then\nreturn 1\nend\nend", "function f(a)\n\tif a then\n\t\treturn 1\n\tend\nend\n"},
+		{"Loops", "for i=1,10,2 do f() end\nfor k,v in pairs(t) do end", "for i = 1, 10, 2 do f() end\nfor k, v in pairs(t) do end\n"},
+		{"Methods", "function a.b:c(x,...) return x end", "function a.b:c(x, ...) return x end\n"},
+		{"Labels", "goto l ::l:: do end ; ;", "goto l\n::l::\ndo end\n"},
+		{"Table", "local t={1,2;3}", "local t = {1, 2, 3}\n"},
+		{"TableLines", "local t = {\na=1,\n['b'] = 'x'}", "local t = {\n\ta = 1,\n\t[\"b\"] = \"x\",\n}\n"},
+		{"Quotes", `print('a', 'it\'s', "q\"", 'q"')`, `print("a", "it's", "q\"", 'q"')` + "\n"},
+		{"Calls", "f{1} f'x' f[[y]] a.b.c = (f())", "f {1}\nf \"x\"\nf [[y]]\na.b.c = (f())\n"},
+		{"Comments", "-- c1\n\n\n\nx = 1 -- c2\n\n--[[ long ]]\ny = 2", "-- c1\n\nx = 1 -- c2\n\n--[[ long ]]\ny = 2\n"},
+		{"Shebang", "#!/usr/bin/lua  \nprint(1)", "#!/usr/bin/lua\nprint(1)\n"},
+		{"Empty", "", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := format.Source("=test", []byte(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.want {
+				t.Errorf("got\n%s\nwant\n%s", out, test.want)
+			}
+		})
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := format.Source("=test", []byte("x = = 1\ny = 2\nz = )"))
+	list, ok := err.(luac.Diagnostics)
+	if !ok || len(list) != 2 || list.Error() != "test:1: unexpected symbol near '='" {
+		t.Errorf("got error %v, want the 2 syntax errors", err)
+	}
+	tree, _ := luac.ParseString("=test", "x = = 1")
+	if err := format.Fprint(new(bytes.Buffer), tree); err == nil {
+		t.Errorf("Fprint: printed a tree with errors")
+	}
+}
+
+func TestFprint(t *testing.T) {
+	tree, err := luac.ParseString("=test", "local x = {'a', 1}\n\n\nreturn x[1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast.Inspect(tree, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "x" {
+			id.Name = "renamed"
+		}
+		return true
+	})
+	var b bytes.Buffer
+	if err := format.Fprint(&b, tree); err != nil {
+		t.Fatal(err)
+	}
+	if want := "local renamed = {\"a\", 1}\n\nreturn renamed[1]\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
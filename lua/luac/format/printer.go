@@ -0,0 +1,608 @@
+package format
+
+import (
+	"bytes"
+	"math"
+	"strings"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// printer prints a syntax tree in the canonical format. Tokens are printed
+// with their source positions, if any, so that the comments before them are
+// printed first, and the line breaks of the source can be kept.
+type printer struct {
+	buf      bytes.Buffer
+	comments []*ast.Comment // comments not printed yet
+	indent   int            // indentation of the current line
+	cont     bool           // whether indent includes a continuation line
+	last     int            // source line of the last token or comment printed
+	col0     bool           // whether the current line is empty
+	pending  bool           // whether a space is due before the next token
+	opened   bool           // whether a block was just opened
+}
+
+func newPrinter(chunk *ast.Chunk) *printer {
+	p := &printer{col0: true, opened: true}
+	for _, g := range chunk.Comments {
+		p.comments = append(p.comments, g.List...)
+	}
+	return p
+}
+
+func (p *printer) chunk(chunk *ast.Chunk) {
+	p.stmts(chunk.Block.Stmts)
+	p.flush(ast.Pos{Line: math.MaxInt32})
+	if !p.col0 {
+		p.newline()
+	}
+}
+
+// write writes s, after the indentation or the pending space.
+func (p *printer) write(s string) {
+	if p.col0 {
+		for i := 0; i < p.indent; i++ {
+			p.buf.WriteByte('\t')
+		}
+	} else if p.pending {
+		p.buf.WriteByte(' ')
+	}
+	p.buf.WriteString(s)
+	p.col0, p.pending, p.opened = false, false, false
+}
+
+// space asks for a space before the next token on the line.
+func (p *printer) space() { p.pending = true }
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.col0, p.pending = true, false
+}
+
+// token prints the token s at pos, if valid, after the comments before it.
+func (p *printer) token(pos ast.Pos, s string) {
+	if pos.IsValid() {
+		p.flush(pos)
+	}
+	p.write(s)
+	if pos.IsValid() {
+		p.last = pos.Line
+	}
+}
+
+// flush prints the comments before pos: on the current line if they start
+// on the source line of the last token, on their own lines otherwise.
+func (p *printer) flush(pos ast.Pos) {
+	for p.before(pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if c.Start.Line == p.last && !p.col0 {
+			p.space()
+		} else {
+			if !p.col0 {
+				p.newline()
+			}
+			if c.Start.Line > p.last+1 && !p.opened {
+				p.newline()
+			}
+		}
+		text, long := c.Text, isLong(c.Text)
+		if !long {
+			text = strings.TrimRight(text, " \t\r")
+		}
+		p.write(text)
+		p.last = c.EndPos.Line
+		if long {
+			p.space()
+		} else {
+			p.newline()
+		}
+	}
+}
+
+// linebreak starts the line of the statement or field at pos, keeping a
+// blank line which separates it from the previous one.
+func (p *printer) linebreak(pos ast.Pos) {
+	p.flush(pos)
+	if !p.col0 {
+		p.newline()
+	}
+	if pos.Line > p.last+1 && !p.opened {
+		p.newline()
+	}
+}
+
+// contbreak continues the current statement or list on a new line, at pos,
+// indented once.
+func (p *printer) contbreak(pos ast.Pos) {
+	p.flush(pos)
+	if !p.col0 {
+		p.newline()
+	}
+	if !p.cont {
+		p.indent++
+		p.cont = true
+	}
+}
+
+// fits reports whether the construct whose first token was just printed
+// ends at end on the same line, without comments: it is then printed on a
+// single line.
+func (p *printer) fits(end ast.Pos) bool {
+	return p.last == end.Line && !p.before(end)
+}
+
+// before reports whether a comment not printed yet is before pos.
+func (p *printer) before(pos ast.Pos) bool {
+	return len(p.comments) > 0 && p.comments[0].Pos().Before(pos)
+}
+
+//
+// Statements
+//
+
+func (p *printer) stmts(list []ast.Stmt) {
+	first := true
+	for i, s := range list {
+		if _, ok := s.(*ast.EmptyStmt); ok {
+			// a line of ";" is not a blank line
+			if i+1 == len(list) || list[i+1].Pos().Line > s.Pos().Line {
+				p.flush(s.Pos())
+				p.last = s.Pos().Line
+			}
+			continue
+		}
+		p.linebreak(s.Pos())
+		if !first && startsWithParen(s) {
+			p.write(";") // not a call of the previous statement
+		}
+		p.stmt(s)
+		first = false
+	}
+}
+
+// block prints the statements of b on their own lines, one level deeper,
+// with the comments before the token closing b.
+func (p *printer) block(b *ast.Block) {
+	indent, cont := p.indent, p.cont
+	p.indent, p.cont = indent+1, false
+	p.opened = true
+	p.stmts(b.Stmts)
+	p.flush(b.EndPos)
+	p.indent, p.cont = indent, cont
+	if !p.col0 {
+		p.newline()
+	}
+}
+
+// inline prints the statements of b on the current line.
+func (p *printer) inline(b *ast.Block) {
+	sep := false
+	for _, s := range b.Stmts {
+		if _, ok := s.(*ast.EmptyStmt); ok {
+			continue
+		}
+		if sep {
+			p.write(";")
+		}
+		p.space()
+		p.stmt(s)
+		sep = true
+	}
+	p.space()
+}
+
+func (p *printer) body(b *ast.Block, one bool) {
+	if one {
+		p.inline(b)
+	} else {
+		p.block(b)
+	}
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	indent, cont := p.indent, p.cont
+	p.cont = false
+	switch s := s.(type) {
+	case *ast.LocalStmt:
+		p.token(s.Local, "local")
+		p.space()
+		p.idents(s.Names)
+		if len(s.Values) > 0 {
+			p.space()
+			p.write("=")
+			p.exprs(s.Names[len(s.Names)-1].End(), s.Values, true)
+		}
+	case *ast.AssignStmt:
+		p.exprs(s.Targets[0].Pos(), s.Targets, false)
+		p.space()
+		p.write("=")
+		p.exprs(s.Targets[len(s.Targets)-1].End(), s.Values, true)
+	case *ast.CallStmt:
+		p.expr(s.Call)
+	case *ast.LabelStmt:
+		p.token(s.Start, "::")
+		p.ident(s.Name)
+		p.token(s.EndPos, "::")
+	case *ast.BreakStmt:
+		p.token(s.Break, "break")
+	case *ast.GotoStmt:
+		p.token(s.Goto, "goto")
+		p.space()
+		p.ident(s.Label)
+	case *ast.DoStmt:
+		p.token(s.Do, "do")
+		p.body(s.Body, p.fits(s.EndPos))
+		p.token(s.EndPos, "end")
+	case *ast.WhileStmt:
+		p.token(s.While, "while")
+		one := p.fits(s.EndPos)
+		p.space()
+		p.expr(s.Cond)
+		p.space()
+		p.write("do")
+		p.indent, p.cont = indent, false
+		p.body(s.Body, one)
+		p.token(s.EndPos, "end")
+	case *ast.RepeatStmt:
+		p.token(s.Repeat, "repeat")
+		p.body(s.Body, p.fits(s.Cond.End()))
+		p.token(s.Until, "until")
+		p.space()
+		p.expr(s.Cond)
+	case *ast.IfStmt:
+		var one bool
+		for i, c := range s.Clauses {
+			switch {
+			case i == 0:
+				p.token(c.Keyword, "if")
+				one = p.fits(s.EndPos)
+			case c.Cond != nil:
+				p.token(c.Keyword, "elseif")
+			default:
+				p.token(c.Keyword, "else")
+			}
+			if c.Cond != nil {
+				p.space()
+				p.expr(c.Cond)
+				p.space()
+				p.write("then")
+			}
+			p.indent, p.cont = indent, false
+			p.body(c.Body, one)
+		}
+		p.token(s.EndPos, "end")
+	case *ast.NumericForStmt:
+		p.token(s.For, "for")
+		one := p.fits(s.EndPos)
+		p.space()
+		p.ident(s.Var)
+		p.space()
+		p.write("=")
+		list := []ast.Expr{s.Start, s.Limit}
+		if s.Step != nil {
+			list = append(list, s.Step)
+		}
+		p.exprs(s.Var.End(), list, true)
+		p.space()
+		p.write("do")
+		p.indent, p.cont = indent, false
+		p.body(s.Body, one)
+		p.token(s.EndPos, "end")
+	case *ast.GenericForStmt:
+		p.token(s.For, "for")
+		one := p.fits(s.EndPos)
+		p.space()
+		p.idents(s.Names)
+		p.space()
+		p.write("in")
+		p.exprs(s.Names[len(s.Names)-1].End(), s.Exprs, true)
+		p.space()
+		p.write("do")
+		p.indent, p.cont = indent, false
+		p.body(s.Body, one)
+		p.token(s.EndPos, "end")
+	case *ast.FuncStmt:
+		p.token(s.Func.Function, "function")
+		one := p.fits(s.Func.EndPos)
+		p.space()
+		p.expr(s.Name)
+		if s.Method != nil {
+			p.write(":")
+			p.ident(s.Method)
+		}
+		p.funcBody(s.Func, one)
+	case *ast.LocalFuncStmt:
+		p.token(s.Local, "local")
+		p.space()
+		p.token(s.Func.Function, "function")
+		one := p.fits(s.Func.EndPos)
+		p.space()
+		p.ident(s.Name)
+		p.funcBody(s.Func, one)
+	case *ast.ReturnStmt:
+		p.token(s.Return, "return")
+		p.exprs(s.Return, s.Values, true)
+	default:
+		panic("format: unexpected statement")
+	}
+	p.indent, p.cont = indent, cont
+}
+
+// startsWithParen reports whether the statement s starts with "(", which
+// would continue a previous call statement.
+func startsWithParen(s ast.Stmt) bool {
+	var x ast.Expr
+	switch s := s.(type) {
+	case *ast.CallStmt:
+		x = s.Call
+	case *ast.AssignStmt:
+		x = s.Targets[0]
+	default:
+		return false
+	}
+	_, ok := leftmost(x).(*ast.ParenExpr)
+	return ok
+}
+
+//
+// Expressions
+//
+
+func (p *printer) ident(x *ast.Ident) { p.token(x.NamePos, x.Name) }
+
+func (p *printer) idents(list []*ast.Ident) {
+	for i, x := range list {
+		if i > 0 {
+			p.write(",")
+			p.space()
+		}
+		p.ident(x)
+	}
+}
+
+// exprs prints a list of expressions following a token which ends at after,
+// keeping the line breaks of the source; lead asks for a space before the
+// first expression, on the same line.
+func (p *printer) exprs(after ast.Pos, list []ast.Expr, lead bool) {
+	for i, x := range list {
+		if i > 0 {
+			p.write(",")
+			after = list[i-1].End()
+		}
+		if x.Pos().Line > after.Line {
+			p.contbreak(x.Pos())
+		} else if i > 0 || lead {
+			p.space()
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) expr(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		p.ident(x)
+	case *ast.NilLit:
+		p.token(x.Nil, "nil")
+	case *ast.BoolLit:
+		if x.Value {
+			p.token(x.ValuePos, "true")
+		} else {
+			p.token(x.ValuePos, "false")
+		}
+	case *ast.NumberLit:
+		p.token(x.ValuePos, x.Raw)
+	case *ast.StringLit:
+		p.token(x.ValuePos, quote(x.Raw))
+		p.last = x.EndPos.Line
+	case *ast.VarargLit:
+		p.token(x.Ellipsis, "...")
+	case *ast.FuncLit:
+		p.token(x.Function, "function")
+		p.funcBody(x, p.fits(x.EndPos))
+	case *ast.TableLit:
+		p.table(x)
+	case *ast.ParenExpr:
+		p.token(x.Lparen, "(")
+		p.expr(x.X)
+		p.token(x.Rparen, ")")
+	case *ast.UnaryExpr:
+		p.token(x.OpPos, ast.OpString(x.Op))
+		if u, ok := leftmost(x.X).(*ast.UnaryExpr); x.Op == code.OpNot || ok && x.Op == code.OpMinus && u.Op == code.OpMinus {
+			p.space() // not "--", a comment
+		}
+		p.expr(x.X)
+	case *ast.BinaryExpr:
+		p.expr(x.X)
+		if x.OpPos.Line > x.X.End().Line {
+			p.contbreak(x.OpPos)
+		} else {
+			p.space()
+		}
+		p.token(x.OpPos, ast.OpString(x.Op))
+		if x.Y.Pos().Line > x.OpPos.Line {
+			p.contbreak(x.Y.Pos())
+		} else {
+			p.space()
+		}
+		p.expr(x.Y)
+	case *ast.FieldExpr:
+		p.expr(x.X)
+		p.write(".")
+		p.ident(x.Name)
+	case *ast.IndexExpr:
+		p.expr(x.X)
+		p.token(x.Lbrack, "[")
+		p.index(x.Index)
+		p.token(x.Rbrack, "]")
+	case *ast.CallExpr:
+		p.call(x)
+	default:
+		panic("format: unexpected expression")
+	}
+}
+
+// funcBody prints the parameters and the body of a function, on a single
+// line if one is set.
+func (p *printer) funcBody(f *ast.FuncLit, one bool) {
+	p.write("(")
+	p.idents(f.Params)
+	if f.Ellipsis.IsValid() {
+		if len(f.Params) > 0 {
+			p.write(",")
+			p.space()
+		}
+		p.token(f.Ellipsis, "...")
+	}
+	p.write(")")
+	p.body(f.Body, one)
+	p.token(f.EndPos, "end")
+}
+
+func (p *printer) call(x *ast.CallExpr) {
+	p.expr(x.Fn)
+	if x.Method != nil {
+		if x.Method.NamePos.Line > x.Fn.End().Line {
+			p.contbreak(x.Method.NamePos)
+		}
+		p.write(":")
+		p.ident(x.Method)
+	}
+	if !x.Lparen.IsValid() { // f"str" or f{fields}
+		p.space()
+		p.expr(x.Args[0])
+		return
+	}
+	p.token(x.Lparen, "(")
+	indent, cont := p.indent, p.cont
+	p.cont = false
+	p.exprs(x.Lparen, x.Args, false)
+	if n := len(x.Args); n > 0 && x.Rparen.Line > x.Args[n-1].End().Line {
+		p.flush(x.Rparen)
+		p.indent = indent
+		if !p.col0 {
+			p.newline()
+		}
+	}
+	p.indent, p.cont = indent, cont
+	p.token(x.Rparen, ")")
+}
+
+// table prints a table constructor, on several lines if its braces are on
+// different lines, or if it has comments, with a field separator after each
+// field; empty tables are printed as "{}".
+func (p *printer) table(t *ast.TableLit) {
+	p.token(t.Lbrace, "{")
+	if !p.before(t.Rbrace) && (p.last == t.Rbrace.Line || len(t.Fields) == 0) {
+		for i, f := range t.Fields {
+			if i > 0 {
+				p.write(",")
+				p.space()
+			}
+			p.field(f)
+		}
+		p.token(t.Rbrace, "}")
+		return
+	}
+	indent, cont := p.indent, p.cont
+	p.opened = true
+	for i, f := range t.Fields {
+		p.indent, p.cont = indent+1, false
+		if i == 0 || f.Pos().Line > t.Fields[i-1].End().Line {
+			p.linebreak(f.Pos())
+		} else {
+			p.space()
+		}
+		p.field(f)
+		p.write(",")
+	}
+	p.indent, p.cont = indent+1, false
+	p.flush(t.Rbrace)
+	p.indent, p.cont = indent, cont
+	if !p.col0 {
+		p.newline()
+	}
+	p.token(t.Rbrace, "}")
+}
+
+func (p *printer) field(f *ast.Field) {
+	switch {
+	case f.Lbrack.IsValid():
+		p.token(f.Lbrack, "[")
+		p.index(f.Key)
+		p.write("]")
+	case f.Key != nil:
+		p.expr(f.Key)
+	default:
+		p.expr(f.Value)
+		return
+	}
+	p.space()
+	p.write("=")
+	p.exprs(f.Key.End(), []ast.Expr{f.Value}, true)
+}
+
+// index prints the expression x between brackets, spaced if it starts with
+// a long bracket, which would otherwise open a long string.
+func (p *printer) index(x ast.Expr) {
+	s, long := leftmost(x).(*ast.StringLit)
+	long = long && s.Raw[0] == '['
+	if long {
+		p.space()
+	}
+	p.expr(x)
+	if long {
+		p.space()
+	}
+}
+
+// leftmost returns the expression starting x.
+func leftmost(x ast.Expr) ast.Expr {
+	for {
+		switch y := x.(type) {
+		case *ast.BinaryExpr:
+			x = y.X
+		case *ast.FieldExpr:
+			x = y.X
+		case *ast.IndexExpr:
+			x = y.X
+		case *ast.CallExpr:
+			x = y.Fn
+		default:
+			return x
+		}
+	}
+}
+
+// quote returns the source text raw of a string constant with double
+// quotes, unless the string has unescaped double quotes.
+func quote(raw string) string {
+	if raw[0] != '\'' {
+		return raw
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 1; i < len(raw)-1; i++ {
+		switch c := raw[i]; c {
+		case '"':
+			return raw
+		case '\\':
+			if raw[i+1] != '\'' {
+				b.WriteByte(c)
+			}
+			i++
+			b.WriteByte(raw[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isLong reports whether the comment text is a long comment.
+func isLong(text string) bool {
+	return strings.HasPrefix(text, "--[") && strings.HasPrefix(strings.TrimLeft(text[3:], "="), "[")
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/Azure/golua/lua/luac/ast"
 )
 
 const eof = -1
@@ -33,6 +35,27 @@ type scanner struct {
 	char   rune
 	line   int
 	last   int
+
+	// positions, for the syntax tree (see ParseString)
+	src     []byte  // source text
+	off     int     // offset of char
+	n       int     // number of bytes read
+	lineOff int     // offset of the current line
+	start   ast.Pos // start of the token being scanned
+	off0    int     // offset of start
+	lastEnd ast.Pos // end of the previous token
+
+	prevEnd ast.Pos // end of the last token scanned
+
+	keep     bool         // keep comments?
+	comments []commentTok // comments read, if keep
+}
+
+// commentTok is a comment (a tComment token), with the end of the token
+// scanned before it.
+type commentTok struct {
+	token
+	after ast.Pos
 }
 
 func (s *lexical) tok2str(char rune) string {
@@ -56,6 +79,7 @@ func (s *lexical) addline() {
 	if s.line++; s.line >= maxInt {
 		s.syntaxErr("chunk has too many lines")
 	}
+	s.lineOff = s.off
 }
 
 func (s *lexical) consume() { s.accept(s.char) }
@@ -66,13 +90,20 @@ func (s *lexical) accept(char rune) {
 }
 
 func (s *lexical) read() {
+	s.off = s.n
 	if c, err := s.source.ReadByte(); err != nil {
 		s.char = eof
 	} else {
 		s.char = rune(c)
+		s.n++
 	}
 }
 
+// here returns the position of the current character.
+func (s *scanner) here() ast.Pos {
+	return ast.Pos{Line: s.line, Column: s.off - s.lineOff + 1}
+}
+
 // check
 func (s *lexical) expect(tok rune) {
 	if s.token.char != tok {
@@ -113,9 +144,18 @@ func (s *lexical) save(char rune) {
 	}
 }
 
+// scan returns the next token, with its position.
 func (s *lexical) scan() token {
-	var comment bool
+	tok := s.lex()
+	tok.pos, tok.end = s.start, s.here()
+	tok.off, tok.endOff = s.off0, s.off
+	s.prevEnd = tok.end
+	return tok
+}
+
+func (s *lexical) lex() token {
 	for {
+		s.start, s.off0 = s.here(), s.off
 		if isNewLine(s.char) {
 			s.addline()
 			continue
@@ -133,7 +173,8 @@ func (s *lexical) scan() token {
 			}
 			if s.read(); s.char == '[' {
 				if sep := s.skipSep(); sep >= 0 {
-					_ = s.multiline(comment, sep)
+					_ = s.multiline(false, sep)
+					s.comment()
 					break
 				}
 				s.buffer.Reset()
@@ -141,6 +182,7 @@ func (s *lexical) scan() token {
 			for !isNewLine(s.char) && s.char != eof {
 				s.read()
 			}
+			s.comment()
 		case '[': // long string or simple '['
 			sep := s.skipSep()
 			if sep >= 0 {
@@ -227,6 +269,23 @@ func (s *lexical) scan() token {
 	}
 }
 
+// comment keeps the comment just read, if comments are kept.
+func (s *lexical) comment() {
+	if s.keep {
+		s.comments = append(s.comments, commentTok{
+			token: token{
+				char:   tComment,
+				sval:   string(s.src[s.off0:s.off]),
+				pos:    s.start,
+				end:    s.here(),
+				off:    s.off0,
+				endOff: s.off,
+			},
+			after: s.prevEnd,
+		})
+	}
+}
+
 func (s *lexical) peek() rune {
 	s.assert(s.peek0.char == tEOS)
 	s.peek0 = s.scan()
@@ -234,6 +293,7 @@ func (s *lexical) peek() rune {
 }
 
 func (s *lexical) next() bool {
+	s.lastEnd = s.token.end
 	if s.last = s.line; s.peek0.char != tEOS {
 		s.token = s.peek0
 		s.peek0.char = tEOS
@@ -249,7 +309,8 @@ func (s *scanner) init(name string, input interface{}) *scanner {
 		panic(err)
 	}
 	// s.source = skipComment(bufio.NewReader(b))
-	s.source = bufio.NewReader(b)
+	s.src = b.Bytes()
+	s.source = bufio.NewReader(bytes.NewReader(s.src))
 	s.peek0.char = tEOS
 	s.buffer.Reset()
 	s.name = name
@@ -507,9 +568,11 @@ func (s *lexical) multiline(comment bool, sep int) (str string) {
 func skipComment(s *scanner) *scanner {
 	if b, err := s.source.ReadByte(); err == nil {
 		if b == '#' {
-			s.source.ReadString('\n')
+			line, _ := s.source.ReadString('\n')
 			s.line++
 			s.last++
+			s.n += 1 + len(line)
+			s.lineOff = s.n
 		} else {
 			s.source.UnreadByte()
 		}
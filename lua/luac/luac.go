@@ -1,7 +1,9 @@
 package luac
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/Azure/golua/lua/code"
@@ -14,23 +16,56 @@ var Defaults = &Config{}
 
 type Config struct{}
 
-func Compile(config *Config, file string, src interface{}) (chunk *code.Chunk, err error) {
-	defer func(err *error) {
-		if r := recover(); r != nil {
-			if e, ok := r.(code.Error); ok {
-				*err = e
-				return
-			}
-			panic(r)
+// Compile compiles the chunk named file, whose source is a string, a []byte
+// or an io.Reader (nil to read the file).
+//
+// Compilation stops at the first error, but the source is then parsed for
+// other syntax errors: the error is a Diagnostics, whose first diagnostic
+// is the error reported by Lua.
+func Compile(config *Config, file string, src interface{}) (*code.Chunk, error) {
+	b, err := readSource(file, src)
+	if err != nil {
+		return nil, err
+	}
+	var d *Diagnostic
+	if fn := compile(file, b.Bytes(), &d); d == nil {
+		return &code.Chunk{Main: fn}, nil
+	}
+	list := Diagnostics{d}
+	_, more := parse(file, b.Bytes())
+	for _, e := range more {
+		if d.Pos.Before(e.Pos) {
+			list = append(list, e)
 		}
-	}(&err)
+	}
+	return nil, list
+}
+
+func compile(file string, src []byte, d **Diagnostic) *code.Proto {
+	defer catch(d)
 	ls := &lexical{scanner: new(scanner).init(file, src)}
-	fn := new(parser).mainFunc(ls)
 	// TODO: assert
-	return &code.Chunk{fn}, err
+	return new(parser).mainFunc(ls)
 }
 
+// Bundle compiles the files ("-" for stdin) into a single chunk, whose main
+// function calls the main function of each file in turn, like luac does
+// with several inputs; binary chunks are undumped.
 func Bundle(config *Config, files []string) (*code.Chunk, error) {
+	chunks := make([]*code.Chunk, len(files))
+	for i, file := range files {
+		chunk, err := compileFile(config, file)
+		if err != nil {
+			return nil, err
+		}
+		chunks[i] = chunk
+	}
+	return Combine(chunks...), nil
+}
+
+// Combine returns a chunk whose main function calls the main function of
+// each chunk in turn (see Bundle); a single chunk is returned as is.
+func Combine(chunks ...*code.Chunk) *code.Chunk {
 	bundle := &code.Proto{
 		UpVars: []*code.UpVar{
 			&code.UpVar{
@@ -43,18 +78,14 @@ func Bundle(config *Config, files []string) (*code.Chunk, error) {
 		Vararg: true,
 		StackN: 2,
 	}
-	if len(files) == 1 {
-		return Compile(config, "@"+files[0], nil)
+	if len(chunks) == 1 {
+		return chunks[0]
 	}
-	for _, file := range files {
-		fn, err := Compile(config, "@"+file, nil)
-		if err != nil {
-			return nil, err
+	for _, chunk := range chunks {
+		if len(chunk.Main.UpVars) > 0 {
+			chunk.Main.UpVars[0].Stack = false
 		}
-		if len(fn.Main.UpVars) > 0 {
-			fn.Main.UpVars[0].Stack = false
-		}
-		bundle.Protos = append(bundle.Protos, fn.Main)
+		bundle.Protos = append(bundle.Protos, chunk.Main)
 	}
 	for pc := 0; pc < len(bundle.Protos); pc++ {
 		bundle.Instrs = append(bundle.Instrs,
@@ -65,7 +96,30 @@ func Bundle(config *Config, files []string) (*code.Chunk, error) {
 	}
 	bundle.Instrs = append(bundle.Instrs, code.MakeABC(code.RETURN, 0, 1, 0))
 	bundle.PcLine = append(bundle.PcLine, 1)
-	return &code.Chunk{bundle}, nil
+	return &code.Chunk{Main: bundle}
+}
+
+// compileFile compiles the Lua file ("-" for stdin), or undumps it if it
+// is a binary chunk.
+func compileFile(config *Config, file string) (*code.Chunk, error) {
+	var (
+		name = "@" + file
+		src  []byte
+		err  error
+	)
+	if file == "-" {
+		name = "=stdin"
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", file, err)
+	}
+	if bytes.HasPrefix(src, []byte(code.LUA_SIGNATURE)) {
+		return code.Undump(name, src)
+	}
+	return Compile(config, name, src)
 }
 
 func Must(chunk *code.Chunk, err error) *code.Chunk {
@@ -235,7 +235,7 @@ func (p *parser) labelstmt(ls *lexical, name string, line int) {
 	// check for repeated labels in the same block.
 	for i := ls.fs.block.label0; i < len(ls.labels); i++ {
 		if lbl := ls.labels[i]; lbl.label == name {
-			msg := fmt.Sprintf("label '%s' already defined on line %d", lbl.line)
+			msg := fmt.Sprintf("label '%s' already defined on line %d", name, lbl.line)
 			ls.semanticErr(msg)
 		}
 	}
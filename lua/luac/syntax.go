@@ -0,0 +1,659 @@
+package luac
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// ParseFile parses the Lua file and returns its syntax tree (see
+// ParseString).
+func ParseFile(file string) (*ast.Chunk, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", file, err)
+	}
+	return ParseString("@"+file, string(src))
+}
+
+// ParseString parses the source of the chunk named name and returns its
+// syntax tree, with its comments. The syntax errors are those reported by
+// Compile; semantic errors such as undefined labels are not detected.
+//
+// The parser recovers from syntax errors, skipping to the next statement,
+// so that all the syntax errors are reported in the returned Diagnostics;
+// the statements skipped are ast.BadStmt nodes of the returned tree.
+func ParseString(name, src string) (*ast.Chunk, error) {
+	chunk, list := parse(name, []byte(src))
+	return chunk, list.err()
+}
+
+func parse(name string, src []byte) (*ast.Chunk, Diagnostics) {
+	ls := &lexical{scanner: new(scanner).init(name, src)}
+	ls.keep = true
+	p := new(syntax)
+	return p.chunk(ls), p.errors
+}
+
+// syntax is the parser of syntax trees: it follows the grammar of parser,
+// building nodes instead of generating code.
+type syntax struct {
+	level  int
+	vararg bool // is the current function vararg?
+	line0  int  // line of the current function (0 for the main function)
+	errors Diagnostics
+}
+
+// try calls f, and returns its error, if any, after reporting it.
+func (p *syntax) try(ls *lexical, f func()) (d *Diagnostic) {
+	defer func() {
+		if d != nil {
+			ls.buffer.Reset() // (after a lexical error)
+			p.error(d)
+		}
+	}()
+	defer catch(&d)
+	f()
+	return nil
+}
+
+// error reports the error d, unless an error was already reported on the
+// same line (errors after the first one are often caused by it).
+func (p *syntax) error(d *Diagnostic) {
+	if n := len(p.errors); n == 0 || p.errors[n-1].Line != d.Line {
+		p.errors = append(p.errors, d)
+	}
+}
+
+// skip skips the current token.
+func (p *syntax) skip(ls *lexical) { p.try(ls, func() { ls.next() }) }
+
+func (p *syntax) enterLevel(ls *lexical) {
+	if p.level++; p.level > maxCalls {
+		ls.limitErrAt(p.line0, maxCalls, "Go levels")
+	}
+}
+
+func (p *syntax) leaveLevel(ls *lexical) { p.level-- }
+
+// mainfunc
+func (p *syntax) chunk(ls *lexical) *ast.Chunk {
+	p.vararg = true
+	for p.try(ls, func() { ls.next() }) != nil {
+	}
+	block := p.block(ls)
+	block.Start = ast.Pos{Line: 1, Column: 1}
+	for p.try(ls, func() { ls.expect(tEOS) }) != nil {
+		p.skip(ls) // unmatched 'end' etc.
+		more := p.block(ls)
+		block.Stmts = append(block.Stmts, more.Stmts...)
+		block.EndPos = more.EndPos
+	}
+	return &ast.Chunk{
+		Name:     ls.name,
+		Block:    block,
+		Comments: commentGroups(ls.comments),
+		EOF:      ls.token.pos,
+	}
+}
+
+// commentGroups groups the comments: comments are in the same group if
+// they are on adjacent lines, with no token between them. A comment on the
+// line of the token before it starts a group of the comments on that line.
+func commentGroups(comments []commentTok) (groups []*ast.CommentGroup) {
+	var last commentTok
+	for i, c := range comments {
+		var (
+			g    *ast.CommentGroup
+			text = &ast.Comment{Start: c.pos, Text: c.sval, EndPos: c.end}
+		)
+		if i > 0 && c.after == last.after {
+			g = groups[len(groups)-1]
+			first := g.List[0]
+			if first.Start.Line == c.after.Line {
+				if c.pos.Line != last.end.Line {
+					g = nil
+				}
+			} else if c.pos.Line > last.end.Line+1 {
+				g = nil
+			}
+		}
+		if g == nil {
+			g = new(ast.CommentGroup)
+			groups = append(groups, g)
+		}
+		g.List = append(g.List, text)
+		last = c
+	}
+	return groups
+}
+
+//
+// Statements
+//
+
+// block_follow
+func (p *syntax) follows(ls *lexical, until bool) bool {
+	switch ls.token.char {
+	case tElse, tElseIf, tEnd, tEOS:
+		return true
+	case tUntil:
+		return until
+	}
+	return false
+}
+
+// block -> statlist
+func (p *syntax) block(ls *lexical) *ast.Block {
+	b := &ast.Block{Start: ls.lastEnd}
+	for !p.follows(ls, true) {
+		if ls.token.char == tReturn {
+			b.Stmts = append(b.Stmts, p.statement(ls))
+			break // 'return' must be last statement
+		}
+		b.Stmts = append(b.Stmts, p.statement(ls))
+	}
+	b.EndPos = ls.token.pos
+	return b
+}
+
+// statement parses a statement; after a syntax error, it skips to the
+// next statement, and returns an *ast.BadStmt.
+func (p *syntax) statement(ls *lexical) (stmt ast.Stmt) {
+	var (
+		level, vararg, line0 = p.level, p.vararg, p.line0
+		from                 = ls.token
+	)
+	d := p.try(ls, func() { stmt = p.stmt(ls) })
+	if d == nil {
+		return stmt
+	}
+	p.level, p.vararg, p.line0 = level, vararg, line0
+	// after a lexical error, the current token is the one before the error
+	if d.Pos != ls.token.pos || ls.token.off == from.off {
+		p.skip(ls)
+	}
+	for !p.follows(ls, true) && !p.resumes(ls) {
+		p.skip(ls)
+	}
+	return &ast.BadStmt{From: from.pos, To: ls.token.pos}
+}
+
+// resumes reports whether the parser can resume at the current token,
+// after a syntax error: the token starts a statement.
+func (p *syntax) resumes(ls *lexical) bool {
+	switch ls.token.char {
+	case tBreak, tDo, tFor, tFunction, tGoto, tIf, tLocal, tRepeat, tReturn, tWhile, tColon2, ';':
+		return true
+	case tName: // (a name at the start of a line)
+		return ls.lastEnd.Line < ls.token.pos.Line
+	}
+	return false
+}
+
+// statement
+func (p *syntax) stmt(ls *lexical) (stmt ast.Stmt) {
+	p.enterLevel(ls)
+	pos, line := ls.token.pos, ls.line
+
+	switch ls.token.char {
+	case tBreak:
+		ls.next()
+		stmt = &ast.BreakStmt{Break: pos}
+	case tGoto:
+		ls.next()
+		stmt = &ast.GotoStmt{Goto: pos, Label: p.ident(ls)}
+	case tFunction:
+		stmt = p.funcstmt(ls, line)
+	case tReturn:
+		stmt = p.retstmt(ls)
+	case tColon2:
+		ls.next()
+		name := p.ident(ls)
+		ls.expect(tColon2)
+		stmt = &ast.LabelStmt{Start: pos, Name: name, EndPos: ls.token.pos}
+		ls.next()
+	case tRepeat:
+		ls.next()
+		body := p.block(ls)
+		until := ls.token.pos
+		ls.match(tUntil, tRepeat, line)
+		stmt = &ast.RepeatStmt{Repeat: pos, Body: body, Until: until, Cond: p.expr(ls)}
+	case tWhile:
+		ls.next()
+		cond := p.expr(ls)
+		ls.expect(tDo)
+		ls.next()
+		body := p.block(ls)
+		end := ls.token.pos
+		ls.match(tEnd, tWhile, line)
+		stmt = &ast.WhileStmt{While: pos, Cond: cond, Body: body, EndPos: end}
+	case tLocal:
+		if ls.next(); ls.token.char == tFunction {
+			stmt = p.localfunc(ls, pos)
+		} else {
+			stmt = p.localstmt(ls, pos)
+		}
+	case tFor:
+		stmt = p.forloop(ls, line)
+	case tDo:
+		ls.next()
+		body := p.block(ls)
+		end := ls.token.pos
+		ls.match(tEnd, tDo, line)
+		stmt = &ast.DoStmt{Do: pos, Body: body, EndPos: end}
+	case tIf:
+		stmt = p.ifstmt(ls, line)
+	case ';':
+		ls.next()
+		stmt = &ast.EmptyStmt{Semi: pos}
+	default:
+		stmt = p.exprstmt(ls)
+	}
+	p.leaveLevel(ls)
+	return stmt
+}
+
+// stat -> func | assignment
+func (p *syntax) exprstmt(ls *lexical) ast.Stmt {
+	x := p.suffixed(ls)
+	if ls.token.char == '=' || ls.token.char == ',' {
+		// stat -> assignment
+		return p.assignment(ls, x)
+	}
+	// stat -> func
+	call, ok := x.(*ast.CallExpr)
+	if !ok {
+		ls.syntaxErr("syntax error")
+	}
+	return &ast.CallStmt{Call: call}
+}
+
+// assignment -> suffixedexp { ',' suffixedexp } '=' explist
+func (p *syntax) assignment(ls *lexical, x ast.Expr) ast.Stmt {
+	stmt := new(ast.AssignStmt)
+	for {
+		if !isVar(x) {
+			ls.syntaxErr("syntax error")
+		}
+		stmt.Targets = append(stmt.Targets, x)
+		if !ls.test(',') {
+			break
+		}
+		x = p.suffixed(ls)
+		if len(stmt.Targets)+p.level > maxCalls {
+			ls.limitErrAt(p.line0, maxCalls, "Go levels")
+		}
+	}
+	ls.expect('=')
+	ls.next()
+	stmt.Values = p.exprs(ls)
+	return stmt
+}
+
+// isVar reports whether x can be assigned.
+func isVar(x ast.Expr) bool {
+	switch x.(type) {
+	case *ast.Ident, *ast.FieldExpr, *ast.IndexExpr:
+		return true
+	}
+	return false
+}
+
+// funcstat -> FUNCTION funcname body
+//
+// funcname -> NAME {fieldsel} [':' NAME]
+func (p *syntax) funcstmt(ls *lexical, line int) ast.Stmt {
+	var (
+		stmt = new(ast.FuncStmt)
+		pos  = ls.token.pos
+	)
+	ls.next() // skip FUNCTION
+	for stmt.Name = p.ident(ls); ls.test('.'); {
+		stmt.Name = &ast.FieldExpr{X: stmt.Name, Name: p.ident(ls)}
+	}
+	if ls.test(':') {
+		stmt.Method = p.ident(ls)
+	}
+	stmt.Func = p.funcbody(ls, pos, line)
+	return stmt
+}
+
+// stat -> LOCAL FUNCTION NAME body
+func (p *syntax) localfunc(ls *lexical, pos ast.Pos) ast.Stmt {
+	fn := ls.token.pos
+	ls.next() // skip FUNCTION
+	name := p.ident(ls)
+	return &ast.LocalFuncStmt{Local: pos, Name: name, Func: p.funcbody(ls, fn, ls.line)}
+}
+
+// stat -> LOCAL NAME {',' NAME} ['=' explist]
+func (p *syntax) localstmt(ls *lexical, pos ast.Pos) ast.Stmt {
+	stmt := &ast.LocalStmt{Local: pos}
+	for {
+		stmt.Names = append(stmt.Names, p.ident(ls))
+		if !ls.test(',') {
+			break
+		}
+	}
+	if ls.test('=') {
+		stmt.Values = p.exprs(ls)
+	}
+	return stmt
+}
+
+// body -> '(' parlist ')' block END
+//
+// parlist -> [ param { ',' param } ]
+func (p *syntax) funcbody(ls *lexical, pos ast.Pos, line int) *ast.FuncLit {
+	fn := &ast.FuncLit{Function: pos}
+	vararg, line0 := p.vararg, p.line0
+	p.vararg, p.line0 = false, line
+	ls.expect('(')
+	ls.next()
+	if ls.token.char != ')' { // is parameter list not empty?
+		for !p.vararg {
+			switch ls.token.char {
+			case tName: // parameter
+				fn.Params = append(fn.Params, p.ident(ls))
+			case tDots: // vararg
+				fn.Ellipsis = ls.token.pos
+				ls.next()
+				p.vararg = true
+			default:
+				ls.syntaxErr("<name> or '...' expected")
+			}
+			if !ls.test(',') {
+				break
+			}
+		}
+	}
+	ls.expect(')')
+	ls.next()
+	fn.Body = p.block(ls)
+	fn.EndPos = ls.token.pos
+	ls.match(tEnd, tFunction, line)
+	p.vararg, p.line0 = vararg, line0
+	return fn
+}
+
+// stat -> RETURN [explist] [';']
+func (p *syntax) retstmt(ls *lexical) ast.Stmt {
+	stmt := &ast.ReturnStmt{Return: ls.token.pos}
+	ls.next() // skip RETURN
+	if !p.follows(ls, true) && ls.token.char != ';' {
+		stmt.Values = p.exprs(ls) // optional return values
+	}
+	ls.test(';') // skip optional semicolon
+	return stmt
+}
+
+// ifstat -> IF cond THEN block {ELSEIF cond THEN block} [ELSE block] END
+func (p *syntax) ifstmt(ls *lexical, line int) ast.Stmt {
+	stmt := new(ast.IfStmt)
+	for {
+		clause := &ast.IfClause{Keyword: ls.token.pos}
+		ls.next() // skip IF or ELSEIF
+		clause.Cond = p.expr(ls)
+		ls.expect(tThen)
+		ls.next()
+		clause.Body = p.block(ls)
+		stmt.Clauses = append(stmt.Clauses, clause)
+		if ls.token.char != tElseIf {
+			break
+		}
+	}
+	if pos := ls.token.pos; ls.test(tElse) {
+		stmt.Clauses = append(stmt.Clauses, &ast.IfClause{Keyword: pos, Body: p.block(ls)})
+	}
+	stmt.EndPos = ls.token.pos
+	ls.match(tEnd, tIf, line)
+	return stmt
+}
+
+// forstat -> FOR (fornum | forlist) END
+//
+// fornum -> NAME = exp1,exp1[,exp1] forbody
+// forlist -> NAME {,NAME} IN explist forbody
+func (p *syntax) forloop(ls *lexical, line int) (stmt ast.Stmt) {
+	pos := ls.token.pos
+	ls.next() // skip 'for'
+	switch name := p.ident(ls); ls.token.char {
+	case ',', tIn:
+		loop := &ast.GenericForStmt{For: pos, Names: []*ast.Ident{name}}
+		for ls.test(',') {
+			loop.Names = append(loop.Names, p.ident(ls))
+		}
+		ls.expect(tIn)
+		ls.next()
+		loop.Exprs = p.exprs(ls)
+		loop.Body = p.forbody(ls)
+		loop.EndPos, stmt = ls.token.pos, loop
+	case '=':
+		loop := &ast.NumericForStmt{For: pos, Var: name}
+		ls.next()
+		loop.Start = p.expr(ls)
+		ls.expect(',')
+		ls.next()
+		loop.Limit = p.expr(ls)
+		if ls.test(',') {
+			loop.Step = p.expr(ls)
+		}
+		loop.Body = p.forbody(ls)
+		loop.EndPos, stmt = ls.token.pos, loop
+	default:
+		ls.syntaxErr("'=' or 'in' expected")
+	}
+	ls.match(tEnd, tFor, line)
+	return stmt
+}
+
+// forbody -> DO block
+func (p *syntax) forbody(ls *lexical) *ast.Block {
+	ls.expect(tDo)
+	ls.next()
+	return p.block(ls)
+}
+
+//
+// Expressions
+//
+
+// explist -> expr { ',' expr }
+func (p *syntax) exprs(ls *lexical) []ast.Expr {
+	list := []ast.Expr{p.expr(ls)} // at least one expression
+	for ls.test(',') {
+		list = append(list, p.expr(ls))
+	}
+	return list
+}
+
+func (p *syntax) expr(ls *lexical) ast.Expr {
+	x, _ := p.subexpr(ls, 0)
+	return x
+}
+
+// subexpr -> (simpleexp | unop subexpr) { binop subexpr }
+//
+// where 'binop' is any binary operator with a priority higher than 'limit'
+func (p *syntax) subexpr(ls *lexical, limit int) (x ast.Expr, op code.Op) {
+	p.enterLevel(ls)
+	if uop := unaryOp(ls.token.char); uop != code.OpNone {
+		pos := ls.token.pos
+		ls.next()
+		y, _ := p.subexpr(ls, unaryPriority)
+		x = &ast.UnaryExpr{OpPos: pos, Op: uop, X: y}
+	} else {
+		x = p.simple(ls)
+	}
+	// expand while operators have priorities higher than 'limit'
+	op = binaryOp(ls.token.char)
+	for op != code.OpNone && priority[op-1].lhs > limit {
+		pos := ls.token.pos
+		ls.next()
+		// read sub-expression with higher priority
+		y, nextop := p.subexpr(ls, priority[op-1].rhs)
+		x = &ast.BinaryExpr{X: x, OpPos: pos, Op: op, Y: y}
+		op = nextop
+	}
+	p.leaveLevel(ls)
+	return x, op // return first untreated operator
+}
+
+// simpleexp -> FLT | INT | STRING | NIL | TRUE | FALSE | ... | constructor | FUNCTION body | suffixedexp
+func (p *syntax) simple(ls *lexical) (x ast.Expr) {
+	switch tok := ls.token; tok.char {
+	case tFunction:
+		ls.next()
+		return p.funcbody(ls, tok.pos, ls.line)
+	case tString:
+		x = &ast.StringLit{ValuePos: tok.pos, Raw: ls.text(tok), Value: tok.sval, EndPos: tok.end}
+	case tFloat:
+		x = &ast.NumberLit{ValuePos: tok.pos, Raw: ls.text(tok), Value: tok.nval}
+	case tInt:
+		x = &ast.NumberLit{ValuePos: tok.pos, Raw: ls.text(tok), Value: tok.ival}
+	case tFalse, tTrue:
+		x = &ast.BoolLit{ValuePos: tok.pos, Value: tok.char == tTrue}
+	case tNil:
+		x = &ast.NilLit{Nil: tok.pos}
+	case tDots:
+		if !p.vararg {
+			ls.syntaxErr("cannot use '...' outside a vararg function")
+		}
+		x = &ast.VarargLit{Ellipsis: tok.pos}
+	case '{':
+		return p.constructor(ls)
+	default:
+		return p.suffixed(ls)
+	}
+	ls.next()
+	return x
+}
+
+// suffixedexp -> primaryexp { '.' NAME | '[' exp ']' | ':' NAME funcargs | funcargs }
+func (p *syntax) suffixed(ls *lexical) ast.Expr {
+	line := ls.line
+	x := p.primary(ls)
+	for {
+		switch ls.token.char {
+		case '(', tString, '{':
+			x = p.arguments(ls, &ast.CallExpr{Fn: x}, line)
+		case '[':
+			index := &ast.IndexExpr{X: x, Lbrack: ls.token.pos}
+			ls.next() // skip the '['
+			index.Index = p.expr(ls)
+			ls.expect(']')
+			index.Rbrack = ls.token.pos
+			ls.next()
+			x = index
+		case ':':
+			ls.next()
+			x = p.arguments(ls, &ast.CallExpr{Fn: x, Method: p.ident(ls)}, line)
+		case '.':
+			ls.next()
+			x = &ast.FieldExpr{X: x, Name: p.ident(ls)}
+		default:
+			return x
+		}
+	}
+}
+
+// primaryexp -> NAME | '(' expr ')'
+func (p *syntax) primary(ls *lexical) ast.Expr {
+	switch ls.token.char {
+	case tName:
+		return p.ident(ls)
+	case '(':
+		var (
+			line = ls.line
+			x    = &ast.ParenExpr{Lparen: ls.token.pos}
+		)
+		ls.next()
+		x.X = p.expr(ls)
+		x.Rparen = ls.token.pos
+		ls.match(')', '(', line)
+		return x
+	default:
+		ls.syntaxErr("unexpected symbol")
+	}
+	panic("unreachable")
+}
+
+// funcargs -> '(' [ explist ] ')' | constructor | STRING
+func (p *syntax) arguments(ls *lexical, call *ast.CallExpr, line int) ast.Expr {
+	switch ls.token.char {
+	case tString:
+		call.Args = []ast.Expr{p.simple(ls)}
+	case '(':
+		call.Lparen = ls.token.pos
+		if ls.next(); ls.token.char != ')' {
+			call.Args = p.exprs(ls)
+		}
+		call.Rparen = ls.token.pos
+		ls.match(')', '(', line)
+	case '{':
+		call.Args = []ast.Expr{p.constructor(ls)}
+	default:
+		ls.syntaxErr("function arguments expected")
+	}
+	return call
+}
+
+// constructor -> '{' [ field { sep field } [sep] ] '}' sep -> ',' | ';
+func (p *syntax) constructor(ls *lexical) ast.Expr {
+	var (
+		line = ls.line
+		t    = &ast.TableLit{Lbrace: ls.token.pos}
+	)
+	ls.expect('{')
+	ls.next()
+	for ls.token.char != '}' {
+		t.Fields = append(t.Fields, p.field(ls))
+		if !ls.test(',') && !ls.test(';') {
+			break
+		}
+	}
+	t.Rbrace = ls.token.pos
+	ls.match('}', '{', line)
+	return t
+}
+
+// field -> listfield | recfield
+//
+// recfield -> (NAME | '['exp1']') = exp1
+func (p *syntax) field(ls *lexical) *ast.Field {
+	f := new(ast.Field)
+	switch ls.token.char {
+	case tName: // may be 'listfield' or 'recfield'
+		if ls.peek() != '=' { // expression?
+			f.Value = p.expr(ls)
+			return f
+		}
+		f.Key = p.ident(ls)
+	case '[':
+		f.Lbrack = ls.token.pos
+		ls.next() // skip the '['
+		f.Key = p.expr(ls)
+		ls.expect(']')
+		ls.next()
+	default:
+		f.Value = p.expr(ls)
+		return f
+	}
+	ls.expect('=')
+	ls.next()
+	f.Value = p.expr(ls)
+	return f
+}
+
+func (p *syntax) ident(ls *lexical) *ast.Ident {
+	ls.expect(tName)
+	x := &ast.Ident{NamePos: ls.token.pos, Name: ls.token.sval}
+	ls.next()
+	return x
+}
+
+// text returns the source text of the token.
+func (s *scanner) text(tok token) string { return string(s.src[tok.off:tok.endOff]) }
@@ -0,0 +1,149 @@
+package luac_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/golua/lua/code"
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// corpus returns the files of the tests in lua/test/lua.
+func corpus(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob("../test/lua/*.lua")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no tests in lua/test/lua: %v", err)
+	}
+	return files
+}
+
+// checkPositions checks that the nodes of tree have valid spans, nested
+// in the spans of their parents.
+func checkPositions(t *testing.T, name string, tree *ast.Chunk) {
+	t.Helper()
+	var stack []ast.Node
+	errs := 0
+	ast.Inspect(tree, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		pos, end := n.Pos(), n.End()
+		switch {
+		case !pos.IsValid() || end.Before(pos):
+			errs++
+			t.Errorf("%s: %T at %s: invalid span %s-%s", name, n, pos, pos, end)
+		case len(stack) > 0:
+			parent := stack[len(stack)-1]
+			if pos.Before(parent.Pos()) || parent.End().Before(end) {
+				errs++
+				t.Errorf("%s: %T at %s-%s not in %T at %s-%s", name, n, pos, end, parent, parent.Pos(), parent.End())
+			}
+		}
+		stack = append(stack, n)
+		return errs < 10
+	})
+}
+
+func TestParseCorpus(t *testing.T) {
+	for _, file := range corpus(t) {
+		tree, err := luac.ParseFile(file)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if tree.Name != "@"+file || len(tree.Block.Stmts) == 0 {
+			t.Errorf("%s: chunk %q with %d statements", file, tree.Name, len(tree.Block.Stmts))
+		}
+		checkPositions(t, file, tree)
+	}
+}
+
+func TestParse(t *testing.T) {
+	const src = `-- doc
+local t = {1, x = "a", [2] = 3.5} -- comment
+function t.f(a, ...)
+  return a + #t * -2, ...
+end
+for i = 1, 10, 2 do t:m(i) end
+::done::`
+	tree, err := luac.ParseString("=test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := tree.Block.Stmts
+	if len(stmts) != 4 {
+		t.Fatalf("got %d statements, want 4", len(stmts))
+	}
+	checkPositions(t, "test", tree)
+
+	local, ok := stmts[0].(*ast.LocalStmt)
+	if !ok || len(local.Names) != 1 || local.Names[0].Name != "t" || local.Pos() != (ast.Pos{Line: 2, Column: 1}) {
+		t.Fatalf("statement 1: got %#v", stmts[0])
+	}
+	table := local.Values[0].(*ast.TableLit)
+	if len(table.Fields) != 3 || table.Pos() != (ast.Pos{Line: 2, Column: 11}) || table.End() != (ast.Pos{Line: 2, Column: 34}) {
+		t.Errorf("table: %d fields at %s-%s", len(table.Fields), table.Pos(), table.End())
+	}
+	if f := table.Fields[1]; f.Key.(*ast.Ident).Name != "x" || f.Value.(*ast.StringLit).Value != "a" {
+		t.Errorf("field 2: %#v", f)
+	}
+	if f := table.Fields[2]; !f.Lbrack.IsValid() || f.Key.(*ast.NumberLit).Value != int64(2) || f.Value.(*ast.NumberLit).Value != 3.5 {
+		t.Errorf("field 3: %#v", f)
+	}
+
+	fn, ok := stmts[1].(*ast.FuncStmt)
+	if !ok || fn.Name.(*ast.FieldExpr).Name.Name != "f" || len(fn.Func.Params) != 1 || !fn.Func.Ellipsis.IsValid() || fn.End() != (ast.Pos{Line: 5, Column: 4}) {
+		t.Fatalf("statement 2: got %#v", stmts[1])
+	}
+	ret := fn.Func.Body.Stmts[0].(*ast.ReturnStmt)
+	sum := ret.Values[0].(*ast.BinaryExpr)
+	if sum.Op != code.OpAdd || len(ret.Values) != 2 {
+		t.Errorf("return: %#v", ret)
+	}
+	if mul, ok := sum.Y.(*ast.BinaryExpr); !ok || mul.Op != code.OpMul || mul.X.(*ast.UnaryExpr).Op != code.OpLen || mul.Y.(*ast.UnaryExpr).Op != code.OpMinus {
+		t.Errorf("precedence: got %#v", sum.Y)
+	}
+
+	loop, ok := stmts[2].(*ast.NumericForStmt)
+	if !ok || loop.Var.Name != "i" || loop.Step == nil {
+		t.Fatalf("statement 3: got %#v", stmts[2])
+	}
+	call := loop.Body.Stmts[0].(*ast.CallStmt).Call
+	if call.Method == nil || call.Method.Name != "m" || len(call.Args) != 1 {
+		t.Errorf("method call: %#v", call)
+	}
+	if label, ok := stmts[3].(*ast.LabelStmt); !ok || label.Name.Name != "done" {
+		t.Errorf("statement 4: got %#v", stmts[3])
+	}
+
+	if len(tree.Comments) != 2 || tree.Comments[0].Text() != "doc\n" || tree.Comments[1].Pos() != (ast.Pos{Line: 2, Column: 35}) {
+		t.Errorf("comments: %v", tree.Comments)
+	}
+}
+func TestParseErrors(t *testing.T) {
+	const src = "x = = 1\ny = 2\nlocal = 3\nz = 4\n"
+	tree, err := luac.ParseString("=test", src)
+	var list luac.Diagnostics
+	if !errors.As(err, &list) || len(list) != 2 {
+		t.Fatalf("got error %v, want 2 diagnostics", err)
+	}
+	if list[0].Line != 1 || list[1].Line != 3 {
+		t.Errorf("got errors on lines %d and %d, want 1 and 3", list[0].Line, list[1].Line)
+	}
+	if _, cerr := luac.Compile(luac.Defaults, "=test", src); cerr == nil || cerr.Error() != list[0].Error() {
+		t.Errorf("got %v, want the error of Compile %v", list[0], cerr)
+	}
+	var kinds []string
+	for _, s := range tree.Block.Stmts {
+		kinds = append(kinds, fmt.Sprintf("%T", s))
+	}
+	want := "[*ast.BadStmt *ast.AssignStmt *ast.BadStmt *ast.AssignStmt]"
+	if fmt.Sprint(kinds) != want {
+		t.Errorf("got statements %v, want %s", kinds, want)
+	}
+}
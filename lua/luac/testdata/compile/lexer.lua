@@ -0,0 +1,24 @@
+#!/usr/bin/env lua
+-- compiled by TestCompileUnchanged: every kind of token, comment and
+-- line break the lexer handles, so that line information is checked too
+local a, b = 1, 2 -- line comment
+--[[ long
+comment ]] local c = a + b
+--[==[ long comment with ]] and
+]=] inside ]==]
+local s1 = "double \"quoted\" \a\b\f\n\r\t\v\\ \x41\65\u{48}\u{7FF}\z
+            continued"
+local s2 = 'single \
+newline'
+local s3 = [[
+first newline skipped]]
+local s4 = [==[
+]] ]=] still in
+]==]
+local n = {0, 42, 0xff, 0XA, 3.0, 3., .5, 1e10, 2E-3, 0x1p4, 0x.8P-1, 0xA.8, 9007199254740993, 0x7fffffffffffffff, 0xffffffffffffffff}
+local ops = {a // b, a ^ b, a % b, a & b, a | b, a ~ b, ~a, a << 1, a >> 1, a .. b, #s1, a == b, a ~= b, a <= b, a >= b, not a}
+local t = {x = 1, ["y"] = 2; 3}
+::label:: goto done
+do local _ = ... end
+::done::
+return function(...) return t.x, t["y"], s1:upper(), ... end
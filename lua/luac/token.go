@@ -1,6 +1,10 @@
 package luac
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/Azure/golua/lua/luac/ast"
+)
 
 const reserved = 257
 
@@ -44,6 +48,7 @@ const (
 	tInt
 	tName
 	tString
+	tComment
 )
 
 var keywords = map[string]rune{
@@ -109,6 +114,7 @@ var tokens = []string{
 	"<integer>",
 	"<name>",
 	"<string>",
+	"<comment>",
 }
 
 type token struct {
@@ -116,6 +122,9 @@ type token struct {
 	ival int64
 	sval string
 	nval float64
+
+	pos, end    ast.Pos // position of the token, and just after it
+	off, endOff int     // offsets of pos and end
 }
 
 func (tok token) String() string {
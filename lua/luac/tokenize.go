@@ -0,0 +1,172 @@
+package luac
+
+import (
+	"bytes"
+
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+// TokenKind is the kind of a Token.
+type TokenKind int
+
+const (
+	EOF     TokenKind = iota // end of the chunk
+	Name                     // names, e.g. "print"
+	Keyword                  // reserved words, e.g. "while"
+	Int                      // integer constants, e.g. "0x10"
+	Float                    // float constants, e.g. "1e10"
+	String                   // string constants, quoted or long
+	Symbol                   // operators and punctuation, e.g. "..", "("
+	Comment                  // comments, including "--" (see Comments)
+	Space                    // white space, including new lines (see Spaces)
+	Invalid                  // text with a lexical error, e.g. "'abc"
+)
+
+var kinds = [...]string{
+	EOF:     "EOF",
+	Name:    "Name",
+	Keyword: "Keyword",
+	Int:     "Int",
+	Float:   "Float",
+	String:  "String",
+	Symbol:  "Symbol",
+	Comment: "Comment",
+	Space:   "Space",
+	Invalid: "Invalid",
+}
+
+func (kind TokenKind) String() string {
+	if 0 <= kind && int(kind) < len(kinds) {
+		return kinds[kind]
+	}
+	return "TokenKind(?)"
+}
+
+// A TokenMode controls which trivia are returned by Tokenize.
+type TokenMode uint
+
+const (
+	Comments TokenMode = 1 << iota // return the comments (and "#" first line)
+	Spaces                         // return the white space between tokens
+)
+
+// Token is a lexical token of a chunk.
+type Token struct {
+	Kind  TokenKind
+	Text  string      // source text of the token
+	Value interface{} // int64, float64 or string for Int, Float and String
+	Pos   ast.Pos     // position of the token
+	End   ast.Pos     // position just after the token
+}
+
+// Tokenize returns the tokens of the chunk named name, up to and including
+// the EOF token; the comments and white space are included as requested
+// by mode, so that the text of all the tokens returned with the mode
+// Comments|Spaces is the source of the chunk. The source may be a string,
+// a []byte or an io.Reader (see Compile).
+//
+// The text of lexical errors, such as unfinished strings, is returned as
+// Invalid tokens, and the errors as a Diagnostics.
+func Tokenize(name string, src interface{}, mode TokenMode) ([]Token, error) {
+	b, err := readSource(name, src)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ls = &lexical{scanner: new(scanner).init(name, b.Bytes())}
+		tz = &tokenizer{mode: mode, src: ls.src, pos: ast.Pos{Line: 1, Column: 1}}
+	)
+	ls.keep = true
+	if bytes.HasPrefix(tz.src, []byte("#")) { // first line skipped by the scanner
+		n := bytes.IndexByte(tz.src, '\n')
+		if n < 0 {
+			n = len(tz.src)
+		}
+		tz.add(Comment, token{pos: tz.pos, end: ast.Pos{Line: 1, Column: n + 1}, endOff: n})
+	}
+	for {
+		var d *Diagnostic
+		tok := tz.scan(ls, &d)
+		for _, c := range ls.comments {
+			tz.add(Comment, c.token)
+		}
+		ls.comments = ls.comments[:0]
+		if d != nil {
+			ls.buffer.Reset()
+			tz.errors = append(tz.errors, d)
+			tz.add(Invalid, token{pos: ls.start, end: ls.here(), off: ls.off0, endOff: ls.off})
+			continue
+		}
+		tz.add(kindOf(tok.char), tok)
+		if tok.char == tEOS {
+			return tz.toks, tz.errors.err()
+		}
+	}
+}
+
+// tokenizer collects the tokens returned by Tokenize.
+type tokenizer struct {
+	mode   TokenMode
+	src    []byte
+	toks   []Token
+	pos    ast.Pos // end of the previous token, or comment
+	off    int     // offset of pos
+	errors Diagnostics
+}
+
+func (tz *tokenizer) scan(ls *lexical, d **Diagnostic) token {
+	defer catch(d)
+	return ls.scan()
+}
+
+func (tz *tokenizer) add(kind TokenKind, tok token) {
+	if tz.mode&Spaces != 0 && tok.off > tz.off {
+		tz.toks = append(tz.toks, Token{
+			Kind: Space,
+			Text: string(tz.src[tz.off:tok.off]),
+			Pos:  tz.pos,
+			End:  tok.pos,
+		})
+	}
+	tz.pos, tz.off = tok.end, tok.endOff
+	if kind == Comment && tz.mode&Comments == 0 {
+		return
+	}
+	t := Token{
+		Kind: kind,
+		Text: string(tz.src[tok.off:tok.endOff]),
+		Pos:  tok.pos,
+		End:  tok.end,
+	}
+	switch kind {
+	case Int:
+		t.Value = tok.ival
+	case Float:
+		t.Value = tok.nval
+	case String:
+		t.Value = tok.sval
+	}
+	tz.toks = append(tz.toks, t)
+}
+
+func kindOf(char rune) TokenKind {
+	switch {
+	case char < reserved:
+		return Symbol
+	case char <= tWhile:
+		return Keyword
+	}
+	switch char {
+	case tEOS:
+		return EOF
+	case tFloat:
+		return Float
+	case tInt:
+		return Int
+	case tName:
+		return Name
+	case tString:
+		return String
+	}
+	return Symbol
+}
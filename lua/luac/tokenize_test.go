@@ -0,0 +1,183 @@
+package luac_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/luac"
+	"github.com/Azure/golua/lua/luac/ast"
+)
+
+func TestTokenizeCorpus(t *testing.T) {
+	for _, file := range corpus(t) {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		toks, err := luac.Tokenize("@"+file, src, luac.Comments|luac.Spaces)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		var (
+			text strings.Builder
+			pos  = ast.Pos{Line: 1, Column: 1}
+		)
+		for _, tok := range toks {
+			if tok.Pos != pos {
+				t.Errorf("%s: %s %q at %s, want %s", file, tok.Kind, tok.Text, tok.Pos, pos)
+				break
+			}
+			text.WriteString(tok.Text)
+			pos = tok.End
+		}
+		if text.String() != string(src) {
+			t.Errorf("%s: tokens do not add up to the source", file)
+		}
+		if last := toks[len(toks)-1]; last.Kind != luac.EOF {
+			t.Errorf("%s: last token is %s %q", file, last.Kind, last.Text)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	type tok struct {
+		Kind  luac.TokenKind
+		Text  string
+		Value interface{}
+	}
+	for _, test := range []struct {
+		src  string
+		mode luac.TokenMode
+		want []tok
+	}{
+		{"local x = 0x10", 0, []tok{
+			{luac.Keyword, "local", nil},
+			{luac.Name, "x", nil},
+			{luac.Symbol, "=", nil},
+			{luac.Int, "0x10", int64(16)},
+			{luac.EOF, "", nil},
+		}},
+		{`f("a\n", [[b]], 1e2, ...)`, 0, []tok{
+			{luac.Name, "f", nil},
+			{luac.Symbol, "(", nil},
+			{luac.String, `"a\n"`, "a\n"},
+			{luac.Symbol, ",", nil},
+			{luac.String, "[[b]]", "b"},
+			{luac.Symbol, ",", nil},
+			{luac.Float, "1e2", 100.0},
+			{luac.Symbol, ",", nil},
+			{luac.Symbol, "...", nil},
+			{luac.Symbol, ")", nil},
+			{luac.EOF, "", nil},
+		}},
+		{"a // b -- c\n", 0, []tok{
+			{luac.Name, "a", nil},
+			{luac.Symbol, "//", nil},
+			{luac.Name, "b", nil},
+			{luac.EOF, "", nil},
+		}},
+		{"a -- c\n--[[d]]", luac.Comments, []tok{
+			{luac.Name, "a", nil},
+			{luac.Comment, "-- c", nil},
+			{luac.Comment, "--[[d]]", nil},
+			{luac.EOF, "", nil},
+		}},
+		{"#!/bin/lua\nx", luac.Comments | luac.Spaces, []tok{
+			{luac.Comment, "#!/bin/lua", nil},
+			{luac.Space, "\n", nil},
+			{luac.Name, "x", nil},
+			{luac.EOF, "", nil},
+		}},
+		{" a\tb ", luac.Spaces, []tok{
+			{luac.Space, " ", nil},
+			{luac.Name, "a", nil},
+			{luac.Space, "\t", nil},
+			{luac.Name, "b", nil},
+			{luac.Space, " ", nil},
+			{luac.EOF, "", nil},
+		}},
+	} {
+		toks, err := luac.Tokenize("=test", test.src, test.mode)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		var got []tok
+		for _, t := range toks {
+			got = append(got, tok{t.Kind, t.Text, t.Value})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestTokenizePositions(t *testing.T) {
+	toks, err := luac.Tokenize("=test", "x =\n  [[a\nb]] y", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]ast.Pos{
+		{{Line: 1, Column: 1}, {Line: 1, Column: 2}},
+		{{Line: 1, Column: 3}, {Line: 1, Column: 4}},
+		{{Line: 2, Column: 3}, {Line: 3, Column: 4}},
+		{{Line: 3, Column: 5}, {Line: 3, Column: 6}},
+		{{Line: 3, Column: 6}, {Line: 3, Column: 6}},
+	}
+	for i, tok := range toks {
+		if i >= len(want) || tok.Pos != want[i][0] || tok.End != want[i][1] {
+			t.Errorf("token %d %q: at %s-%s", i, tok.Text, tok.Pos, tok.End)
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	for _, test := range []struct {
+		src, err string
+	}{
+		{"x = 'abc\ny = 1", "test:1: unfinished string near <string>"},
+		{"x = [[abc", "test:1: unfinished long string near <eof>"},
+		{"x = 3e", "test:1: malformed number near <number>"},
+		{"x = '\\q'", "test:1: invalid escape sequence near <string>"},
+	} {
+		toks, err := luac.Tokenize("=test", test.src, 0)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: got error %v, want %s", test.src, err, test.err)
+		}
+		if len(toks) < 2 || toks[0].Text != "x" || toks[1].Text != "=" {
+			t.Errorf("%q: got tokens %v", test.src, toks)
+		}
+	}
+}
+
+func TestTokenizeInvalid(t *testing.T) {
+	const src = "x = 'abc\ny = 3e + 1\n"
+	toks, err := luac.Tokenize("=test", src, luac.Comments|luac.Spaces)
+	var list luac.Diagnostics
+	if !errors.As(err, &list) || len(list) != 2 || list[0].Line != 1 || list[1].Line != 2 {
+		t.Fatalf("got error %v, want errors on lines 1 and 2", err)
+	}
+	var (
+		text    strings.Builder
+		invalid []string
+	)
+	for _, tok := range toks {
+		text.WriteString(tok.Text)
+		if tok.Kind == luac.Invalid {
+			invalid = append(invalid, tok.Text)
+		}
+	}
+	if text.String() != src {
+		t.Errorf("tokens add up to %q, want %q", text.String(), src)
+	}
+	if want := []string{"'abc", "3e"}; !reflect.DeepEqual(invalid, want) {
+		t.Errorf("got invalid tokens %q, want %q", invalid, want)
+	}
+	if last := toks[len(toks)-1]; last.Kind != luac.EOF {
+		t.Errorf("last token is %s %q", last.Kind, last.Text)
+	}
+}
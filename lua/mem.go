@@ -0,0 +1,247 @@
+package lua
+
+import (
+	"errors"
+	goruntime "runtime"
+)
+
+// ErrMemory is the error raised when a runtime exceeds its memory limit
+// (see Config.MemoryLimit).
+var ErrMemory = errors.New("not enough memory")
+
+// Approximate sizes (in bytes) of Lua objects used for memory accounting.
+const (
+	sizeString = 16 // plus the string's length
+	sizeTable  = 64 // plus sizeEntry per array or hash entry
+	sizeEntry  = 48
+	sizeFunc   = 48 // plus sizeUpVar per upvalue
+	sizeUpVar  = 64
+	sizeValue  = 16
+	sizeReg    = 32 // stack slot
+)
+
+// Once over the limit, memory in use is measured again only after another
+// 1/measureRatio of the limit is allocated, so that measuring costs a
+// constant amortized time per byte allocated; in between, allocations are
+// allowed, so memory in use may exceed the limit by this much.
+const measureRatio = 8
+
+// Runtimes with ephemeron tables or objects marked for finalization, which
+// Go's collector cannot handle, measure memory in use (see collect) once
+// the bytes allocated since the last measure reach the memory then in use,
+// or autoMin.
+const autoMin = 1 << 20
+
+type memstats struct {
+	bytes int64 // estimated bytes in use
+	limit int64 // maximum bytes in use; 0 means no limit
+	since int64 // bytes allocated since memory in use was measured
+	auto  bool  // measure as allocations grow (see autoMin)
+}
+
+// Memory returns the estimated number of bytes in use by the runtime.
+func (t *Thread) Memory() int64 {
+	return t.ls.rt.mem.bytes
+}
+
+// Collect performs a full garbage-collection cycle and recomputes the
+// runtime's estimated memory in use. Entries of weak tables whose keys
+// or values are no longer reachable are cleared, and unreachable objects
+// marked for finalization are queued (see RunFinalizers).
+//
+// As with the reference implementation, reachability is decided from the
+// runtime's roots (the registry, the basic types' metatables and the
+// stack): values held only by Go variables are not reachable, and must be
+// stored in the registry to be kept in weak tables.
+func (t *Thread) Collect() {
+	t.ls.flush()
+	t.ls.rt.collect()
+	goruntime.GC()
+}
+
+// Alloc accounts for size bytes allocated by a Go function on behalf of
+// Lua code (e.g. a string built by a library function); it should be
+// called before allocating.
+//
+// Returns ErrMemory if the allocation exceeds the runtime's memory limit.
+func (t *Thread) Alloc(size int) error {
+	return t.ls.rt.alloc(size)
+}
+
+// alloc accounts for size bytes allocated by the runtime. If the limit is
+// exceeded, memory in use is measured again (at most every 1/measureRatio
+// of the limit allocated) and the allocation fails with ErrMemory if it is
+// still exceeded.
+func (rt *runtime) alloc(size int) error {
+	rt.mem.since += int64(size)
+	rt.mem.bytes += int64(size)
+	switch {
+	case rt.mem.limit > 0 && rt.mem.bytes > rt.mem.limit && rt.mem.since >= rt.mem.limit/measureRatio:
+		if rt.collect(); rt.mem.bytes+int64(size) > rt.mem.limit {
+			return ErrMemory
+		}
+		rt.mem.bytes += int64(size)
+	case rt.mem.auto && rt.mem.since >= max(rt.mem.bytes-rt.mem.since, autoMin):
+		rt.collect()
+		rt.mem.bytes += int64(size)
+	}
+	return nil
+}
+
+// collect recomputes the runtime's memory in use as the size of all the
+// objects reachable from its roots: the registry, the basic types'
+// metatables, the values on the threads' stacks and the objects queued
+// for finalization.
+//
+// As with the reference implementation, unreachable objects marked for
+// finalization are queued, and reachable again; the entries of weak tables
+// whose weak values are not reachable are cleared before, and those whose
+// weak keys are not reachable after.
+func (rt *runtime) collect() {
+	m := &meter{seen: make(map[Value]bool)}
+	m.value(rt.values)
+	for _, meta := range rt.types {
+		m.value(meta)
+	}
+	ls := rt.thread
+	m.bytes += int64(len(ls.stack)) * sizeReg
+	for _, r := range ls.stack[:ls.fr.call.top()] {
+		m.value(r.v)
+	}
+	for _, v := range rt.fin.queue {
+		m.value(v)
+	}
+	m.converge()
+	m.clearweak(weakValues)
+	if len(rt.fin.objects) > 0 {
+		rt.separate(m)
+		for _, v := range rt.fin.queue {
+			m.value(v)
+		}
+		m.converge()
+	}
+	m.clearweak(weakKeys)
+	rt.mem.bytes, rt.mem.since = m.bytes, 0
+}
+
+// meter measures the approximate size of a graph of Lua objects, and
+// marks the objects reached.
+//
+// Weak keys and values are not followed, except the values of ephemeron
+// tables (with weak keys and strong values), which are reached once their
+// keys are (see converge).
+type meter struct {
+	seen  map[Value]bool
+	weak  []*Table // weak tables reached
+	bytes int64
+}
+
+func (m *meter) value(v Value) {
+	switch v := v.(type) {
+	case String:
+		m.bytes += sizeString + int64(len(v))
+	case *Table:
+		if v == nil || m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.bytes += sizeTable
+		if v.mode != 0 {
+			m.weak = append(m.weak, v)
+		}
+		mode := v.mode
+		v.foreach(func(k, v Value) bool {
+			m.bytes += sizeEntry
+			if mode&weakKeys == 0 || !collectable(k) {
+				m.value(k)
+			}
+			if mode&weakValues == 0 && m.marked(k) || !collectable(v) {
+				m.value(v)
+			}
+			return true
+		})
+		m.value(v.meta)
+	case *Func:
+		if m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.bytes += sizeFunc
+		m.upvars(v.up)
+	case *GoFunc:
+		if m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.bytes += sizeFunc
+		m.upvars(v.up)
+	case *GoValue:
+		if m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.bytes += sizeValue
+		m.value(v.user)
+		m.value(v.funcs)
+	case *Thread:
+		m.seen[v] = true
+	}
+}
+
+// marked reports whether value v was reached; values which are not
+// collectable always are.
+func (m *meter) marked(v Value) bool {
+	return !collectable(v) || m.seen[v]
+}
+
+// converge marks the values of the ephemeron tables reached whose keys
+// have been marked, until no more objects are marked.
+func (m *meter) converge() {
+	for n := -1; n != len(m.seen); {
+		n = len(m.seen)
+		for _, t := range m.weak {
+			if t.mode != weakKeys {
+				continue
+			}
+			t.foreach(func(k, v Value) bool {
+				if m.marked(k) && !m.marked(v) {
+					m.value(v)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// clearweak removes the entries of the weak tables reached whose weak keys
+// (if mode has weakKeys) or values (if mode has weakValues) were not
+// marked.
+func (m *meter) clearweak(mode weakmode) {
+	for _, t := range m.weak {
+		weak := t.mode & mode
+		t.foreach(func(k, v Value) bool {
+			if weak&weakKeys != 0 && !m.marked(k) || weak&weakValues != 0 && !m.marked(v) {
+				t.Set(k, nil)
+			}
+			return true
+		})
+	}
+}
+
+// collectable reports whether v is an object, which weak tables refer to
+// weakly.
+func collectable(v Value) bool {
+	switch v.(type) {
+	case *Table, *Func, *GoFunc, *GoValue, *Thread:
+		return true
+	}
+	return false
+}
+
+func (m *meter) upvars(ups []*upvar) {
+	for _, up := range ups {
+		if m.bytes += sizeUpVar; up != nil && !up.open {
+			m.value(up.value.v)
+		}
+	}
+}
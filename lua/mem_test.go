@@ -0,0 +1,58 @@
+package lua_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+// limited returns a thread whose runtime may use extra bytes more than a
+// runtime with only the standard libraries.
+func limited(t testing.TB, extra int64) *lua.Thread {
+	return newThread(t, &lua.Config{MemoryLimit: newThread(t, nil).Memory() + extra})
+}
+
+func TestMemoryLimit(t *testing.T) {
+	ls := limited(t, 1<<20)
+	rets, err := run(t, ls, `
+		return pcall(function()
+			local t = {}
+			for i = 1, 1e6 do t[i] = string.rep("x", 100) end
+		end)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rets[0] != lua.False || !strings.Contains(string(rets[1].(lua.String)), "not enough memory") {
+		t.Fatalf("pcall returned %v, want false and a memory error", rets)
+	}
+	// the table is garbage: memory is available again
+	if _, err := run(t, ls, `local t = {}; for i = 1, 100 do t[i] = string.rep("x", 100) end`); err != nil {
+		t.Errorf("allocating after a memory error: %v", err)
+	}
+}
+
+// nearLimit allocates many small objects which are garbage right away,
+// while the memory in use stays close to the limit.
+const nearLimit = `
+	local keep = string.rep("x", 64000)
+	local t
+	for i = 1, 20000 do t = {i, "x" .. i} end
+	return #t
+`
+
+func TestMemoryNearLimit(t *testing.T) {
+	if _, err := run(t, limited(t, 64<<10), nearLimit); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkAllocNearLimit(b *testing.B) {
+	ls := limited(b, 64<<10)
+	for i := 0; i < b.N; i++ {
+		if _, err := run(b, ls, nearLimit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
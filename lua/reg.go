@@ -0,0 +1,201 @@
+package lua
+
+import "math"
+
+// reg is the representation of values held in the registers of the VM
+// (the thread's stack) and in upvalues: integers and floats are stored
+// unboxed, so that the numbers produced by the interpreter need no heap
+// allocation; other values (including nil) are stored in v.
+//
+// Values are converted at the boundaries with the rest of the runtime,
+// e.g. when stored into tables or passed to Go functions.
+type reg struct {
+	v Value
+	n uint64
+	t regtag
+}
+
+type regtag uint8
+
+const (
+	regValue regtag = iota // value in v
+	regInt                 // Int in n
+	regFloat               // Float bits in n
+)
+
+func intreg(i Int) reg     { return reg{n: uint64(i), t: regInt} }
+func floatreg(f Float) reg { return reg{n: math.Float64bits(float64(f)), t: regFloat} }
+
+// toreg returns the register holding v.
+func toreg(v Value) reg {
+	switch v := v.(type) {
+	case Int:
+		return intreg(v)
+	case Float:
+		return floatreg(v)
+	}
+	return reg{v: v}
+}
+
+// value returns the register's value, boxing numbers.
+func (r reg) value() Value {
+	switch r.t {
+	case regInt:
+		return Int(r.n)
+	case regFloat:
+		return Float(math.Float64frombits(r.n))
+	}
+	return r.v
+}
+
+func (r reg) isnil() bool { return r.t == regValue && r.v == nil }
+
+func (r reg) isstring() bool {
+	_, ok := r.v.(String)
+	return ok
+}
+
+func (r reg) truth() bool { return r.t != regValue || Truth(r.v) }
+
+// float returns the register's number as a Float; ok is false if the
+// register does not hold a number.
+func (r reg) float() (f Float, ok bool) {
+	switch r.t {
+	case regInt:
+		return Float(Int(r.n)), true
+	case regFloat:
+		return Float(math.Float64frombits(r.n)), true
+	}
+	return 0, false
+}
+
+// arith performs the arithmetic operation op on numbers without boxing
+// them; ok is false if the operation is left to binary: operands other
+// than numbers, bitwise operations on floats and integer division by 0.
+func arith(op Op, x, y reg) (r reg, ok bool) {
+	if x.t == regInt && y.t == regInt {
+		a, b := Int(x.n), Int(y.n)
+		switch op {
+		case OpAdd, OpSub, OpMul, OpBand, OpBor, OpBxor, OpShl, OpShr:
+			return intreg(intop(op, a, b)), true
+		case OpMod, OpDivI:
+			if b == 0 {
+				return r, false
+			}
+			return intreg(intop(op, a, b)), true
+		case OpDivF, OpPow:
+			return floatreg(numop(op, Float(a), Float(b))), true
+		}
+		return r, false
+	}
+	switch op {
+	case OpBand, OpBor, OpBxor, OpShl, OpShr:
+		return r, false
+	}
+	if a, ok := x.float(); ok {
+		if b, ok := y.float(); ok {
+			return floatreg(numop(op, a, b)), true
+		}
+	}
+	return r, false
+}
+
+// order compares numbers x and y without boxing them; ok is false if
+// the comparison is left to compare (i.e. operands are not numbers).
+func order(op Op, x, y reg) (v, ok bool) {
+	switch {
+	case x.t == regInt && y.t == regInt:
+		a, b := Int(x.n), Int(y.n)
+		switch op {
+		case OpEq:
+			return a == b, true
+		case OpLt:
+			return a < b, true
+		case OpLe:
+			return a <= b, true
+		}
+	case x.t == regFloat && y.t == regFloat:
+		a, b := Float(math.Float64frombits(x.n)), Float(math.Float64frombits(y.n))
+		switch op {
+		case OpEq:
+			return a == b, true
+		case OpLt:
+			return a < b, true
+		case OpLe:
+			return a <= b, true
+		}
+	case x.t == regInt && y.t == regFloat:
+		a, b := Int(x.n), Float(math.Float64frombits(y.n))
+		switch op {
+		case OpEq:
+			return eqIntFloat(a, b), true
+		case OpLt:
+			return ltIntFloat(a, b), true
+		case OpLe:
+			return leIntFloat(a, b), true
+		}
+	case x.t == regFloat && y.t == regInt:
+		a, b := Float(math.Float64frombits(x.n)), Int(y.n)
+		switch op {
+		case OpEq:
+			return eqIntFloat(b, a), true
+		case OpLt:
+			return ltFloatInt(a, b), true
+		case OpLe:
+			return leFloatInt(a, b), true
+		}
+	}
+	return false, false
+}
+
+// Comparisons of integers with floats are exact, as in the reference
+// implementation: the float is not rounded to the nearest integer (nor
+// the integer to the nearest float, which is wrong above 2^53); i < f
+// iff i < ceil(f), i <= f iff i <= floor(f) and so on.
+
+// eqIntFloat reports whether i == f.
+func eqIntFloat(i Int, f Float) bool {
+	n, ok := floatToInt(f, math.Floor)
+	return ok && Float(n) == f && n == i
+}
+
+// ltIntFloat reports whether i < f.
+func ltIntFloat(i Int, f Float) bool {
+	if n, ok := floatToInt(f, math.Ceil); ok {
+		return i < n
+	}
+	return f > 0 // f is out of the integer range (or NaN)
+}
+
+// leIntFloat reports whether i <= f.
+func leIntFloat(i Int, f Float) bool {
+	if n, ok := floatToInt(f, math.Floor); ok {
+		return i <= n
+	}
+	return f > 0
+}
+
+// ltFloatInt reports whether f < i.
+func ltFloatInt(f Float, i Int) bool {
+	if n, ok := floatToInt(f, math.Floor); ok {
+		return n < i
+	}
+	return f < 0
+}
+
+// leFloatInt reports whether f <= i.
+func leFloatInt(f Float, i Int) bool {
+	if n, ok := floatToInt(f, math.Ceil); ok {
+		return n <= i
+	}
+	return f < 0
+}
+
+// floatToInt converts f, rounded to an integral value by round, to an
+// Int; ok is false if the result is out of the integer range or f is NaN.
+func floatToInt(f Float, round func(float64) float64) (n Int, ok bool) {
+	if r := round(float64(f)); r >= math.MinInt64 && r < -math.MinInt64 {
+		return Int(r), true
+	}
+	return 0, false
+}
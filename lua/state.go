@@ -1,8 +1,11 @@
 package lua
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"weak"
 
 	"github.com/Azure/golua/lua/code"
 )
@@ -21,14 +24,21 @@ type (
 		config  *Config
 		thread  *thread
 		values  *Table
+		mem     memstats
 		wait    sync.WaitGroup
 		types   [code.MaxType]*Table
+		icaches []weak.Pointer[icache] // see thread.flush
+		fin     finalizers
 	}
 )
 
 func (rt *runtime) init(config *Config) *thread {
 	fr := &frame{call: &call{flag: mainfunc}}
-	ls := &thread{rt: rt, fr: fr}
+	ls := &thread{rt: rt, fr: fr, stack: make([]reg, stackNew)}
+	ls.limits.quota = config.Budget
+	ls.limits.budget = config.Budget
+	ls.limits.limited = config.Budget != 0
+	rt.mem.limit = config.MemoryLimit
 
 	rt.packages = packages{
 		searchers: NewTable(),
@@ -41,6 +51,11 @@ func (rt *runtime) init(config *Config) *thread {
 	rt.thread = ls
 	config.init(rt)
 
+	// registry
+	rt.values.Set(globals, rt.globals)
+	rt.values.Set(String(loadedKey), rt.loaded)
+	rt.values.Set(String(preloadKey), rt.preload)
+
 	return ls
 }
 
@@ -58,28 +73,123 @@ func (rt *runtime) Config() *Config   { return rt.config }
 
 type thread struct {
 	// co *coroutine
-	rt *runtime
-	tt *Thread
-	fr *frame
+	rt     *runtime
+	tt     *Thread
+	fr     *frame
+	free   *frame
+	stack  []reg   // registers of all active calls
+	argv   []Value // arguments of active Go calls
+	argn   int     // top of argv
+	depth  int     // number of active calls
+	calls  int     // number of nested Go calls
+	hook   hookstate
+	limits limits
+	ctx    context.Context
+	done   <-chan struct{}
+	ticks  uint
 }
 
+// call calls the function value fn with args.
 func (ls *thread) call(fn Value, args []Value, want int) ([]Value, error) {
-	if fn, ok := fn.(callable); ok {
-		ci := new(call).prepare(ls, fn, &args, want)
-		return ci.call(ls, args)
+	return ls.docall(fn, args, want, 0)
+}
+
+// docall calls fn on behalf of Go code (e.g. a Go function or a metamethod
+// event); such calls nest at most maxCalls deep.
+func (ls *thread) docall(fn Value, args []Value, want int, flag callstatus) ([]Value, error) {
+	if ls.calls >= maxCalls {
+		return nil, ls.runtimeError("Go stack overflow")
 	}
-	method := ls.meta(fn, "__call")
-	if method == nil {
-		return nil, fmt.Errorf("attempt to call a nil value")
+	slot := ls.fr.call.top()
+	if err := ls.checkstack(slot + 1 + len(args)); err != nil {
+		return nil, err
+	}
+	ls.stack[slot] = toreg(fn)
+	for i, arg := range args {
+		ls.stack[slot+1+i] = toreg(arg)
+	}
+
+	if ls.calls == 0 { // call from Go: refill the budget
+		ls.limits.budget = ls.limits.quota
 	}
-	return ls.call(method, append([]Value{fn}, args...), want)
+	ls.calls++
+	n, err := ls.invoke(slot, len(args), want, flag)
+	if ls.calls--; err != nil {
+		if len(ls.stack) > stackMax {
+			ls.shrinkstack()
+		}
+		return nil, err
+	}
+	rets := make([]Value, n)
+	for i, r := range ls.stack[slot : slot+n] {
+		rets[i] = r.value()
+	}
+	return rets, nil
+}
+
+// invoke calls the value at stack slot 'slot' with the nargs arguments
+// above it; the results are moved to slot and their number is returned.
+func (ls *thread) invoke(slot, nargs, want int, flag callstatus) (int, error) {
+	for loop := 0; loop < maxMetaLoop; loop++ {
+		if fn, ok := ls.stack[slot].v.(callable); ok {
+			ci, err := ls.prepare(fn, slot, nargs, want)
+			if err != nil {
+				return 0, err
+			}
+			ci.flag |= flag
+			return ci.call(ls)
+		}
+		v := ls.stack[slot].value()
+		method := ls.meta(v, "__call")
+		if method == nil {
+			return 0, fmt.Errorf("attempt to call a %s value", TypeName(v))
+		}
+		// insert the called value as the metamethod's first argument.
+		if err := ls.checkstack(slot + nargs + 2); err != nil {
+			return 0, err
+		}
+		copy(ls.stack[slot+1:], ls.stack[slot:slot+nargs+1])
+		ls.stack[slot] = toreg(method)
+		nargs++
+	}
+	return 0, fmt.Errorf("'__call' chain too long; possible loop")
+}
+
+// args returns the values of stack slots sb..sp as the arguments of a Go
+// function, stored in the thread's argument buffer so that Go calls need
+// no allocation; argn is the buffer's previous top, see release.
+func (ls *thread) args(sb, sp int) (argv []Value, argn int) {
+	argn = ls.argn
+	if n := argn + sp - sb; n > len(ls.argv) {
+		buf := make([]Value, max(2*len(ls.argv), n, stackMin))
+		copy(buf, ls.argv[:argn])
+		ls.argv = buf
+	}
+	ls.argn += sp - sb
+	argv = ls.argv[argn:ls.argn:ls.argn]
+	for i, r := range ls.stack[sb:sp] {
+		argv[i] = r.value()
+	}
+	return argv, argn
+}
+
+// release releases the arguments argv of a Go function, restoring the
+// argument buffer's top to argn.
+func (ls *thread) release(argv []Value, argn int) {
+	clear(argv)
+	ls.argn = argn
 }
 
 func (ls *thread) load(chunk *code.Chunk) *Func {
 	up := make([]*upvar, len(chunk.Main.UpVars))
-	up[0] = &upvar{value: ls.rt.globals}
+	for i := range up {
+		up[i] = new(upvar)
+	}
+	if len(up) > 0 { // (binary chunks may have no _ENV)
+		up[0].value = reg{v: ls.rt.globals}
+	}
 
-	fn := &Func{proto: chunk.Main}
+	fn := &Func{proto: chunk.Main, ic: new(icache)}
 	fn.closure = closure{fn, up}
 	return fn
 }
@@ -101,23 +211,36 @@ func (ls *thread) meta(value Value, event string) Value {
 
 func (ls *thread) typeOf(v Value) *rtype {
 	if m, ok := v.(HasMeta); ok {
-		return &rtype{m.Meta(), v}
+		return &rtype{ls.rt, m.Meta(), v}
 	}
 	if v != nil {
 		meta := ls.rt.types[0x0F&v.kind()]
-		return &rtype{meta, v}
+		return &rtype{ls.rt, meta, v}
 	}
-	return nil
+	return &rtype{ls.rt, ls.rt.types[NilType], nil}
 }
 
 func (ls *thread) error(err error) error {
 	if err == nil {
 		return nil
 	}
-	fmt.Printf("%T\n", err)
 	return err
 }
 
+// runtimeError returns a runtime error with message msg, prefixed with the
+// position of the running function if it is a Lua function, as done by the
+// error function; a call still being set up reports its caller's position.
+func (ls *thread) runtimeError(msg string) error {
+	fr := ls.fr
+	for fr.call.fn == nil && fr.prev != nil {
+		fr = fr.prev
+	}
+	if fr.call.fn == nil {
+		return errors.New(msg)
+	}
+	return errors.New(fr.call.debug("Sl").Where() + msg)
+}
+
 func (ls *thread) caller(skip int) *call {
 	var (
 		fr, ci = ls.fr, ls.fr.call
@@ -133,3 +256,37 @@ func (ls *thread) caller(skip int) *call {
 	}
 	return nil
 }
+
+// checkstack ensures the stack has at least n slots, growing it as
+// needed. Growing it beyond stackMax fails with a "stack overflow"
+// error, leaving errorStackSize slots for handling the error.
+func (ls *thread) checkstack(n int) error {
+	if n <= len(ls.stack) {
+		return nil
+	}
+	if len(ls.stack) > stackMax { // error while handling an overflow?
+		return fmt.Errorf("error in error handling")
+	}
+	if n > stackMax {
+		ls.realloc(errorStackSize)
+		return ls.runtimeError("stack overflow")
+	}
+	ls.realloc(min(max(2*len(ls.stack), n+stackMin), stackMax))
+	return nil
+}
+
+// shrinkstack shrinks a stack grown to handle an overflow once the slots
+// in use fit in stackMax again.
+func (ls *thread) shrinkstack() {
+	if used := ls.fr.call.top(); used <= stackMax {
+		ls.realloc(min(max(used+used/8+2*extraStack, stackNew), stackMax))
+	}
+}
+
+// realloc resizes the stack to size slots; open upvalues refer to the
+// thread's stack and follow the move.
+func (ls *thread) realloc(size int) {
+	stack := make([]reg, size)
+	copy(stack, ls.stack)
+	ls.stack = stack
+}
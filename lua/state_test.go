@@ -0,0 +1,40 @@
+package lua_test
+
+import (
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Lua", `
+			local function f() return f() + 1 end
+			return pcall(f)`, "test:2: stack overflow"},
+		{"Go", `
+			local t = setmetatable({}, {})
+			getmetatable(t).__index = function(t, k) return t[k] end
+			return pcall(function() return t.x end)`, "test:3: Go stack overflow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rets := check(t, tt.src)
+			if rets[0] != lua.False || rets[1] != lua.String(tt.want) {
+				t.Errorf("pcall returned %v, want false, %q", rets, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	rets := check(t, `
+		local function fib(n) if n < 2 then return n end return fib(n-1) + fib(n-2) end
+		return fib(20)`)
+	if rets[0] != lua.Int(6765) {
+		t.Errorf("fib(20) = %v, want 6765", rets[0])
+	}
+}
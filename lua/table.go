@@ -2,22 +2,37 @@ package lua
 
 import (
 	"fmt"
+	"math"
+	"math/bits"
 )
 
-type border int
-
+// Maximum number of bits and size of a table's array part.
 const (
-	borderOk border = iota
-	borderUp
-	borderDown
+	maxABits = 31
+	maxASize = 1 << maxABits
 )
 
+// maxSizeHint bounds the sizes of the parts of tables created by NEWTABLE;
+// the hints of binary chunks are not trusted, and larger tables grow as
+// their keys are inserted.
+const maxSizeHint = 1 << 16
+
+// Table is a Lua table: keys 1..n of a sequence are stored in a dense
+// array part, the other keys in a hash part. Tables whose metatable has
+// a '__mode' field hold their keys and/or values weakly (see weakref).
+//
+// Like the reference implementation, the sizes of both parts are only
+// recomputed (see rehash) when inserting a new key into a full hash part.
+// Assigning to existing keys, including assigning nil, never moves a key
+// so that Next remains valid during traversals.
 type Table struct {
-	kvs  map[Value]entry
-	seqN Int
-	key0 Value
-	flag border
+	arr  []Value         // array part (keys 1..len(arr))
+	kvs  map[Value]entry // hash part (including dead keys)
+	hcap int             // size of the hash part
+	key0 Value           // first key of the hash part's traversal list
 	meta *Table
+	ver  uint64   // version of the hash part, see islot
+	mode weakmode // weak keys and/or values
 }
 
 type entry struct {
@@ -33,15 +48,23 @@ func NewTableFromMap(kvs map[string]Value) *Table {
 	return t
 }
 
+// NewTableSize returns a new table with room for arrN elements in its
+// array part and kvsN elements in its hash part.
 func NewTableSize(arrN, kvsN int) *Table {
-	return &Table{kvs: make(map[Value]entry, kvsN)}
+	t := &Table{}
+	t.resize(arrN, kvsN)
+	return t
 }
 
 func NewTable() *Table { return NewTableSize(0, 0) }
 
-func (t *Table) String() string      { return fmt.Sprintf("table: %p", t) }
-func (t *Table) SetMeta(meta *Table) { t.meta = meta }
-func (t *Table) Meta() *Table        { return t.meta }
+func (t *Table) String() string { return fmt.Sprintf("table: %p", t) }
+func (t *Table) Meta() *Table   { return t.meta }
+
+func (t *Table) SetMeta(meta *Table) {
+	t.meta, t.ver = meta, t.ver+1
+	t.setmode(modeOf(meta))
+}
 
 func (t *Table) Slice() (slice []Value) {
 	var (
@@ -56,88 +79,265 @@ func (t *Table) Slice() (slice []Value) {
 	return slice
 }
 
+// Next returns the key-value pair following key in a traversal of the
+// table; key == nil starts the traversal. The array part is traversed
+// first, in order, followed by the hash part.
+//
+// At the end of the traversal k is nil; ok is false if key is not in
+// the table.
 func (t *Table) Next(key Value) (k, v Value, ok bool) {
-	var e entry
-	if key == nil {
-		k, ok = t.key0, true
-	} else {
-		if e, ok = t.kvs[key]; !ok {
-			return
+	var i int // array index to continue from
+	if key != nil {
+		key = normkey(key)
+		if n, isInt := key.(Int); isInt && n >= 1 && n <= Int(len(t.arr)) {
+			i = int(n)
+		} else {
+			e, found := t.kvs[t.wkey(key)]
+			if !found {
+				return nil, nil, false
+			}
+			k, v = t.nextKey(e.next)
+			return k, v, true
 		}
-		k = e.next
 	}
+	for ; i < len(t.arr); i++ {
+		if v = t.val(t.arr[i]); v != nil {
+			return Int(i + 1), v, true
+		}
+	}
+	k, v = t.nextKey(t.key0)
+	return k, v, true
+}
+
+// nextKey returns the first live key-value pair of the hash part's
+// traversal list starting at key k.
+func (t *Table) nextKey(k Value) (Value, Value) {
 	for k != nil {
-		e = t.kvs[k]
-		if v = e.value; v != nil {
-			return
+		e := t.kvs[k]
+		if t.live(k, e) {
+			return strong(k), strong(e.value)
 		}
 		k = e.next
 	}
-	return
+	return nil, nil
 }
 
+// Length returns a border of the table, i.e. an index n such that t[n]
+// is not nil and t[n+1] is nil (or 0 if t[1] is nil).
 func (t *Table) Length() Int {
-	switch t.flag {
-	case borderDown:
-		for t.seqN > 0 && t.kvs[t.seqN].value == nil {
-			t.seqN--
-		}
-	case borderUp:
-		for t.kvs[t.seqN+1].value != nil {
-			t.seqN++
+	j := len(t.arr)
+	if j > 0 && t.val(t.arr[j-1]) == nil {
+		// binary search for a border in the array part
+		var i int
+		for j-i > 1 {
+			if m := (i + j) / 2; t.val(t.arr[m-1]) == nil {
+				j = m
+			} else {
+				i = m
+			}
 		}
+		return Int(i)
 	}
-	t.flag = borderOk
-	return t.seqN
+	if len(t.kvs) == 0 {
+		return Int(j)
+	}
+	return t.unbound(Int(j))
 }
 
-func (t *Table) Get(k Value) Value {
-	if n, ok := k.(Float); ok {
-		if i := Int(n); Float(i) == n {
-			k = i
+// unbound searches a border in the hash part, above the array part
+// (of size j) which is full.
+func (t *Table) unbound(j Int) Int {
+	i := j
+	j++
+	// find 'i' and 'j' such that t[i] is present and t[j] is absent
+	for t.val(t.kvs[j].value) != nil {
+		i = j
+		if j > math.MaxInt64/2 { // overflow?
+			// table was built with bad purposes: resort to linear search
+			i = 1
+			for t.Get(i) != nil {
+				i++
+			}
+			return i - 1
 		}
+		j *= 2
 	}
-	return t.kvs[k].value
+	// binary search between them
+	for j-i > 1 {
+		if m := (i + j) / 2; t.val(t.kvs[m].value) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
 }
 
-func (t *Table) Set(k, v Value) {
+func (t *Table) Get(k Value) Value {
 	switch k := k.(type) {
+	case Int:
+		if uint64(k-1) < uint64(len(t.arr)) {
+			return t.val(t.arr[k-1])
+		}
 	case Float:
 		if i := Int(k); Float(i) == k {
-			t.setInt(i, v)
-			return
+			return t.Get(i)
 		}
-		t.set(k, v)
-	case Int:
-		t.setInt(k, v)
-	default:
-		t.set(k, v)
 	}
+	return t.val(t.kvs[t.wkey(k)].value)
 }
 
-func (t *Table) setInt(k Int, v Value) {
-	switch {
-	case v == nil && t.seqN > 0 && k == t.seqN:
-		t.flag = borderDown
-		t.seqN--
-	case k > t.seqN && v != nil:
-		t.flag = borderUp
-		t.seqN = k
+func (t *Table) Set(k, v Value) {
+	k = normkey(k)
+	if i, ok := k.(Int); ok && uint64(i-1) < uint64(len(t.arr)) {
+		t.arr[i-1] = t.wval(v)
+		return
+	}
+	t.ver++
+	if e, ok := t.kvs[t.wkey(k)]; ok {
+		e.value = t.wval(v)
+		t.kvs[t.wkey(k)] = e
+		return
+	}
+	if v == nil {
+		return
+	}
+	if len(t.kvs) >= t.hcap { // hash part is full?
+		t.rehash(k)
+		t.Set(k, v)
+		return
 	}
-	t.set(k, v)
+	t.insert(t.wkey(k), t.wval(v))
 }
 
-func (t *Table) set(k, v Value) {
-	entry, ok := t.kvs[k]
-	if v == nil && !ok {
-		return
+// getreg returns the value of the key held in register k.
+func (t *Table) getreg(k reg) Value {
+	if k.t == regInt && t.mode == 0 {
+		if i := Int(k.n); uint64(i-1) < uint64(len(t.arr)) {
+			return t.arr[i-1]
+		}
 	}
-	entry.value = v
-	if !ok {
-		entry.next = t.key0
-		t.key0 = k
+	return t.Get(k.value())
+}
+
+// setreg assigns the value of register v to the key held in register k
+// if the key is present in the array part of a table which is not weak;
+// it reports whether it did.
+func (t *Table) setreg(k, v reg) bool {
+	if k.t == regInt && t.mode == 0 {
+		if i := Int(k.n); uint64(i-1) < uint64(len(t.arr)) && t.arr[i-1] != nil {
+			t.arr[i-1] = v.value()
+			return true
+		}
 	}
-	t.kvs[k] = entry
+	return false
+}
+
+// normkey converts float keys with an integer value to integer keys.
+func normkey(k Value) Value {
+	if f, ok := k.(Float); ok {
+		if i := Int(f); Float(i) == f {
+			return i
+		}
+	}
+	return k
+}
+
+// rehash recomputes the sizes of the table's parts to insert key k: the
+// array part gets the largest size n such that more than half of the
+// slots 1..n would be in use.
+func (t *Table) rehash(k Value) {
+	var (
+		nums  [maxABits + 1]int // nums[i] = number of keys in (2^(i-1), 2^i]
+		na    int               // number of integer keys candidate to the array part
+		total int               // number of keys
+	)
+	for i, v := range t.arr {
+		if t.val(v) != nil {
+			nums[ceilLog2(i+1)]++
+			na++
+		}
+	}
+	total = na
+	for key, e := range t.kvs {
+		if t.live(key, e) {
+			na += countint(key, &nums)
+			total++
+		}
+	}
+	na += countint(k, &nums)
+	total++
+
+	asize, na := computesizes(&nums, na)
+	t.resize(asize, total-na)
+}
+
+// countint counts key k in nums if it is a candidate to the array part.
+func countint(k Value, nums *[maxABits + 1]int) int {
+	if i, ok := k.(Int); ok && i > 0 && i <= maxASize {
+		nums[ceilLog2(int(i))]++
+		return 1
+	}
+	return 0
+}
+
+// computesizes returns the optimal size of the array part for the na
+// integer keys counted in nums, and the number of keys going into it.
+func computesizes(nums *[maxABits + 1]int, na int) (optimal, n int) {
+	var a int // number of keys smaller than 2^i
+	for i, twotoi := 0, 1; i <= maxABits && na > twotoi/2; i, twotoi = i+1, twotoi*2 {
+		if nums[i] > 0 {
+			if a += nums[i]; a > twotoi/2 { // more than half the slots in use?
+				optimal, n = twotoi, a
+			}
+		}
+	}
+	return optimal, n
+}
+
+// resize resizes the array part to asize slots and the hash part to
+// the smallest power of 2 holding hsize keys, migrating the elements
+// between the parts; dead keys are dropped.
+func (t *Table) resize(asize, hsize int) {
+	var (
+		arr  = t.arr
+		kvs  = t.kvs
+		key0 = t.key0
+	)
+	if hsize > 0 {
+		hsize = 1 << ceilLog2(hsize)
+	}
+	t.hcap, t.key0 = hsize, nil
+	t.kvs = make(map[Value]entry, hsize)
+	if asize != len(arr) {
+		t.arr = make([]Value, asize)
+		copy(t.arr, arr)
+	}
+	for i := asize; i < len(arr); i++ { // move vanishing slots to the hash part
+		if t.val(arr[i]) != nil {
+			t.insert(Int(i+1), arr[i])
+		}
+	}
+	// re-insert the live hash keys, keeping the order of their traversal
+	var keys []Value
+	for k := key0; k != nil; k = kvs[k].next {
+		if t.live(k, kvs[k]) {
+			keys = append(keys, k)
+		}
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		t.insert(keys[i], kvs[keys[i]].value)
+	}
+}
+
+// insert stores the new key k without checking the size of the hash part.
+func (t *Table) insert(k, v Value) {
+	if i, ok := k.(Int); ok && uint64(i-1) < uint64(len(t.arr)) {
+		t.arr[i-1] = v
+		return
+	}
+	t.kvs[k] = entry{value: v, next: t.key0}
+	t.key0 = k
 }
 
 func (t *Table) foreach(fn func(k, v Value) bool) {
@@ -149,6 +349,11 @@ func (t *Table) foreach(fn func(k, v Value) bool) {
 	}
 }
 
+// ceilLog2 returns ceil(log2(x)) for x > 0.
+func ceilLog2(x int) int {
+	return bits.Len(uint(x - 1))
+}
+
 // fb2int converts a "floating point byte" back to an integer.
 func fb2int(x int) int {
 	e := (x >> 3) & 0x1F
@@ -0,0 +1,186 @@
+package lua
+
+import (
+	"fmt"
+	"testing"
+)
+
+// parts returns the sizes of the array and hash parts of table t.
+func parts(t *Table) (arr, hash int) { return len(t.arr), len(t.kvs) }
+
+func TestTableParts(t *testing.T) {
+	// keys 4, 3, 2 go to the hash part until more than half of 1..4 is used
+	tt := NewTable()
+	for _, k := range []Int{4, 3} {
+		tt.Set(k, k)
+	}
+	if arr, hash := parts(tt); arr != 0 || hash != 2 {
+		t.Errorf("keys 4, 3: got parts %d, %d; want 0, 2", arr, hash)
+	}
+	tt.Set(Int(2), Int(2))
+	if arr, hash := parts(tt); arr != 4 || hash != 0 {
+		t.Errorf("keys 4, 3, 2: got parts %d, %d; want 4, 0", arr, hash)
+	}
+
+	// a sparse array part moves its keys to the hash part on rehash
+	tt = NewTableSize(8, 0)
+	for k := Int(1); k <= 8; k++ {
+		tt.Set(k, k)
+	}
+	for k := Int(1); k < 8; k++ {
+		tt.Set(k, nil)
+	}
+	tt.Set(String("x"), True)
+	if arr, hash := parts(tt); arr != 0 || hash != 2 {
+		t.Errorf("sparse array: got parts %d, %d; want 0, 2", arr, hash)
+	}
+	if v := tt.Get(Int(8)); v != Int(8) {
+		t.Errorf("t[8] = %v after moving to the hash part", v)
+	}
+
+	// float keys with an integer value are integer keys
+	tt = NewTable()
+	tt.Set(Float(1), String("a"))
+	if v := tt.Get(Int(1)); v != String("a") {
+		t.Errorf("t[1] = %v after t[1.0] = a", v)
+	}
+	if k, _, _ := tt.Next(nil); k != Int(1) {
+		t.Errorf("key of t[1.0]: got %v (%T), want 1", k, k)
+	}
+}
+
+func TestTableRehash(t *testing.T) {
+	tt := NewTable()
+	for k := Int(1); k <= 1000; k++ {
+		tt.Set(k, k)
+		if arr, hash := parts(tt); hash != 0 || arr < int(k) || arr >= 2*int(k) {
+			t.Fatalf("after t[%d]: got parts %d, %d", k, arr, hash)
+		}
+	}
+	if n := tt.Length(); n != 1000 {
+		t.Errorf("#t = %d, want 1000", n)
+	}
+
+	// the hash part grows by powers of 2
+	tt = NewTable()
+	for i := 1; i <= 100; i++ {
+		tt.Set(String(fmt.Sprint("k", i)), Int(i))
+		if want := 1 << ceilLog2(i); tt.hcap != want {
+			t.Fatalf("after %d keys: hash part of size %d, want %d", i, tt.hcap, want)
+		}
+	}
+	for i := 1; i <= 100; i++ {
+		if v := tt.Get(String(fmt.Sprint("k", i))); v != Int(i) {
+			t.Errorf("t.k%d = %v after rehashes", i, v)
+		}
+	}
+}
+
+func TestNewTableSize(t *testing.T) {
+	tt := NewTableSize(10, 5)
+	if arr, hcap := len(tt.arr), tt.hcap; arr != 10 || hcap != 8 {
+		t.Fatalf("NewTableSize(10, 5): got parts %d, %d; want 10, 8", arr, hcap)
+	}
+	arr := &tt.arr[0]
+	for k := Int(1); k <= 10; k++ {
+		tt.Set(k, k)
+	}
+	for i := 0; i < 8; i++ {
+		tt.Set(String(fmt.Sprint("k", i)), True)
+	}
+	if &tt.arr[0] != arr || tt.hcap != 8 {
+		t.Errorf("table presized for its keys was rehashed")
+	}
+	tt.Set(String("k8"), True)
+	if tt.hcap != 16 {
+		t.Errorf("hash part of size %d after 9 keys, want 16", tt.hcap)
+	}
+}
+
+func TestTableNext(t *testing.T) {
+	tt := NewTableSize(4, 0)
+	want := make(map[Value]bool)
+	for i := 1; i <= 4; i++ {
+		tt.Set(Int(i), Int(i))
+		want[Int(i)] = true
+	}
+	for i := 0; i < 20; i++ {
+		tt.Set(String(fmt.Sprint("k", i)), Int(i))
+		want[String(fmt.Sprint("k", i))] = true
+	}
+	tt.Set(Float(0.5), True)
+	want[Float(0.5)] = true
+
+	// assigning existing keys, including nil, does not disturb the traversal
+	seen := make(map[Value]bool)
+	var n int
+	for k, v, ok := tt.Next(nil); k != nil; k, v, ok = tt.Next(k) {
+		if !ok {
+			t.Fatalf("Next(%v): key not found", k)
+		}
+		if seen[k] {
+			t.Fatalf("key %v seen twice", k)
+		}
+		seen[k] = true
+		if n++; n%2 == 0 {
+			tt.Set(k, nil)
+		} else {
+			tt.Set(k, v)
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("traversed %d keys, want %d", len(seen), len(want))
+	}
+	for k := range want {
+		if !seen[k] {
+			t.Errorf("key %v not traversed", k)
+		}
+	}
+	if _, _, ok := tt.Next(String("missing")); ok {
+		t.Errorf("Next of a missing key: got ok")
+	}
+}
+
+func TestTableLength(t *testing.T) {
+	tests := []struct {
+		name string
+		make func() *Table
+	}{
+		{"Empty", NewTable},
+		{"Array", func() *Table { return seq(NewTableSize(8, 0), 8) }},
+		{"ArrayHoles", func() *Table {
+			tt := seq(NewTableSize(8, 0), 8)
+			tt.Set(Int(3), nil)
+			tt.Set(Int(8), nil)
+			return tt
+		}},
+		{"Hash", func() *Table { return seq(NewTableSize(0, 16), 10) }},
+		{"ArrayAndHash", func() *Table {
+			tt := seq(NewTableSize(4, 16), 10)
+			tt.Set(String("x"), True)
+			return tt
+		}},
+		{"HashHoles", func() *Table {
+			tt := seq(NewTableSize(2, 16), 12)
+			tt.Set(Int(5), nil)
+			return tt
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := tt.make()
+			n := tbl.Length()
+			if (n != 0 && tbl.Get(n) == nil) || tbl.Get(n+1) != nil {
+				t.Errorf("#t = %d is not a border", n)
+			}
+		})
+	}
+}
+
+// seq assigns t[i] = i for i in 1..n and returns t.
+func seq(t *Table, n int) *Table {
+	for i := 1; i <= n; i++ {
+		t.Set(Int(i), Int(i))
+	}
+	return t
+}
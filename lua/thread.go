@@ -95,3 +95,67 @@ func (t *Thread) Caller(level int) Debug {
 	}
 	return nil
 }
+
+// Info returns the debug information, selected by the option letters
+// in 'what' (see debug.getinfo), for the function running at the given
+// level of the call stack; level 0 is the current running function.
+//
+// Returns nil if level is greater than the stack depth.
+func (t *Thread) Info(level int, what string) Debug {
+	if ci := t.ls.caller(level); ci != nil {
+		return ci.debug(what)
+	}
+	return nil
+}
+
+// FuncInfo returns the debug information, selected by the option letters
+// in 'what', for the function value fn; options referring to an active
+// call (e.g. "l", "n" and "t") are not available.
+func (t *Thread) FuncInfo(fn Value, what string) Debug {
+	dbg := &debug{fn: fn}
+	return dbg.options(what)
+}
+
+// Local returns the name and the value of the n'th local variable of the
+// function running at the given level of the call stack.
+//
+// Negative n refers to the function's vararg values. Returns an empty
+// name if there is no such level or variable.
+func (t *Thread) Local(level, n int) (string, Value) {
+	if ci := t.ls.caller(level); ci != nil {
+		if name, slot := ci.local(t.ls, n); slot != nil {
+			return name, slot.value()
+		}
+	}
+	return "", nil
+}
+
+// SetLocal assigns value to the n'th local variable of the function running
+// at the given level of the call stack and returns the variable's name.
+//
+// Returns an empty name if there is no such level or variable.
+func (t *Thread) SetLocal(level, n int, value Value) string {
+	if ci := t.ls.caller(level); ci != nil {
+		if name, slot := ci.local(t.ls, n); slot != nil {
+			*slot = toreg(value)
+			return name
+		}
+	}
+	return ""
+}
+
+// LocalName returns the name of the n'th parameter of function fn;
+// only parameters of Lua functions have names.
+func (t *Thread) LocalName(fn Value, n int) string {
+	if fn, ok := fn.(*Func); ok {
+		return localName(fn.proto, 0, n)
+	}
+	return ""
+}
+
+// Traceback returns a traceback of the call stack starting at level.
+//
+// If msg is not empty it is prepended to the traceback.
+func (t *Thread) Traceback(msg string, level int) string {
+	return t.ls.traceback(msg, level)
+}
@@ -9,19 +9,45 @@ type Type interface {
 	Method(name string) Callable
 	Kind() code.Type
 	Name() string
+	Meta() *Table
 }
 
 type rtype struct {
+	rt *runtime
 	mt *Table
 	tv Value
 }
 
+// SetMeta sets the metatable for the type's value; values that do not
+// carry their own metatable share one metatable per basic type.
+//
+// Tables and userdata are marked for finalization if the metatable has a
+// '__gc' field.
+//
+// Returns the previous metatable.
 func (t *rtype) SetMeta(funcs *Table) (prev *Table) {
-	// if t != nil && t.mt != nil {
-	// 	prev = metatypes[t.tv.kind()]
-	// 	metatypes[t.tv.kind()] = funcs
-	// 	return prev
-	// }
+	if prev = t.mt; t.tv != nil {
+		if v, ok := t.tv.(HasMeta); ok {
+			v.SetMeta(funcs)
+			t.mt = funcs
+			if funcs != nil && funcs.Get(String(_gc.String())) != nil {
+				t.rt.mark(t.tv)
+			}
+			if tbl, ok := t.tv.(*Table); ok && tbl.mode == weakKeys {
+				t.rt.mem.auto = true // ephemerons need collections
+			}
+			return prev
+		}
+	}
+	t.rt.types[0x0F&t.Kind()] = funcs
+	t.mt = funcs
+	return prev
+}
+
+func (t *rtype) Meta() *Table {
+	if t != nil {
+		return t.mt
+	}
 	return nil
 }
 
@@ -24,24 +24,42 @@ type (
 )
 
 const (
-	NilType    = code.NilType
-	BoolType   = code.BoolType
-	NumberType = code.NumberType
-	StringType = code.StringType
-	TableType  = code.TableType
-	FuncType   = code.FuncType
-	GoType     = code.GoType
-	ThreadType = code.ThreadType
-	IntType    = code.IntType
-	FloatType  = code.FloatType
+	NilType     = code.NilType
+	BoolType    = code.BoolType
+	PointerType = code.PointerType
+	NumberType  = code.NumberType
+	StringType  = code.StringType
+	TableType   = code.TableType
+	FuncType    = code.FuncType
+	GoType      = code.GoType
+	ThreadType  = code.ThreadType
+	IntType     = code.IntType
+	FloatType   = code.FloatType
 )
 
 type GoValue struct {
 	Value interface{}
 	funcs *Table
+	user  Value
 }
 
-func (v *GoValue) String() string { return fmt.Sprintf("userdata: %p", v) }
+func (v *GoValue) String() string      { return fmt.Sprintf("userdata: %p", v) }
+func (v *GoValue) SetMeta(meta *Table) { v.funcs = meta }
+func (v *GoValue) Meta() *Table        { return v.funcs }
+
+// UserValue returns the Lua value associated with the userdata.
+func (v *GoValue) UserValue() Value { return v.user }
+
+// SetUserValue associates the Lua value user with the userdata.
+func (v *GoValue) SetUserValue(user Value) { v.user = user }
+
+// Pointer represents a light userdata value; that is, a bare
+// Go pointer that is compared by identity.
+type Pointer struct {
+	ptr interface{}
+}
+
+func (v Pointer) String() string { return fmt.Sprintf("userdata: %p", v.ptr) }
 
 func (v *closure) Type(t *Thread) Type { return t.ls.typeOf(v) }
 func (v *GoValue) Type(t *Thread) Type { return t.ls.typeOf(v) }
@@ -51,6 +69,7 @@ func (v String) Type(t *Thread) Type   { return t.ls.typeOf(v) }
 func (v Float) Type(t *Thread) Type    { return t.ls.typeOf(v) }
 func (v Int) Type(t *Thread) Type      { return t.ls.typeOf(v) }
 func (v Bool) Type(t *Thread) Type     { return t.ls.typeOf(v) }
+func (v Pointer) Type(t *Thread) Type  { return t.ls.typeOf(v) }
 
 func (*closure) kind() code.Type { return FuncType }
 func (*GoValue) kind() code.Type { return GoType }
@@ -60,3 +79,4 @@ func (String) kind() code.Type   { return StringType }
 func (Float) kind() code.Type    { return FloatType }
 func (Int) kind() code.Type      { return IntType }
 func (Bool) kind() code.Type     { return BoolType }
+func (Pointer) kind() code.Type  { return PointerType }
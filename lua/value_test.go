@@ -0,0 +1,74 @@
+package lua_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestValues(t *testing.T) {
+	rets := check(t, `
+		local x, y = 7, 2.0
+		local t = {x, y, x // 2, x / 2, x % 3, -x, x << 62, math.maxinteger + 1}
+		return x + 1, y + 1, x // 2, x / 2, x % 3, x << 62, math.maxinteger + 1,
+			x == 7.0, x < y, nil, "s" .. x, t[1] + t[2], t[#t] == math.mininteger
+	`)
+	want := []lua.Value{
+		lua.Int(8), lua.Float(3), lua.Int(3), lua.Float(3.5), lua.Int(1),
+		lua.Int(-0x4000000000000000), lua.Int(-0x8000000000000000),
+		lua.True, lua.False, nil, lua.String("s7"), lua.Float(9), lua.True,
+	}
+	if len(rets) != len(want) {
+		t.Fatalf("got %d values, want %d", len(rets), len(want))
+	}
+	for i, v := range rets {
+		if v != want[i] {
+			t.Errorf("value %d: got %v (%T), want %v (%T)", i+1, v, v, want[i], want[i])
+		}
+	}
+}
+
+func TestCompareIntFloat(t *testing.T) {
+	const (
+		p53  = lua.Int(1 << 53)
+		p63f = lua.Float(1 << 63)
+	)
+	nan := lua.Float(math.NaN())
+	tests := []struct {
+		x, y       lua.Value
+		eq, lt, le bool
+	}{
+		{lua.Int(1), lua.Float(1), true, false, true},
+		{lua.Int(1), lua.Float(1.5), false, true, true},
+		{lua.Float(1.5), lua.Int(1), false, false, false},
+		{p53 + 1, lua.Float(p53), false, false, false},
+		{lua.Float(p53), p53 + 1, false, true, true},
+		{lua.Int(math.MaxInt64), p63f, false, true, true},
+		{p63f, lua.Int(math.MaxInt64), false, false, false},
+		{lua.Int(math.MinInt64), -p63f, true, false, true},
+		{lua.Int(math.MinInt64), lua.Float(math.Inf(-1)), false, false, false},
+		{lua.Int(0), nan, false, false, false},
+		{nan, lua.Int(0), false, false, false},
+	}
+	ls := newThread(t, nil)
+	cmp, err := run(t, ls, `return function(x, y) return x == y, x < y, x <= y end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		want := []lua.Value{lua.Bool(tt.eq), lua.Bool(tt.lt), lua.Bool(tt.le)}
+		rets, err := ls.Call(cmp[0], tt.x, tt.y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, op := range []lua.Op{lua.OpEq, lua.OpLt, lua.OpLe} {
+			if rets[i] != want[i] {
+				t.Errorf("%v %v %v (%T, %T): got %v in Lua", tt.x, op, tt.y, tt.x, tt.y, rets[i])
+			}
+			if v, err := lua.Compare(ls, op, tt.x, tt.y); err != nil || lua.Bool(v) != want[i] {
+				t.Errorf("Compare(%v, %v, %v): got %v, %v", op, tt.x, tt.y, v, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package lua
+
+import (
+	"strings"
+	"weak"
+
+	"github.com/Azure/golua/lua/code"
+)
+
+// weakmode is the mode of a weak table, set by the '__mode' field of its
+// metatable: a table may have weak keys ("k"), weak values ("v") or both.
+type weakmode uint8
+
+const (
+	weakKeys weakmode = 1 << iota
+	weakValues
+)
+
+// modeOf returns the weak mode of tables with metatable meta.
+//
+// Like the reference implementation, the mode is read when the metatable
+// is set; changing the '__mode' field of a metatable in use has no effect.
+func modeOf(meta *Table) (mode weakmode) {
+	if meta == nil {
+		return 0
+	}
+	if s, ok := meta.Get(String(_mode.String())).(String); ok {
+		if strings.ContainsRune(string(s), 'k') {
+			mode |= weakKeys
+		}
+		if strings.ContainsRune(string(s), 'v') {
+			mode |= weakValues
+		}
+	}
+	return mode
+}
+
+// weakref is a weak reference to a collectable value (table, function,
+// userdata or thread) held as a key or value by a weak table.
+//
+// Weak references are cleared by the Go garbage collector once their
+// object is no longer reachable from Lua or Go; their entries are then
+// absent from the table, and dropped the next time it is resized.
+//
+// Since the Go collector has no notion of ephemerons, a value strongly
+// referring to its own weak key keeps the entry alive until the runtime
+// measures its memory in use, which clears entries whose keys are only
+// reachable from their values (see runtime.collect).
+type weakref[T any] struct {
+	p weak.Pointer[T]
+}
+
+// weakValue is implemented by all the weakref types.
+type weakValue interface {
+	Value
+	strong() Value
+}
+
+func (w weakref[T]) strong() Value {
+	if p := w.p.Value(); p != nil {
+		return any(p).(Value)
+	}
+	return nil
+}
+
+// weakrefs are never exposed out of tables.
+func (w weakref[T]) String() string      { return "weakref" }
+func (w weakref[T]) Type(t *Thread) Type { return t.ls.typeOf(w.strong()) }
+func (w weakref[T]) kind() code.Type     { return NilType }
+
+// weaken returns a weak reference to v if v is collectable, or v.
+func weaken(v Value) Value {
+	switch v := v.(type) {
+	case *Table:
+		return weakref[Table]{weak.Make(v)}
+	case *Func:
+		return weakref[Func]{weak.Make(v)}
+	case *GoFunc:
+		return weakref[GoFunc]{weak.Make(v)}
+	case *GoValue:
+		return weakref[GoValue]{weak.Make(v)}
+	case *Thread:
+		return weakref[Thread]{weak.Make(v)}
+	}
+	return v
+}
+
+// strong returns the value referred to by v if v is a weak reference
+// (nil once collected), or v.
+func strong(v Value) Value {
+	if w, ok := v.(weakValue); ok {
+		return w.strong()
+	}
+	return v
+}
+
+// wkey returns key k as stored in the table's hash part.
+func (t *Table) wkey(k Value) Value {
+	if t.mode&weakKeys != 0 {
+		return weaken(k)
+	}
+	return k
+}
+
+// wval returns value v as stored in the table.
+func (t *Table) wval(v Value) Value {
+	if t.mode&weakValues != 0 {
+		return weaken(v)
+	}
+	return v
+}
+
+// val returns the value of the stored value v (nil if collected).
+func (t *Table) val(v Value) Value {
+	if t.mode&weakValues != 0 {
+		return strong(v)
+	}
+	return v
+}
+
+// live reports whether the entry of stored key k is live, i.e. neither
+// removed nor collected.
+func (t *Table) live(k Value, e entry) bool {
+	if t.mode == 0 {
+		return e.value != nil
+	}
+	return strong(k) != nil && strong(e.value) != nil
+}
+
+// setmode changes the table's weak mode, converting its entries.
+func (t *Table) setmode(mode weakmode) {
+	if mode == t.mode {
+		return
+	}
+	var keys, values []Value
+	for k, v, _ := t.Next(nil); k != nil; k, v, _ = t.Next(k) {
+		keys, values = append(keys, k), append(values, v)
+	}
+	t.mode, t.key0 = mode, nil
+	clear(t.arr)
+	clear(t.kvs)
+	for i := len(keys) - 1; i >= 0; i-- { // (keep the traversal order)
+		t.insert(t.wkey(keys[i]), t.wval(values[i]))
+	}
+}
+
+// flush drops the runtime's strong references to values that are no
+// longer in use, so that a garbage collection clears the weak references
+// to values only reachable from them: stale stack slots, recycled frames
+// and inline caches.
+func (ls *thread) flush() {
+	clear(ls.stack[ls.fr.call.top():])
+	for fr := ls.free; fr != nil; fr = fr.prev {
+		*fr.call = call{}
+	}
+	ls.rt.sweep(true)
+}
+
+// track registers the inline caches ic, to be flushed on collections.
+func (rt *runtime) track(ic *icache) {
+	if len(rt.icaches) == cap(rt.icaches) {
+		rt.sweep(false)
+	}
+	rt.icaches = append(rt.icaches, weak.Make(ic))
+}
+
+// sweep drops the collected inline caches from the runtime's list and
+// clears the others if flush is set.
+func (rt *runtime) sweep(flush bool) {
+	ics := rt.icaches[:0]
+	for _, p := range rt.icaches {
+		if ic := p.Value(); ic != nil {
+			if flush {
+				clear(ic.slots)
+			}
+			ics = append(ics, p)
+		}
+	}
+	clear(rt.icaches[len(ics):])
+	rt.icaches = ics
+}
@@ -0,0 +1,89 @@
+package lua_test
+
+import (
+	"testing"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want lua.Value
+	}{
+		{"String", `return #"abc"`, lua.Int(3)},
+		{"Table", `return #{1, 2, 3}`, lua.Int(3)},
+		{"WeakKeys", `return #setmetatable({1, 2, 3}, {__mode = "k"})`, lua.Int(3)},
+		{"WeakValues", `return #setmetatable({1, 2}, {__mode = "v"})`, lua.Int(2)},
+		{"Metatable", `return #setmetatable({1}, {})`, lua.Int(1)},
+		{"Len", `return #setmetatable({}, {__len = function(t) return 42 end})`, lua.Int(42)},
+		{"Error", `local ok, err = pcall(function() return #5 end); return err`,
+			lua.String("attempt to get length of a number value")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rets := check(t, tt.src); rets[0] != tt.want {
+				t.Errorf("got %v, want %v", rets[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestWeakTables(t *testing.T) {
+	rets := check(t, `
+		local keys = setmetatable({}, {__mode = "k"})
+		local values = setmetatable({}, {__mode = "v"})
+		local k, v = {}, {}
+		keys[k], keys[{}] = 1, 2
+		values[1], values[2] = v, {}
+		collectgarbage()
+		local n = 0
+		for _ in pairs(keys) do n = n + 1 end
+		return n, keys[k], values[1] == v, values[2]
+	`)
+	want := []lua.Value{lua.Int(1), lua.Int(1), lua.True, nil}
+	for i, v := range want {
+		if rets[i] != v {
+			t.Errorf("value %d: got %v, want %v", i+1, rets[i], v)
+		}
+	}
+}
+
+func TestEphemerons(t *testing.T) {
+	rets := check(t, `
+		local t = setmetatable({}, {__mode = "k"})
+		local k = {}
+		t[k] = {k}
+		do
+			local k1, k2 = {}, {}
+			t[k1], t[k2] = {k2}, {k1} -- entries referring to each other's key
+			t[{}] = function() return t end
+		end
+		t[t] = {}
+		collectgarbage()
+		local n = 0
+		for _ in pairs(t) do n = n + 1 end
+		return n, t[k][1] == k
+	`)
+	if rets[0] != lua.Int(2) || rets[1] != lua.True {
+		t.Errorf("got %v, want 2 entries, true", rets)
+	}
+}
+
+// Ephemeron tables are cleared without explicit collections.
+func TestEphemeronsAuto(t *testing.T) {
+	rets := check(t, `
+		local t = setmetatable({}, {__mode = "k"})
+		for i = 1, 50000 do
+			local k = {}
+			t[k] = {k, string.rep("x", 100)}
+		end
+		local n = 0
+		for _ in pairs(t) do n = n + 1 end
+		return n
+	`)
+	if n := rets[0].(lua.Int); n >= 50000 {
+		t.Errorf("%d entries left, want less than 50000", n)
+	}
+}